@@ -0,0 +1,162 @@
+package blobstore
+
+import (
+	"Pelmenner/TransferBot/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	ErrTooLarge = fmt.Errorf("file is larger than %d bytes", config.AttachmentMaxSize)
+	ErrNotFound = errors.New("no such blob")
+)
+
+// Index keeps track of the stored files and of the messages referring to them
+type Index interface {
+	RegisterBlob(hash string, size int64) error
+	PinBlob(hash string, until time.Time) (bool, error)
+	CollectBlobs(gracePeriod time.Duration, remove func(hash string) error) (int, error)
+}
+
+// Backend keeps contents of the files in a storage
+type Backend interface {
+	// Put saves the local file under the key, the local file may be moved by it
+	Put(key string, path string) error
+	// Open returns contents of the file saved under the key or ErrNotFound
+	Open(key string) (io.ReadCloser, error)
+	// Remove removes the file saved under the key, it is not an error if there is no such file
+	Remove(key string) error
+	// DownloadURL returns a link the file can be downloaded by directly during the given time.
+	// An empty link is returned if the storage does not support it
+	DownloadURL(key string, name string, expiry time.Duration) (string, error)
+}
+
+// Store keeps attachment files under their SHA-256 hashes, so that the same file is stored once
+// no matter how many messages it is attached to. A file is removed when no queued message refers to it
+type Store struct {
+	backend Backend
+	index   Index
+	// tempDir is where the uploaded files are kept until their hashes are known
+	tempDir string
+}
+
+func New(backend Backend, index Index) *Store {
+	return &Store{backend: backend, index: index, tempDir: config.AttachmentsDir}
+}
+
+// NewBackend creates the backend chosen by the configuration
+func NewBackend() (Backend, error) {
+	switch config.BlobStorage {
+	case "", "local":
+		return NewLocalBackend(config.AttachmentsDir), nil
+	case "s3":
+		return NewS3Backend(config.S3)
+	default:
+		return nil, fmt.Errorf("unknown blob storage %s", config.BlobStorage)
+	}
+}
+
+// Put saves the file read from the reader and returns its key
+func (s *Store) Put(reader io.Reader) (string, error) {
+	if err := os.MkdirAll(s.tempDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(s.tempDir, "upload-*")
+	if err != nil {
+		return "", err
+	}
+	// the file may be moved by the backend, removing it is a no-op then
+	defer os.Remove(file.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(reader, config.AttachmentMaxSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if size > config.AttachmentMaxSize {
+		return "", ErrTooLarge
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+	// the blob is registered before it is saved, so that a concurrent removal of the same blob
+	// finishes before the file is there
+	if err = s.index.RegisterBlob(key, size); err != nil {
+		return "", fmt.Errorf("could not register blob: %w", err)
+	}
+	if err = s.backend.Put(key, file.Name()); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Open returns contents of the file stored under the key
+func (s *Store) Open(key string) (io.ReadCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrNotFound
+	}
+	return s.backend.Open(key)
+}
+
+// DownloadURL returns a temporary link to download the file stored under the key directly from the storage.
+// An empty link is returned if the storage does not support it, the file should be downloaded with Open then
+func (s *Store) DownloadURL(key string, name string) string {
+	if !ValidKey(key) {
+		return ""
+	}
+	url, err := s.backend.DownloadURL(key, name, time.Second*config.DownloadURLExpirySec)
+	if err != nil {
+		log.Printf("could not create download url for blob %s: %v", key, err)
+		return ""
+	}
+	return url
+}
+
+// Pin keeps the file stored under the key at least until the given time, e.g. while links to it are valid
+func (s *Store) Pin(key string, until time.Time) error {
+	if !ValidKey(key) {
+		return ErrNotFound
+	}
+	exists, err := s.index.PinBlob(key, until)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Collect removes files which are not referred to by queued messages for longer than the grace period
+func (s *Store) Collect() {
+	cnt, err := s.index.CollectBlobs(time.Second*config.BlobGracePeriodSec, s.remove)
+	if err != nil {
+		log.Printf("could not collect unused blobs: %v", err)
+	} else if cnt > 0 {
+		log.Printf("removed %d unused blobs", cnt)
+	}
+}
+
+func (s *Store) remove(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid key %s", key)
+	}
+	return s.backend.Remove(key)
+}
+
+// ValidKey checks that the key is a hex-encoded SHA-256 hash
+func ValidKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
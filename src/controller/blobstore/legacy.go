@@ -0,0 +1,96 @@
+package blobstore
+
+import (
+	"Pelmenner/TransferBot/config"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// LegacyIndex finds attachments saved before the blob store. Their urls are paths of their files instead of keys:
+// absolute paths in the downloads directory shared by the services or paths relative to the attachments directory
+type LegacyIndex interface {
+	// GetLegacyAttachmentURLs returns the urls along with the number of queued messages referring to each of them
+	GetLegacyAttachmentURLs() (map[string]int, error)
+	ReplaceLegacyAttachment(url string, hash string) error
+	RemoveLegacyAttachment(url string) error
+}
+
+// ImportLegacyFiles moves files of the legacy attachments still referred to by queued messages to the store
+// and removes the rest of them, so that they are not left on disk forever.
+// Attachments whose files are missing are left as they are, their messages fail to be delivered
+func (s *Store) ImportLegacyFiles(index LegacyIndex) error {
+	urls, err := index.GetLegacyAttachmentURLs()
+	if err != nil {
+		return err
+	}
+	imported, removed := 0, 0
+	for url, references := range urls {
+		path, root, valid := s.legacyPath(url)
+		if !valid {
+			log.Printf("invalid url of legacy attachment %s", url)
+			continue
+		}
+		if references == 0 {
+			if err = index.RemoveLegacyAttachment(url); err != nil {
+				return err
+			}
+			s.removeLegacyFile(path, root)
+			removed++
+			continue
+		}
+		key, err := s.putFile(path)
+		if err != nil {
+			log.Printf("could not import legacy attachment %s: %v", url, err)
+			continue
+		}
+		if err = index.ReplaceLegacyAttachment(url, key); err != nil {
+			return err
+		}
+		s.removeLegacyFile(path, root)
+		imported++
+	}
+	if imported > 0 || removed > 0 {
+		log.Printf("imported %d legacy attachment files, removed %d unused ones", imported, removed)
+	}
+	return nil
+}
+
+func (s *Store) putFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return s.Put(file)
+}
+
+// legacyPath returns the path of the file of the legacy url along with the directory the file was saved in.
+// Urls pointing outside of the directories are not valid
+func (s *Store) legacyPath(url string) (string, string, bool) {
+	if !filepath.IsAbs(url) {
+		return filepath.Join(s.tempDir, url), s.tempDir, filepath.IsLocal(url)
+	}
+	path := filepath.Clean(url)
+	relative, err := filepath.Rel(config.LegacyDownloadsDir, path)
+	if err != nil || relative == "." || !filepath.IsLocal(relative) {
+		return "", "", false
+	}
+	return path, config.LegacyDownloadsDir, true
+}
+
+// removeLegacyFile removes the file along with the directories made for it in the root directory,
+// every legacy file used to have a directory of its own
+func (s *Store) removeLegacyFile(path string, root string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("could not remove legacy attachment %s: %v", path, err)
+		return
+	}
+	root = filepath.Clean(root)
+	// directories are removed only if they are empty, e.g. a chat directory is left until all its files are removed
+	for dir := filepath.Dir(path); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LocalBackend keeps files in a local directory
+type LocalBackend struct {
+	dir string
+}
+
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{dir: dir}
+}
+
+func (b *LocalBackend) Put(key string, path string) error {
+	destination := b.path(key)
+	if err := os.MkdirAll(filepath.Dir(destination), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(path, destination)
+}
+
+func (b *LocalBackend) Open(key string) (io.ReadCloser, error) {
+	file, err := os.Open(b.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (b *LocalBackend) Remove(key string) error {
+	path := b.path(key)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// the directory is shared by many blobs, it is removed only when it is empty
+	_ = os.Remove(filepath.Dir(path))
+	return nil
+}
+
+// DownloadURL is not supported, the files are only reachable through the controller
+func (b *LocalBackend) DownloadURL(string, string, time.Duration) (string, error) {
+	return "", nil
+}
+
+// path spreads the files across subdirectories named by the first bytes of their hashes
+func (b *LocalBackend) path(key string) string {
+	return filepath.Join(b.dir, key[:2], key)
+}
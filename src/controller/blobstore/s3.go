@@ -0,0 +1,83 @@
+package blobstore
+
+import (
+	"Pelmenner/TransferBot/config"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Backend keeps files in a bucket of an S3-compatible object storage such as MinIO
+type S3Backend struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Backend connects to the storage and creates the bucket if it does not exist
+func NewS3Backend(settings config.S3Settings) (*S3Backend, error) {
+	client, err := minio.New(settings.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(settings.AccessKey, settings.SecretKey, ""),
+		Secure: settings.UseSSL,
+		Region: settings.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create s3 client: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.TODO(), settings.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not check bucket %s: %w", settings.Bucket, err)
+	}
+	if !exists {
+		err = client.MakeBucket(context.TODO(), settings.Bucket, minio.MakeBucketOptions{Region: settings.Region})
+		if err != nil {
+			return nil, fmt.Errorf("could not create bucket %s: %w", settings.Bucket, err)
+		}
+	}
+	return &S3Backend{client: client, bucket: settings.Bucket}, nil
+}
+
+func (b *S3Backend) Put(key string, path string) error {
+	_, err := b.client.FPutObject(context.TODO(), b.bucket, key, path, minio.PutObjectOptions{})
+	return err
+}
+
+func (b *S3Backend) Open(key string) (io.ReadCloser, error) {
+	object, err := b.client.GetObject(context.TODO(), b.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// the object is requested lazily, its absence is only found out by the first request
+	if _, err = object.Stat(); err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return object, nil
+}
+
+func (b *S3Backend) Remove(key string) error {
+	return b.client.RemoveObject(context.TODO(), b.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// DownloadURL returns a presigned link, the file is downloaded with its original name by it
+func (b *S3Backend) DownloadURL(key string, name string, expiry time.Duration) (string, error) {
+	params := url.Values{}
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name})
+	if name != "" && disposition != "" {
+		params.Set("response-content-disposition", disposition)
+	}
+	link, err := b.client.PresignedGetObject(context.TODO(), b.bucket, key, expiry, params)
+	if err != nil {
+		return "", err
+	}
+	return link.String(), nil
+}
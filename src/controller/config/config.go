@@ -5,14 +5,150 @@ import "os"
 const (
 	TokenLength            = 10
 	FileCleanupIntervalSec = 60
-	RetrySendIntervalSec   = 60
-	UnsentRetrieveMaxCnt   = 10
+	ReplyQuoteMaxLength    = 100
+	MaxHopCount            = 5
+
+	// DispatchIntervalSec is how often the outbox is checked for messages to retry
+	DispatchIntervalSec = 5
+	// DispatchBatchSize is the maximum number of messages claimed from the outbox at once
+	DispatchBatchSize = 50
+	// DispatchPerDestinationMaxCnt is the maximum number of messages claimed for a single destination at once
+	DispatchPerDestinationMaxCnt = 5
+	// SendTimeoutSec limits a single request to a messenger service. Requests sending attachments are given
+	// more time to download and upload the files at SendMinBytesPerSec, up to SendMaxTimeoutSec
+	SendTimeoutSec     = 20
+	SendMinBytesPerSec = 256 * 1024
+	SendMaxTimeoutSec  = 15 * 60
+	// DeliveryLeaseSec should be long enough to send the largest message. Messages which could not be sent
+	// before the lease expires are released, so that a request is never running when they are claimed again
+	DeliveryLeaseSec = SendMaxTimeoutSec + 2*DeliveryLeaseMarginSec
+	// DeliveryLeaseMarginSec is kept for saving the result of a request before the lease expires
+	DeliveryLeaseMarginSec = 60
+	// RetryBaseDelaySec is the delay before the first retry, every next one is twice as long
+	RetryBaseDelaySec = 5
+	RetryMaxDelaySec  = 60 * 60
+	// MaxDeliveryAttempts is the number of attempts after which a message is moved to the dead letters
+	MaxDeliveryAttempts = 10
+	// DeadLettersListMaxCnt is the maximum number of dead letters returned at once
+	DeadLettersListMaxCnt = 50
+
+	// AttachmentsDir is where attachments uploaded by messenger services are stored
+	AttachmentsDir      = "/transferbot/data/attachments"
+	AttachmentChunkSize = 64 * 1024
+	AttachmentMaxSize   = 100 * 1024 * 1024
+	// LegacyDownloadsDir is where messenger services saved attachments before they were uploaded to the controller,
+	// urls of such attachments are absolute paths in it
+	LegacyDownloadsDir = "/transferbot/data/downloads"
+	// BlobGracePeriodSec is how long unreferenced files are kept, messages referring to them are queued meanwhile
+	BlobGracePeriodSec = 60 * 60
+	// DownloadURLExpirySec is how long links to download files directly from the blob storage are valid
+	DownloadURLExpirySec = 15 * 60
+	// DeliveredRetentionSec is how long delivered messages are kept in the outbox
+	DeliveredRetentionSec = 24 * 60 * 60
+	// MaxSplitParts is the maximum number of parts a file too large for the destination is split into,
+	// larger files are replaced with links
+	MaxSplitParts = 5
+	// FileLinkExpirySec is how long links to files shared by the file server are valid
+	FileLinkExpirySec = 3 * 24 * 60 * 60
+	// RecompressedPhotoQuality is the JPEG quality of photos recompressed to fit the destination limits
+	RecompressedPhotoQuality = 85
+	// ProcessedPhotoQuality is the JPEG quality of photos changed according to the subscription options
+	ProcessedPhotoQuality = 90
 )
 
+// MessengerAddresses are hosts of messenger services, messengers without a host are not used
 var MessengerAddresses = map[string]string{
-	"vk": os.Getenv("VK_SERVICE_HOST"),
-	"tg": os.Getenv("TG_SERVICE_HOST"),
+	"vk":      os.Getenv("VK_SERVICE_HOST"),
+	"tg":      os.Getenv("TG_SERVICE_HOST"),
+	"discord": os.Getenv("DISCORD_SERVICE_HOST"),
+	"matrix":  os.Getenv("MATRIX_SERVICE_HOST"),
+	"slack":   os.Getenv("SLACK_SERVICE_HOST"),
+	"irc":     os.Getenv("IRC_SERVICE_HOST"),
+	"xmpp":    os.Getenv("XMPP_SERVICE_HOST"),
+}
+
+// Limits describe files a messenger accepts from a bot
+type Limits struct {
+	// MaxFileSize is the maximum size of a file of any type
+	MaxFileSize int64
+	// MaxPhotoSize is the maximum size of a photo shown as a photo, larger ones are recompressed or sent as files
+	MaxPhotoSize int64
+	// UnsupportedTypes are types of attachments which can not be sent at all, they are replaced with links
+	UnsupportedTypes []string
+	// PhotoFormats are image formats shown as photos, photos of other formats are converted to JPEG
+	PhotoFormats []string
+}
+
+var MessengerLimits = map[string]Limits{
+	"vk": {
+		MaxFileSize:  200 * 1024 * 1024,
+		MaxPhotoSize: 50 * 1024 * 1024,
+		PhotoFormats: []string{"jpeg", "png", "gif"},
+	},
+	"tg": {
+		MaxFileSize:  50 * 1024 * 1024,
+		MaxPhotoSize: 10 * 1024 * 1024,
+		PhotoFormats: []string{"jpeg", "png", "gif", "webp"},
+	},
+	"discord": {
+		MaxFileSize:  10 * 1024 * 1024,
+		MaxPhotoSize: 10 * 1024 * 1024,
+		PhotoFormats: []string{"jpeg", "png", "gif", "webp"},
+	},
+	// the limit of uploads is set by the homeserver, 50 MB is the default one of Synapse
+	"matrix": {
+		MaxFileSize:  50 * 1024 * 1024,
+		MaxPhotoSize: 50 * 1024 * 1024,
+		PhotoFormats: []string{"jpeg", "png", "gif", "webp"},
+	},
+	"slack": {
+		MaxFileSize:  1024 * 1024 * 1024,
+		MaxPhotoSize: 1024 * 1024 * 1024,
+		PhotoFormats: []string{"jpeg", "png", "gif"},
+	},
+	// IRC has no attachments, all of them are posted as links
+	"irc": {
+		UnsupportedTypes: []string{"photo", "video", "audio", "voice", "video_note", "animation", "sticker", "doc"},
+	},
+	// the limit of uploads is set by the server, 10 MB is the default one of mod_http_file_share of Prosody
+	"xmpp": {
+		MaxFileSize:  10 * 1024 * 1024,
+		MaxPhotoSize: 10 * 1024 * 1024,
+		PhotoFormats: []string{"jpeg", "png", "gif", "webp"},
+	},
 }
 
 var ServerPort = os.Getenv("PORT")
 var DBConnectString = os.Getenv("DB_CONNECT_STRING")
+
+// BlobStorage is where attachment files are kept: "local" (default) for AttachmentsDir or "s3"
+var BlobStorage = os.Getenv("BLOB_STORAGE")
+
+// FileServerPort is the port of the HTTP server sharing files which can not be sent to messengers.
+// The server is not started if it is empty
+var FileServerPort = os.Getenv("FILE_SERVER_PORT")
+
+// FileServerURL is the public address of the file server used in links to files
+var FileServerURL = os.Getenv("FILE_SERVER_URL")
+
+// FileLinkSecret is the key links to files are signed with, a random one is used if it is empty
+var FileLinkSecret = os.Getenv("FILE_LINK_SECRET")
+
+// S3Settings describe a bucket of an S3-compatible storage
+type S3Settings struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+var S3 = S3Settings{
+	Endpoint:  os.Getenv("S3_ENDPOINT"),
+	AccessKey: os.Getenv("S3_ACCESS_KEY"),
+	SecretKey: os.Getenv("S3_SECRET_KEY"),
+	Bucket:    os.Getenv("S3_BUCKET"),
+	Region:    os.Getenv("S3_REGION"),
+	UseSSL:    os.Getenv("S3_USE_SSL") == "true",
+}
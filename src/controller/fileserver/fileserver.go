@@ -0,0 +1,131 @@
+package fileserver
+
+import (
+	"Pelmenner/TransferBot/blobstore"
+	"Pelmenner/TransferBot/config"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server shares files of the blob store by signed links which expire after a while.
+// The files are pinned in the store until the links to them expire
+type Server struct {
+	blobs     *blobstore.Store
+	publicURL string
+	secret    []byte
+}
+
+func New(blobs *blobstore.Store, publicURL string, secret string) *Server {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, sha256.Size)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("could not generate file link secret: %v", err)
+		}
+		log.Printf("file link secret is not set, links to files will stop working after restart")
+	}
+	return &Server{blobs: blobs, publicURL: strings.TrimSuffix(publicURL, "/"), secret: key}
+}
+
+// Link returns a link to download the file stored under the key with the given name
+func (s *Server) Link(key string, name string) (string, error) {
+	expires := time.Now().Add(time.Second * config.FileLinkExpirySec)
+	if err := s.blobs.Pin(key, expires); err != nil {
+		return "", fmt.Errorf("could not pin blob %s: %w", key, err)
+	}
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", hex.EncodeToString(s.sign(key, name, expires.Unix())))
+	return fmt.Sprintf("%s/files/%s/%s?%s", s.publicURL, key, url.PathEscape(name), query.Encode()), nil
+}
+
+func (s *Server) sign(key string, name string, expires int64) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%d", key, name, expires)
+	return mac.Sum(nil)
+}
+
+// ListenAndServe serves files on the given port until the server fails
+func (s *Server) ListenAndServe(port string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/files/", s)
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// ServeHTTP sends the file requested by a link made by Link
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, name, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/files/"), "/")
+	if !found || !blobstore.ValidKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid link", http.StatusForbidden)
+		return
+	}
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || !hmac.Equal(signature, s.sign(key, name, expires)) {
+		http.Error(w, "invalid link", http.StatusForbidden)
+		return
+	}
+	if time.Now().Unix() > expires {
+		http.Error(w, "link has expired", http.StatusGone)
+		return
+	}
+
+	file, err := s.blobs.Open(key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("could not open blob %s: %v", key, err)
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	// files are never shown inline, so that a shared page could not run scripts on behalf of the server
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		// ranges are supported as well, so that large downloads could be resumed
+		http.ServeContent(w, r, name, time.Time{}, seeker)
+		return
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err = io.Copy(w, file); err != nil {
+		log.Printf("could not send blob %s: %v", key, err)
+	}
+}
@@ -0,0 +1,135 @@
+package filter
+
+import (
+	"Pelmenner/TransferBot/orm"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kinds of filter rules
+const (
+	KindHashtag    = "hashtag"
+	KindRegex      = "regex"
+	KindSender     = "sender"
+	KindAttachment = "attachment"
+)
+
+// anyAttachment is a value of attachment rule which matches attachments of all types
+const anyAttachment = "any"
+
+// Rule is a single condition a message should meet in order to be forwarded.
+// The textual form is "[!]<kind>:<value>", "!" negates the rule.
+// A hashtag rule may also be written as a plain hashtag, e.g. "#news".
+type Rule struct {
+	kind    string
+	value   string
+	negated bool
+	pattern *regexp.Regexp
+}
+
+// Parse creates a rule from its textual form
+func Parse(rule string) (*Rule, error) {
+	rule = strings.TrimSpace(rule)
+	res := &Rule{}
+	if strings.HasPrefix(rule, "!") {
+		res.negated = true
+		rule = strings.TrimSpace(rule[1:])
+	}
+	if strings.HasPrefix(rule, "#") {
+		rule = KindHashtag + ":" + rule
+	}
+
+	kind, value, found := strings.Cut(rule, ":")
+	if !found {
+		return nil, fmt.Errorf("rule should look like <kind>:<value>")
+	}
+	res.kind = strings.ToLower(strings.TrimSpace(kind))
+	res.value = strings.TrimSpace(value)
+	if res.value == "" {
+		return nil, fmt.Errorf("rule value is empty")
+	}
+
+	var err error
+	switch res.kind {
+	case KindHashtag:
+		res.value = strings.ToLower(strings.TrimPrefix(res.value, "#"))
+		res.pattern, err = regexp.Compile(`(?i)(?:^|[^\p{L}\p{N}_])#` + regexp.QuoteMeta(res.value) +
+			`(?:$|[^\p{L}\p{N}_])`)
+	case KindRegex:
+		res.pattern, err = regexp.Compile(res.value)
+	case KindSender:
+	case KindAttachment:
+		res.value = strings.ToLower(res.value)
+	default:
+		return nil, fmt.Errorf("unknown rule kind %q, expected one of: %s, %s, %s, %s",
+			res.kind, KindHashtag, KindRegex, KindSender, KindAttachment)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule value: %w", err)
+	}
+	return res, nil
+}
+
+// String returns the canonical textual form of the rule
+func (r *Rule) String() string {
+	prefix := ""
+	if r.negated {
+		prefix = "!"
+	}
+	return fmt.Sprintf("%s%s:%s", prefix, r.kind, r.value)
+}
+
+// Match checks if the message satisfies the rule ignoring its negation
+func (r *Rule) Match(message *orm.Message) bool {
+	switch r.kind {
+	case KindHashtag, KindRegex:
+		return r.pattern.MatchString(message.Text)
+	case KindSender:
+		return strings.EqualFold(strings.TrimSpace(message.Sender.Name), r.value)
+	case KindAttachment:
+		for _, attachment := range message.Attachments {
+			if r.value == anyAttachment || strings.EqualFold(attachment.Type, r.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches checks if the message passes all the rules.
+// Rules of the same kind are alternatives, so it is enough to match one of them,
+// while at least one rule of each kind should be matched. None of negated rules may be matched.
+// A message passes an empty list of rules.
+func Matches(rules []*Rule, message *orm.Message) bool {
+	matchedKinds := make(map[string]bool)
+	for _, rule := range rules {
+		matched := rule.Match(message)
+		if rule.negated {
+			if matched {
+				return false
+			}
+			continue
+		}
+		matchedKinds[rule.kind] = matchedKinds[rule.kind] || matched
+	}
+	for _, matched := range matchedKinds {
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAll parses all valid rules skipping invalid ones
+func ParseAll(rules []string) []*Rule {
+	res := make([]*Rule, 0, len(rules))
+	for _, rule := range rules {
+		parsed, err := Parse(rule)
+		if err != nil {
+			continue
+		}
+		res = append(res, parsed)
+	}
+	return res
+}
@@ -0,0 +1,164 @@
+package format
+
+import (
+	"Pelmenner/TransferBot/orm"
+	"container/list"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf16"
+)
+
+// DefaultTemplate shows the sender with the name of their chat above the message text
+const DefaultTemplate = `{{if .Sender.Name}}{{.Sender.Name}}{{if .Sender.Chat}} ({{.Sender.Chat}}){{end}}:
+{{end}}{{.Text}}`
+
+// Data is the value templates are executed with
+type Data struct {
+	// Text is the text of the message
+	Text string
+	// Sender is the author of the message
+	Sender orm.Sender
+	// Chat is the chat the message was sent to originally
+	Chat orm.Chat
+	// Attachments are files attached to the message
+	Attachments []*orm.Attachment
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+[ \t]?`)
+
+var funcs = template.FuncMap{
+	// stripLinks removes all links from the text
+	"stripLinks": func(text string) string {
+		return strings.TrimSpace(linkPattern.ReplaceAllString(text, ""))
+	},
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// parsedCacheSize is the number of parsed templates kept, there are usually few distinct templates in use
+const parsedCacheSize = 128
+
+// templateCache keeps the most recently used parsed templates, as the same ones are used for every message.
+// Templates which are not used anymore, e.g. replaced ones, are evicted when the cache is full
+type templateCache struct {
+	mutex sync.Mutex
+	// order keeps the texts of the templates from the most to the least recently used
+	order     *list.List
+	templates map[string]*list.Element
+}
+
+type cachedTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+var parsed = templateCache{order: list.New(), templates: make(map[string]*list.Element)}
+
+func (c *templateCache) get(text string) (*template.Template, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, exists := c.templates[text]
+	if !exists {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedTemplate).tmpl, true
+}
+
+func (c *templateCache) add(text string, tmpl *template.Template) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, exists := c.templates[text]; exists {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.templates[text] = c.order.PushFront(&cachedTemplate{text: text, tmpl: tmpl})
+	if c.order.Len() > parsedCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.templates, oldest.Value.(*cachedTemplate).text)
+	}
+}
+
+func parse(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	if tmpl, exists := parsed.get(text); exists {
+		return tmpl, nil
+	}
+	tmpl, err := template.New("message").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	parsed.add(text, tmpl)
+	return tmpl, nil
+}
+
+// textMarker stands for the message text to find where the text is put by a template
+const textMarker = "\uE000"
+
+// Render returns the message text formatted by the template along with the entities of the message text
+// moved to where the text is put. An empty template means the default one.
+// The entities are dropped if the template changes the text itself, e.g. removes links from it
+func Render(text string, message *orm.Message, source *orm.Chat) (string, []orm.Entity, error) {
+	tmpl, err := parse(text)
+	if err != nil {
+		return "", nil, err
+	}
+	data := Data{
+		Text:        message.Text,
+		Sender:      message.Sender,
+		Attachments: message.Attachments,
+	}
+	if source != nil {
+		data.Chat = *source
+	}
+
+	res, err := execute(tmpl, data)
+	if err != nil || len(message.Entities) == 0 {
+		return res, nil, err
+	}
+	data.Text = textMarker
+	marked, err := execute(tmpl, data)
+	if err != nil || strings.Replace(marked, textMarker, message.Text, 1) != res {
+		return res, nil, nil
+	}
+	prefix, _, _ := strings.Cut(marked, textMarker)
+	return res, ShiftEntities(message.Entities, prefix), nil
+}
+
+func execute(tmpl *template.Template, data Data) (string, error) {
+	var res strings.Builder
+	if err := tmpl.Execute(&res, data); err != nil {
+		return "", err
+	}
+	return res.String(), nil
+}
+
+// ShiftEntities returns the entities of a text moved as if the prefix was added before the text
+func ShiftEntities(entities []orm.Entity, prefix string) []orm.Entity {
+	shift := int32(len(utf16.Encode([]rune(prefix))))
+	var res []orm.Entity
+	for _, entity := range entities {
+		entity.Offset += shift
+		res = append(res, entity)
+	}
+	return res
+}
+
+// Validate checks if the template can be used to render messages
+func Validate(text string) error {
+	example := &orm.Message{
+		Text:   "Example text https://example.com",
+		Sender: orm.Sender{Name: "Name", Chat: "Chat"},
+	}
+	if _, _, err := Render(text, example, &orm.Chat{Name: "Chat"}); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	return nil
+}
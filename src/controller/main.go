@@ -1,7 +1,9 @@
 package main
 
 import (
+	"Pelmenner/TransferBot/blobstore"
 	"Pelmenner/TransferBot/config"
+	"Pelmenner/TransferBot/fileserver"
 	"Pelmenner/TransferBot/messenger"
 	"Pelmenner/TransferBot/orm"
 	"fmt"
@@ -10,8 +12,6 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"log"
 	"net"
-	"os"
-	"path/filepath"
 	"time"
 )
 
@@ -30,12 +30,23 @@ func main() {
 		log.Fatalf("could not connect to messengers: %v", err)
 	}
 
+	backend, err := blobstore.NewBackend()
+	if err != nil {
+		log.Fatalf("could not create blob storage: %v", err)
+	}
+	blobs := blobstore.New(backend, db)
+	// files are imported before the dispatcher starts sending the messages referring to them
+	if err := blobs.ImportLegacyFiles(db); err != nil {
+		log.Fatalf("could not import legacy attachment files: %v", err)
+	}
+	files := newFileServer(blobs)
+	dispatcher := messenger.NewDispatcher(db, messengers, blobs, files)
 	listener := newHTTPListener()
-	server := newGRPCServer(db, messengers)
+	server := newGRPCServer(db, messengers, dispatcher, blobs)
 	log.Printf("created grpc server")
 
-	go repeatedProcessUnsentMessages(db, messengers)
-	go repeatedFileCleanup(db)
+	go dispatcher.Run()
+	go repeatedFileCleanup(db, blobs)
 
 	if err := server.Serve(listener); err != nil {
 		log.Fatalf("failed to serve: %s", err)
@@ -45,6 +56,10 @@ func main() {
 func initMessengers() (map[string]Messenger, error) {
 	messengers := make(map[string]Messenger)
 	for messengerName, host := range config.MessengerAddresses {
+		if host == "" {
+			log.Printf("%s service host is not set, skipping it", messengerName)
+			continue
+		}
 		connection, err := grpc.Dial(host, grpc.WithTransportCredentials(insecure.NewCredentials()))
 		if err != nil {
 			return messengers, fmt.Errorf("connection to %s failed: %v", messengerName, err)
@@ -63,58 +78,36 @@ func newHTTPListener() net.Listener {
 	return lis
 }
 
-func newGRPCServer(storage messenger.Storage, messengers map[string]Messenger) *grpc.Server {
+// newFileServer starts the server sharing files which can not be sent to messengers if its port is configured
+func newFileServer(blobs *blobstore.Store) *fileserver.Server {
+	if config.FileServerPort == "" {
+		return nil
+	}
+	if config.FileServerURL == "" {
+		log.Fatalf("public url of the file server is not set")
+	}
+	files := fileserver.New(blobs, config.FileServerURL, config.FileLinkSecret)
+	go func() {
+		log.Fatalf("file server failed: %v", files.ListenAndServe(config.FileServerPort))
+	}()
+	log.Printf("serving files on port %s", config.FileServerPort)
+	return files
+}
+
+func newGRPCServer(storage messenger.Storage, messengers map[string]Messenger,
+	dispatcher *messenger.Dispatcher, blobs *blobstore.Store) *grpc.Server {
 	server := grpc.NewServer()
-	controllerServer := messenger.NewControllerServer(storage, messengers)
+	controllerServer := messenger.NewControllerServer(storage, messengers, dispatcher, blobs)
 	controller.RegisterControllerServer(server, controllerServer)
 	return server
 }
 
-func repeatedFileCleanup(db *orm.DB) {
+func repeatedFileCleanup(db *orm.DB, blobs *blobstore.Store) {
 	for {
-		attachments, err := db.GetUnusedAttachments()
-		if err != nil {
-			log.Print(err)
-		} else {
-			for _, attachment := range attachments {
-				deleteAttachment(attachment)
-			}
+		if err := db.PruneDeliveredMessages(time.Second * config.DeliveredRetentionSec); err != nil {
+			log.Printf("could not prune delivered messages: %v", err)
 		}
+		blobs.Collect()
 		time.Sleep(time.Second * config.FileCleanupIntervalSec)
 	}
 }
-
-func deleteAttachment(attachment *orm.Attachment) {
-	err := os.Remove(attachment.URL)
-	if err != nil {
-		log.Println("could not delete file", attachment.URL, err)
-	}
-	err = os.Remove(filepath.Dir(attachment.URL))
-	if err != nil {
-		log.Println("could not delete directory", filepath.Dir(attachment.URL), err)
-	}
-}
-
-func repeatedProcessUnsentMessages(db *orm.DB, messengers map[string]Messenger) {
-	for {
-		messages, err := db.GetUnsentMessages(config.UnsentRetrieveMaxCnt)
-		if err != nil {
-			log.Print(err)
-		} else {
-			processUnsentMessages(messages, db, messengers)
-		}
-		time.Sleep(time.Second * config.RetrySendIntervalSec)
-	}
-}
-
-func processUnsentMessages(messages []orm.QueuedMessage, db *orm.DB, messengers map[string]Messenger) {
-	for _, queuedMessage := range messages {
-		destination := &queuedMessage.Destination
-		if err := messengers[destination.Type].SendMessage(&queuedMessage.Message, destination); err != nil {
-			log.Printf("could not send message: %v", err)
-			if err := db.AddUnsentMessage(queuedMessage); err != nil {
-				log.Printf("could not save unsent message %v", err)
-			}
-		}
-	}
-}
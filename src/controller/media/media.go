@@ -0,0 +1,65 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"math"
+)
+
+// maxPixels limits the size of images decoded to memory
+const maxPixels = 100 * 1000 * 1000
+
+// maxScaleSteps is the number of times an image is scaled down before giving up on fitting it
+const maxScaleSteps = 5
+
+var ErrTooLarge = errors.New("image can not be compressed enough")
+
+// Recompress re-encodes the image as JPEG with given quality, scaling it down until it takes at most maxSize bytes
+func Recompress(data []byte, maxSize int64, quality int) ([]byte, error) {
+	imgConfig, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if imgConfig.Width*imgConfig.Height > maxPixels {
+		return nil, fmt.Errorf("image is too large: %dx%d", imgConfig.Width, imgConfig.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// the orientation tag is lost on encoding
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	for step := 0; step <= maxScaleSteps; step++ {
+		var res bytes.Buffer
+		if err = jpeg.Encode(&res, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		if int64(res.Len()) <= maxSize {
+			return res.Bytes(), nil
+		}
+		// the size of a JPEG is roughly proportional to the number of pixels
+		img = scale(img, min(0.8, 0.95*float64(maxSize)/float64(res.Len())))
+	}
+	return nil, ErrTooLarge
+}
+
+// scale returns the image scaled down so that its area is multiplied by the factor
+func scale(img image.Image, areaFactor float64) image.Image {
+	bounds := img.Bounds()
+	factor := math.Sqrt(areaFactor)
+	width := max(1, int(float64(bounds.Dx())*factor))
+	height := max(1, int(float64(bounds.Dy())*factor))
+	res := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(res, res.Bounds(), img, bounds, draw.Src, nil)
+	return res
+}
@@ -0,0 +1,180 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// JPEG markers
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	markerAPP2 = 0xE2
+	// APP14 tells how colors of the image are encoded, so it is not metadata
+	markerAPP14 = 0xEE
+	markerAPP15 = 0xEF
+	markerCOM   = 0xFE
+)
+
+const orientationTag = 0x0112
+
+// jpegSegment is a part of a JPEG file before the image data
+type jpegSegment struct {
+	marker byte
+	// data is the whole segment including the marker
+	data []byte
+}
+
+// jpegSegments splits a JPEG file into segments before the start of the image data and the rest of the file
+func jpegSegments(data []byte) ([]jpegSegment, []byte, bool) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, nil, false
+	}
+	var segments []jpegSegment
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, nil, false
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			// fill bytes may go before a marker
+			pos++
+			continue
+		}
+		if marker == markerSOS {
+			return segments, data[pos:], true
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, nil, false
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[pos : pos+2+length]})
+		pos += 2 + length
+	}
+	return nil, nil, false
+}
+
+// stripJPEGMetadata removes EXIF, XMP, IPTC and comments from a JPEG file without decoding it.
+// Color profiles are kept, as the image looks different without them
+func stripJPEGMetadata(data []byte) ([]byte, bool) {
+	segments, rest, ok := jpegSegments(data)
+	if !ok {
+		return nil, false
+	}
+	var res bytes.Buffer
+	res.Write([]byte{0xFF, markerSOI})
+	for _, segment := range segments {
+		isApp := segment.marker >= markerAPP0 && segment.marker <= markerAPP15
+		isKept := segment.marker == markerAPP0 || segment.marker == markerAPP2 || segment.marker == markerAPP14
+		if segment.marker == markerCOM || isApp && !isKept {
+			continue
+		}
+		res.Write(segment.data)
+	}
+	res.Write(rest)
+	return res.Bytes(), true
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG file, 1 means the image is stored as it is shown
+func jpegOrientation(data []byte) int {
+	segments, _, ok := jpegSegments(data)
+	if !ok {
+		return 1
+	}
+	for _, segment := range segments {
+		exif, found := bytes.CutPrefix(segment.data[4:], []byte("Exif\x00\x00"))
+		if segment.marker == markerAPP1 && found {
+			return exifOrientation(exif)
+		}
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first directory of EXIF data
+func exifOrientation(exif []byte) int {
+	if len(exif) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(exif[4:]))
+	if offset < 8 || offset+2 > len(exif) {
+		return 1
+	}
+	cnt := int(order.Uint16(exif[offset:]))
+	for i := 0; i < cnt; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(exif) {
+			return 1
+		}
+		if order.Uint16(exif[entry:]) == orientationTag {
+			orientation := int(order.Uint16(exif[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// orient turns the image as the EXIF orientation says, so that it is shown right without the orientation tag
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	src := toRGBA(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	// orientations from 5 to 8 swap the sides
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):])
+		}
+	}
+	return dst
+}
+
+// toRGBA returns the image as RGBA starting at the origin
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(res, res.Bounds(), img, bounds.Min, draw.Src)
+	return res
+}
@@ -0,0 +1,85 @@
+package media
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	minDimension       = 16
+	maxDimension       = 10000
+	watermarkMaxLength = 100
+)
+
+// Options describe how photos are processed before they are sent.
+// The textual form is a list of "resize=<pixels>", "strip" and "watermark=<text>" separated by spaces,
+// the watermark goes last as it may contain spaces itself. Empty options leave photos as they are
+type Options struct {
+	// MaxDimension limits the width and the height of photos, zero means no limit
+	MaxDimension int
+	// StripMetadata removes EXIF and other metadata, which may contain e.g. the location a photo was taken at
+	StripMetadata bool
+	// Watermark is a text drawn in the corner of photos
+	Watermark string
+	// Formats are formats of photos the destination shows, photos of other formats are converted to JPEG.
+	// They are not a part of the textual form, empty formats mean any
+	Formats []string
+}
+
+// ParseOptions creates options from their textual form
+func ParseOptions(text string) (Options, error) {
+	var options Options
+	rest := text
+	for {
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			break
+		}
+		option, remaining, _ := strings.Cut(rest, " ")
+		name, value, _ := strings.Cut(option, "=")
+		switch strings.ToLower(name) {
+		case "resize":
+			size, err := strconv.Atoi(value)
+			if err != nil || size < minDimension || size > maxDimension {
+				return Options{}, fmt.Errorf("resize should be a number of pixels from %d to %d",
+					minDimension, maxDimension)
+			}
+			options.MaxDimension = size
+		case "strip":
+			options.StripMetadata = true
+		case "watermark":
+			// the watermark takes the rest of the text
+			options.Watermark = strings.TrimSpace(strings.TrimPrefix(rest[len(name):], "="))
+			remaining = ""
+			if options.Watermark == "" || len([]rune(options.Watermark)) > watermarkMaxLength {
+				return Options{}, fmt.Errorf("watermark should be from 1 to %d characters long", watermarkMaxLength)
+			}
+		default:
+			return Options{}, fmt.Errorf("unknown option %q, expected one of: resize=<pixels>, strip, "+
+				"watermark=<text>", option)
+		}
+		rest = remaining
+	}
+	return options, nil
+}
+
+// String returns the canonical textual form of the options
+func (o Options) String() string {
+	var parts []string
+	if o.MaxDimension > 0 {
+		parts = append(parts, fmt.Sprintf("resize=%d", o.MaxDimension))
+	}
+	if o.StripMetadata {
+		parts = append(parts, "strip")
+	}
+	if o.Watermark != "" {
+		parts = append(parts, "watermark="+o.Watermark)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Key identifies the result of processing a photo with the options including the formats
+func (o Options) Key() string {
+	return fmt.Sprintf("photo %s formats=%s", o.String(), strings.Join(o.Formats, ","))
+}
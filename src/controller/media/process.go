@@ -0,0 +1,139 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// heifConverter is a tool of libheif converting HEIC images to JPEG, they can not be decoded in Go
+const heifConverter = "heif-convert"
+
+const heifConversionTimeout = time.Minute
+
+// heifBrands are brands of the ISO media files which contain HEIC images
+var heifBrands = []string{"heic", "heix", "hevc", "hevx", "mif1", "msf1"}
+
+// Process applies the options to the photo and returns the result along with its format, "jpeg" or "png".
+// Nil is returned if the photo does not need to be changed
+func Process(data []byte, options Options, quality int) ([]byte, string, error) {
+	changed := false
+	if isHEIC(data) {
+		converted, err := convertHEIC(data, quality)
+		if err != nil {
+			return nil, "", err
+		}
+		data, changed = converted, true
+	}
+	imgConfig, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if imgConfig.Width*imgConfig.Height > maxPixels {
+		return nil, "", fmt.Errorf("image is too large: %dx%d", imgConfig.Width, imgConfig.Height)
+	}
+
+	convert := len(options.Formats) > 0 && !slices.Contains(options.Formats, format)
+	resize := options.MaxDimension > 0 && max(imgConfig.Width, imgConfig.Height) > options.MaxDimension
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	if !convert && !resize && options.Watermark == "" {
+		// the image is not recompressed if only metadata is removed, unless it is needed to keep the orientation
+		if options.StripMetadata && format == "jpeg" && orientation == 1 {
+			if stripped, ok := stripJPEGMetadata(data); ok {
+				return stripped, format, nil
+			}
+		}
+		// animations are lost on decoding, and gif files hardly have metadata,
+		// so they are not decoded just to strip it
+		if !options.StripMetadata || format == "gif" {
+			if changed {
+				return data, format, nil
+			}
+			return nil, "", nil
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	img = orient(img, orientation)
+	if resize {
+		img = fitDimension(img, options.MaxDimension)
+	}
+	rgba := toRGBA(img)
+	if options.Watermark != "" {
+		if err = drawWatermark(rgba, options.Watermark); err != nil {
+			return nil, "", fmt.Errorf("could not draw watermark: %w", err)
+		}
+	}
+
+	// metadata is never written by the encoders
+	var res bytes.Buffer
+	if format == "png" && !convert {
+		err = png.Encode(&res, rgba)
+	} else {
+		format = "jpeg"
+		err = jpeg.Encode(&res, rgba, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return res.Bytes(), format, nil
+}
+
+// fitDimension scales the image down, so that its sides are not larger than the maximum dimension
+func fitDimension(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	longest := max(bounds.Dx(), bounds.Dy())
+	if longest <= maxDimension {
+		return img
+	}
+	width := max(1, bounds.Dx()*maxDimension/longest)
+	height := max(1, bounds.Dy()*maxDimension/longest)
+	res := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(res, res.Bounds(), img, bounds, draw.Src, nil)
+	return res
+}
+
+// isHEIC checks if the file is an ISO media file with a HEIC image
+func isHEIC(data []byte) bool {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	return slices.Contains(heifBrands, string(data[8:12]))
+}
+
+// convertHEIC converts a HEIC image to JPEG using an external tool
+func convertHEIC(data []byte, quality int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "heic-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.heic")
+	output := filepath.Join(dir, "output.jpg")
+	if err = os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), heifConversionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, heifConverter, "-q", fmt.Sprint(quality), input, output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("could not convert HEIC image: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return os.ReadFile(output)
+}
@@ -0,0 +1,49 @@
+package media
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"sync"
+)
+
+var watermarkFont = sync.OnceValues(func() (*opentype.Font, error) {
+	return opentype.Parse(goregular.TTF)
+})
+
+// drawWatermark draws the text in the bottom right corner of the image.
+// The text is light with a dark shadow, so that it could be read on any background
+func drawWatermark(img *image.RGBA, text string) error {
+	fnt, err := watermarkFont()
+	if err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	size := max(12, float64(min(bounds.Dx(), bounds.Dy()))/25)
+	face, err := opentype.NewFace(fnt, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	margin := fixed.I(int(size / 2))
+	drawer := &font.Drawer{Dst: img, Face: face}
+	width := drawer.MeasureString(text)
+	descent := face.Metrics().Descent
+	position := fixed.Point26_6{
+		X: max(fixed.I(bounds.Max.X)-margin-width, fixed.I(bounds.Min.X)),
+		Y: fixed.I(bounds.Max.Y) - margin - descent,
+	}
+	shadowOffset := fixed.I(max(1, int(size/16)))
+
+	drawer.Src = image.NewUniform(color.NRGBA{A: 128})
+	drawer.Dot = position.Add(fixed.Point26_6{X: shadowOffset, Y: shadowOffset})
+	drawer.DrawString(text)
+	drawer.Src = image.NewUniform(color.NRGBA{R: 255, G: 255, B: 255, A: 200})
+	drawer.Dot = position
+	drawer.DrawString(text)
+	return nil
+}
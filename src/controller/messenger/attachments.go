@@ -0,0 +1,90 @@
+package messenger
+
+import (
+	"Pelmenner/TransferBot/blobstore"
+	"Pelmenner/TransferBot/config"
+	"errors"
+	"github.com/Pelmenner/TransferBot/proto/controller"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+)
+
+// UploadAttachment saves a file sent by a messenger service to the blob store.
+// The url of the file is its key in the store, so the same file sent twice gets the same url.
+func (c *ControllerServer) UploadAttachment(stream controller.Controller_UploadAttachmentServer) error {
+	request, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "no file was sent")
+	}
+	if err != nil {
+		return err
+	}
+
+	url, err := c.blobs.Put(&uploadReader{stream: stream, chunk: request.Data})
+	if errors.Is(err, blobstore.ErrTooLarge) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		if status.Code(err) != codes.Unknown {
+			return err
+		}
+		log.Printf("could not save attachment %s: %v", request.Name, err)
+		return status.Error(codes.Unknown, "something went wrong")
+	}
+	return stream.SendAndClose(&controller.UploadAttachmentResponse{Url: url})
+}
+
+// uploadReader reads the file sent in chunks starting from the one already received
+type uploadReader struct {
+	stream controller.Controller_UploadAttachmentServer
+	chunk  []byte
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		request, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = request.Data
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// DownloadAttachment sends a file from the blob store in chunks
+func (c *ControllerServer) DownloadAttachment(request *controller.DownloadAttachmentRequest,
+	stream controller.Controller_DownloadAttachmentServer) error {
+	if !blobstore.ValidKey(request.Url) {
+		return status.Error(codes.InvalidArgument, "invalid attachment url")
+	}
+	file, err := c.blobs.Open(request.Url)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return status.Error(codes.NotFound, "no such attachment")
+	}
+	if err != nil {
+		log.Printf("could not open attachment: %v", err)
+		return status.Error(codes.Unknown, "something went wrong")
+	}
+	defer file.Close()
+
+	buffer := make([]byte, config.AttachmentChunkSize)
+	for {
+		n, err := file.Read(buffer)
+		if n > 0 {
+			if sendErr := stream.Send(&controller.DownloadAttachmentResponse{Data: buffer[:n]}); sendErr != nil {
+				return sendErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("could not read attachment: %v", err)
+			return status.Error(codes.Unknown, "something went wrong")
+		}
+	}
+}
@@ -1,16 +1,28 @@
 package messenger
 
 import (
+	"Pelmenner/TransferBot/config"
 	"Pelmenner/TransferBot/orm"
 	"context"
 	msg "github.com/Pelmenner/TransferBot/proto/messenger"
 	"google.golang.org/grpc"
+	"time"
 )
 
 type Client struct {
 	msg.ChatServiceClient
 }
 
+// PartialSendError is returned along with ids of the sent parts of a message when the rest of it could not be sent.
+// The message should not be sent again, since the sent parts would be duplicated
+type PartialSendError struct {
+	Reason string
+}
+
+func (e *PartialSendError) Error() string {
+	return "the message was sent partially: " + e.Reason
+}
+
 func NewMessengerClient(cc grpc.ClientConnInterface) *Client {
 	internalClient := msg.NewChatServiceClient(cc)
 	return &Client{
@@ -18,45 +30,127 @@ func NewMessengerClient(cc grpc.ClientConnInterface) *Client {
 	}
 }
 
-func (m *Client) SendMessage(message *orm.Message, chat *orm.Chat) error {
+func (m *Client) SendMessage(ctx context.Context, message *orm.Message, chat *orm.Chat) ([]string, error) {
 	pbMessage := messageToProto(message)
 	pbChat := chatToProto(chat)
-	_, err := m.ChatServiceClient.SendMessage(context.TODO(), &msg.SendMessageRequest{
+	response, err := m.ChatServiceClient.SendMessage(ctx, &msg.SendMessageRequest{
 		Message: pbMessage,
 		Chat:    pbChat,
 	})
+	if err != nil {
+		return nil, err
+	}
+	if response.PartialError != "" {
+		return response.MessageIds, &PartialSendError{Reason: response.PartialError}
+	}
+	return response.MessageIds, nil
+}
+
+func (m *Client) EditMessage(message *orm.Message, chat *orm.Chat, messageIDs []string) error {
+	ctx, cancel := requestContext()
+	defer cancel()
+	pbMessage := messageToProto(message)
+	pbChat := chatToProto(chat)
+	_, err := m.ChatServiceClient.EditMessage(ctx, &msg.EditMessageRequest{
+		Message:    pbMessage,
+		Chat:       pbChat,
+		MessageIds: messageIDs,
+	})
 	return err
 }
 
+func (m *Client) DeleteMessage(chat *orm.Chat, messageIDs []string) error {
+	ctx, cancel := requestContext()
+	defer cancel()
+	pbChat := chatToProto(chat)
+	_, err := m.ChatServiceClient.DeleteMessage(ctx, &msg.DeleteMessageRequest{
+		Chat:       pbChat,
+		MessageIds: messageIDs,
+	})
+	return err
+}
+
+// requestContext limits the time of a request to the messenger, so that an unavailable one does not hang the caller.
+// Requests sending messages are limited by the dispatcher according to the size of their attachments
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Second*config.SendTimeoutSec)
+}
+
 func messageToProto(message *orm.Message) *msg.Message {
 	pbSender := senderToProto(&message.Sender)
 	pbMessage := msg.Message{
-		Text:   message.Text,
-		Sender: pbSender,
+		Id:       message.ID,
+		Text:     message.Text,
+		Sender:   pbSender,
+		OriginId: message.OriginID,
+		HopCount: message.HopCount,
 	}
 	for _, attachment := range message.Attachments {
 		pbMessage.Attachments = append(pbMessage.Attachments, attachmentToProto(attachment))
 	}
+	if message.ReplyTo != nil {
+		pbMessage.ReplyTo = replyToProto(message.ReplyTo)
+	}
+	if location := message.Location; location != nil {
+		pbMessage.Location = &msg.Location{
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Title:     location.Title,
+			Address:   location.Address,
+		}
+	}
+	if contact := message.Contact; contact != nil {
+		pbMessage.Contact = &msg.Contact{Name: contact.Name, Phone: contact.Phone}
+	}
+	if poll := message.Poll; poll != nil {
+		pbMessage.Poll = &msg.Poll{
+			Question:        poll.Question,
+			Options:         poll.Options,
+			MultipleAnswers: poll.MultipleAnswers,
+			Anonymous:       poll.Anonymous,
+		}
+	}
+	for _, entity := range message.Entities {
+		pbMessage.Entities = append(pbMessage.Entities, &msg.TextEntity{
+			Type:     entity.Type,
+			Offset:   entity.Offset,
+			Length:   entity.Length,
+			Url:      entity.URL,
+			Language: entity.Language,
+		})
+	}
 	return &pbMessage
 }
 
+func replyToProto(reply *orm.Reply) *msg.Reply {
+	return &msg.Reply{
+		MessageId: reply.MessageID,
+		Sender:    senderToProto(&reply.Sender),
+		Text:      reply.Text,
+	}
+}
+
 func chatToProto(chat *orm.Chat) *msg.Chat {
 	if chat == nil {
 		return nil
 	}
 	return &msg.Chat{
-		Id:   chat.ID,
-		Name: chat.Type, // TODO: pass an actual name
-		Type: chat.Type,
+		Id:      chat.ID,
+		Name:    chat.Type, // TODO: pass an actual name
+		Type:    chat.Type,
+		Address: chat.Address,
 	}
 }
 
 func attachmentToProto(attachment *orm.Attachment) *msg.Attachment {
 	return &msg.Attachment{
-		Type: attachment.Type,
-		Url:  attachment.URL,
+		Type:        attachment.Type,
+		Url:         attachment.URL,
+		Name:        attachment.Name,
+		DownloadUrl: attachment.DownloadURL,
 	}
 }
+
 func senderToProto(sender *orm.Sender) *msg.Sender {
 	return &msg.Sender{
 		Name: sender.Name,
@@ -0,0 +1,87 @@
+package messenger
+
+import (
+	"Pelmenner/TransferBot/config"
+	"Pelmenner/TransferBot/orm"
+	"context"
+	"github.com/Pelmenner/TransferBot/proto/controller"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+)
+
+func (c *ControllerServer) ListDeadLetters(_ context.Context, request *controller.DeadLettersRequest) (
+	*controller.ListDeadLettersResponse, error) {
+	limit := int(request.Limit)
+	if limit <= 0 || limit > config.DeadLettersListMaxCnt {
+		limit = config.DeadLettersListMaxCnt
+	}
+	deadLetters, err := c.storage.GetDeadLetters(deadLetterFilterFromProto(request), limit)
+	if err != nil {
+		log.Printf("could not get dead letters: %v", err)
+		return &controller.ListDeadLettersResponse{}, status.Error(codes.Unknown, "something went wrong")
+	}
+
+	response := &controller.ListDeadLettersResponse{}
+	for i := range deadLetters {
+		response.DeadLetters = append(response.DeadLetters, deadLetterToProto(&deadLetters[i]))
+	}
+	return response, nil
+}
+
+func (c *ControllerServer) GetDeadLetter(_ context.Context, request *controller.GetDeadLetterRequest) (
+	*controller.DeadLetter, error) {
+	deadLetters, err := c.storage.GetDeadLetters(orm.DeadLetterFilter{DeliveryIDs: []int32{request.Id}}, 1)
+	if err != nil {
+		log.Printf("could not get dead letter %d: %v", request.Id, err)
+		return &controller.DeadLetter{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	if len(deadLetters) == 0 {
+		return &controller.DeadLetter{}, status.Error(codes.NotFound, "no such dead letter")
+	}
+	return deadLetterToProto(&deadLetters[0]), nil
+}
+
+func (c *ControllerServer) ReplayDeadLetters(_ context.Context, request *controller.DeadLettersRequest) (
+	*controller.DeadLettersResponse, error) {
+	cnt, err := c.storage.ReplayDeadLetters(deadLetterFilterFromProto(request))
+	if err != nil {
+		log.Printf("could not replay dead letters: %v", err)
+		return &controller.DeadLettersResponse{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	log.Printf("replayed %d dead letters", cnt)
+	if cnt > 0 {
+		c.dispatcher.Notify()
+	}
+	return &controller.DeadLettersResponse{Count: int32(cnt)}, nil
+}
+
+func (c *ControllerServer) PurgeDeadLetters(_ context.Context, request *controller.DeadLettersRequest) (
+	*controller.DeadLettersResponse, error) {
+	cnt, err := c.storage.PurgeDeadLetters(deadLetterFilterFromProto(request))
+	if err != nil {
+		log.Printf("could not purge dead letters: %v", err)
+		return &controller.DeadLettersResponse{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	log.Printf("purged %d dead letters", cnt)
+	return &controller.DeadLettersResponse{Count: int32(cnt)}, nil
+}
+
+func deadLetterFilterFromProto(request *controller.DeadLettersRequest) orm.DeadLetterFilter {
+	return orm.DeadLetterFilter{
+		Destination: chatFromProto(request.Destination),
+		DeliveryIDs: request.Ids,
+	}
+}
+
+func deadLetterToProto(deadLetter *orm.DeadLetter) *controller.DeadLetter {
+	return &controller.DeadLetter{
+		Id:          deadLetter.DeliveryID,
+		Message:     messageToProto(&deadLetter.Message),
+		Source:      chatToProto(&deadLetter.Source),
+		Destination: chatToProto(&deadLetter.Destination),
+		Attempts:    int32(deadLetter.Attempts),
+		LastError:   deadLetter.LastError,
+		FailedAt:    deadLetter.FailedAt.Unix(),
+	}
+}
@@ -0,0 +1,262 @@
+package messenger
+
+import (
+	"Pelmenner/TransferBot/blobstore"
+	"Pelmenner/TransferBot/config"
+	"Pelmenner/TransferBot/fileserver"
+	"Pelmenner/TransferBot/orm"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// Outbox stores messages until they are delivered to their destinations
+type Outbox interface {
+	copyFinder
+	ImageCache
+	PartCache
+	ClaimMessages(maxCnt int, maxPerDestination int, lease time.Duration) ([]orm.QueuedMessage, error)
+	MarkMessageDelivered(deliveryID int32) error
+	MarkMessageFailed(deliveryID int32, lastError string, nextAttemptAt time.Time) error
+	MarkMessageDead(deliveryID int32, lastError string) error
+	PostponeMessages(deliveryIDs []int32, nextAttemptAt time.Time) error
+	LogDeliveryDecisions(deliveryID int32, attempt int, decisions []orm.DeliveryDecision) error
+	AddForwardedMessage(source *orm.Chat, message *orm.Message, destination *orm.Chat,
+		destinationMessageIDs []string) error
+}
+
+// Dispatcher delivers messages from the outbox to the messengers.
+// Every destination is served separately, so that an unavailable one does not delay the others.
+type Dispatcher struct {
+	outbox     Outbox
+	messengers map[string]Messenger
+	blobs      *blobstore.Store
+	// files shares attachments which can not be sent to the destination, it is nil if there is no file server
+	files    *fileserver.Server
+	variants variantCalls
+	wake     chan struct{}
+}
+
+func NewDispatcher(outbox Outbox, messengers map[string]Messenger, blobs *blobstore.Store,
+	files *fileserver.Server) *Dispatcher {
+	return &Dispatcher{
+		outbox:     outbox,
+		messengers: messengers,
+		blobs:      blobs,
+		files:      files,
+		variants:   variantCalls{calls: make(map[string]*variantCall)},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Notify makes the dispatcher check the outbox without waiting for the next scheduled check
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers messages from the outbox until the program exits
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(time.Second * config.DispatchIntervalSec)
+	defer ticker.Stop()
+	for {
+		// a full batch means there may be more messages to deliver right away
+		if d.dispatch() == config.DispatchBatchSize {
+			continue
+		}
+		select {
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// errLeaseExpiring is returned when a message could not be sent before the lease of the delivery expires
+var errLeaseExpiring = errors.New("the lease would expire before the message is sent")
+
+// dispatch claims due messages and starts their delivery. It returns the number of claimed messages
+func (d *Dispatcher) dispatch() int {
+	// the lease is counted from before the claim, so that it never ends later than the one saved in the outbox
+	leaseEnd := time.Now().Add(time.Second * config.DeliveryLeaseSec)
+	messages, err := d.outbox.ClaimMessages(config.DispatchBatchSize, config.DispatchPerDestinationMaxCnt,
+		time.Second*config.DeliveryLeaseSec)
+	if err != nil {
+		log.Printf("could not claim messages from the outbox: %v", err)
+		return 0
+	}
+
+	var destinations []chatKey
+	byDestination := make(map[chatKey][]orm.QueuedMessage)
+	for _, message := range messages {
+		key := chatKey{message.Destination.Type, message.Destination.ID}
+		if _, exists := byDestination[key]; !exists {
+			destinations = append(destinations, key)
+		}
+		byDestination[key] = append(byDestination[key], message)
+	}
+	for _, key := range destinations {
+		go d.deliver(byDestination[key], leaseEnd)
+	}
+	return len(messages)
+}
+
+// deliver sends messages to a single destination one by one.
+// After a failure the rest of the messages are postponed as the destination is likely to be unavailable.
+// Messages which could not be sent before the lease ends are released to be claimed again
+func (d *Dispatcher) deliver(messages []orm.QueuedMessage, leaseEnd time.Time) {
+	// more messages of the destination can be claimed as soon as these ones are released
+	defer d.Notify()
+	for i := range messages {
+		message := &messages[i]
+		err := d.send(message, leaseEnd)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, errLeaseExpiring) {
+			var released []int32
+			for _, rest := range messages[i:] {
+				released = append(released, rest.DeliveryID)
+			}
+			if err = d.outbox.PostponeMessages(released, time.Now()); err != nil {
+				log.Printf("could not release deliveries: %v", err)
+			}
+			return
+		}
+		var partialErr *PartialSendError
+		if errors.As(err, &partialErr) {
+			d.settlePartialDelivery(message, partialErr)
+			continue
+		}
+
+		log.Printf("could not deliver message %d to chat %+v (attempt %d): %v",
+			message.DeliveryID, message.Destination, message.Attempts, err)
+		nextAttemptAt := time.Now().Add(retryDelay(message.Attempts))
+		if message.Attempts >= config.MaxDeliveryAttempts {
+			log.Printf("giving up on message %d, moving it to the dead letters", message.DeliveryID)
+			err = d.outbox.MarkMessageDead(message.DeliveryID, err.Error())
+		} else {
+			err = d.outbox.MarkMessageFailed(message.DeliveryID, err.Error(), nextAttemptAt)
+		}
+		if err != nil {
+			log.Printf("could not save failed delivery: %v", err)
+		}
+		var postponed []int32
+		for _, rest := range messages[i+1:] {
+			postponed = append(postponed, rest.DeliveryID)
+		}
+		if err = d.outbox.PostponeMessages(postponed, nextAttemptAt); err != nil {
+			log.Printf("could not postpone deliveries: %v", err)
+		}
+		return
+	}
+}
+
+// send delivers a single message and marks it as delivered. The request to the messenger is given time
+// according to the size of the attachments, and it is not started if it could outlast the lease
+func (d *Dispatcher) send(message *orm.QueuedMessage, leaseEnd time.Time) error {
+	destination := &message.Destination
+	destinationMessenger, exists := d.messengers[destination.Type]
+	if !exists {
+		return fmt.Errorf("unknown messenger %s", destination.Type)
+	}
+
+	prepared := &message.Message
+	if !message.Prepared {
+		prepared = prepareMessage(d.outbox, &message.Message, &message.Source, destination, message.Template)
+	}
+	processed := d.processPhotos(prepared, message.PhotoOptions, destination.Type)
+	fitted, decisions := d.fitLimits(processed, destination.Type)
+	if len(decisions) > 0 {
+		if err := d.outbox.LogDeliveryDecisions(message.DeliveryID, message.Attempts, decisions); err != nil {
+			log.Printf("could not save delivery decisions of message %d: %v", message.DeliveryID, err)
+		}
+	}
+	// messenger services download the files from the storage directly when it is possible
+	for _, attachment := range fitted.Attachments {
+		attachment.DownloadURL = d.blobs.DownloadURL(attachment.URL, attachment.Name)
+	}
+	deadline := time.Now().Add(sendTimeout(fitted))
+	if deadline.After(leaseEnd.Add(-time.Second * config.DeliveryLeaseMarginSec)) {
+		return errLeaseExpiring
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	messageIDs, err := destinationMessenger.SendMessage(ctx, fitted, destination)
+	var partialErr *PartialSendError
+	if errors.As(err, &partialErr) {
+		// the sent parts are edited and deleted along with the source message like the ones of a delivered message
+		d.rememberForwardedMessage(message, prepared, messageIDs)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = d.outbox.MarkMessageDelivered(message.DeliveryID); err != nil {
+		log.Printf("could not mark message %d as delivered: %v", message.DeliveryID, err)
+	}
+	d.rememberForwardedMessage(message, prepared, messageIDs)
+	return nil
+}
+
+// settlePartialDelivery moves the message whose parts were only partially sent to the dead letters right away
+// and logs the failure, sending the message again would duplicate the sent parts
+func (d *Dispatcher) settlePartialDelivery(message *orm.QueuedMessage, partialErr *PartialSendError) {
+	log.Printf("message %d was sent to chat %+v partially, moving it to the dead letters: %s",
+		message.DeliveryID, message.Destination, partialErr.Reason)
+	decision := orm.DeliveryDecision{Action: "partially_sent", Details: partialErr.Reason}
+	if err := d.outbox.LogDeliveryDecisions(message.DeliveryID, message.Attempts,
+		[]orm.DeliveryDecision{decision}); err != nil {
+		log.Printf("could not save delivery decisions of message %d: %v", message.DeliveryID, err)
+	}
+	if err := d.outbox.MarkMessageDead(message.DeliveryID, partialErr.Error()); err != nil {
+		log.Printf("could not save failed delivery: %v", err)
+	}
+}
+
+// rememberForwardedMessage saves ids of the message copies, so that later changes of the source message
+// could be applied to them as well
+func (d *Dispatcher) rememberForwardedMessage(message *orm.QueuedMessage, prepared *orm.Message,
+	destinationMessageIDs []string) {
+	if message.ID == "" || len(destinationMessageIDs) == 0 {
+		return
+	}
+	err := d.outbox.AddForwardedMessage(&message.Source, prepared, &message.Destination, destinationMessageIDs)
+	if err != nil {
+		log.Printf("could not save forwarded message ids: %v", err)
+	}
+}
+
+// sendTimeout returns the time the messenger is given to send the message, including downloading and uploading
+// its attachments. Attachments of unknown size are expected to be the largest possible
+func sendTimeout(message *orm.Message) time.Duration {
+	var size int64
+	for _, attachment := range message.Attachments {
+		if attachment.Size > 0 {
+			size += attachment.Size
+		} else {
+			size += config.AttachmentMaxSize
+		}
+	}
+	timeout := time.Second * time.Duration(config.SendTimeoutSec+size/config.SendMinBytesPerSec)
+	return min(timeout, time.Second*config.SendMaxTimeoutSec)
+}
+
+// retryDelay returns the delay before the next delivery attempt growing exponentially with the number of attempts.
+// The delay is randomized, so that messages failed at once are not retried at once.
+func retryDelay(attempts int) time.Duration {
+	delay := time.Second * config.RetryMaxDelaySec
+	if attempts < 1 {
+		attempts = 1
+	}
+	// the shift is limited to avoid overflows
+	if attempts <= 20 {
+		delay = min(delay, time.Second*config.RetryBaseDelaySec<<(attempts-1))
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,190 @@
+package messenger
+
+import (
+	"Pelmenner/TransferBot/config"
+	"Pelmenner/TransferBot/media"
+	"Pelmenner/TransferBot/orm"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+)
+
+// PartCache keeps parts of split files, so that a file sent to many chats or retried is split once
+type PartCache interface {
+	FindFileParts(sourceHash string, partSize int64) ([]string, error)
+	AddFileParts(sourceHash string, partSize int64, partHashes []string) error
+}
+
+// fitLimits returns the message with attachments changed to fit the limits of the destination messenger.
+// Photos too large are recompressed or sent as files, other files too large are split into parts
+// or replaced with links in the text, as well as attachments of unsupported types. Decisions made for the changed attachments are returned as well
+func (d *Dispatcher) fitLimits(message *orm.Message, messengerType string) (*orm.Message, []orm.DeliveryDecision) {
+	limits, exists := config.MessengerLimits[messengerType]
+	if !exists {
+		return message, nil
+	}
+	fitted := *message
+	fitted.Attachments = nil
+	var decisions []orm.DeliveryDecision
+	var placeholders []string
+	for _, attachment := range message.Attachments {
+		attachments, decision := d.fitAttachment(attachment, limits)
+		fitted.Attachments = append(fitted.Attachments, attachments...)
+		if decision == nil {
+			continue
+		}
+		decisions = append(decisions, *decision)
+		if len(attachments) == 0 {
+			placeholders = append(placeholders, d.placeholder(attachment))
+		}
+	}
+	if len(placeholders) > 0 {
+		if fitted.Text != "" {
+			placeholders = append([]string{fitted.Text}, placeholders...)
+		}
+		fitted.Text = strings.Join(placeholders, "\n\n")
+	}
+	return &fitted, decisions
+}
+
+// fitAttachment returns attachments to send instead of the given one. No attachments are returned
+// if it can not be sent at all. The decision is nil if the attachment is sent as is
+func (d *Dispatcher) fitAttachment(attachment *orm.Attachment,
+	limits config.Limits) ([]*orm.Attachment, *orm.DeliveryDecision) {
+	decision := &orm.DeliveryDecision{Attachment: attachment.Name, Size: attachment.Size}
+	if slices.Contains(limits.UnsupportedTypes, attachment.Type) {
+		decision.Action = "replaced_with_link"
+		decision.Details = fmt.Sprintf("%s attachments are not supported", attachment.Type)
+		return nil, decision
+	}
+	if attachment.Type == "photo" && attachment.Size > limits.MaxPhotoSize {
+		recompressed, err := d.recompressPhoto(attachment, limits.MaxPhotoSize)
+		if err == nil {
+			decision.Action = "recompressed"
+			decision.Details = fmt.Sprintf("recompressed to %s to fit %s", formatSize(recompressed.Size),
+				formatSize(limits.MaxPhotoSize))
+			return []*orm.Attachment{recompressed}, decision
+		}
+		log.Printf("could not recompress photo %s: %v", attachment.URL, err)
+		if attachment.Size <= limits.MaxFileSize {
+			asFile := *attachment
+			asFile.Type = "doc"
+			decision.Action = "sent_as_file"
+			decision.Details = fmt.Sprintf("photo is larger than %s and could not be recompressed: %v",
+				formatSize(limits.MaxPhotoSize), err)
+			return []*orm.Attachment{&asFile}, decision
+		}
+	}
+	if attachment.Size <= limits.MaxFileSize {
+		return []*orm.Attachment{attachment}, nil
+	}
+
+	// parts of media files can not be played, so only documents are split
+	if attachment.Type == "doc" && limits.MaxFileSize > 0 {
+		parts, err := d.splitFile(attachment, limits.MaxFileSize)
+		if err == nil {
+			decision.Action = "split"
+			decision.Details = fmt.Sprintf("split into %d parts to fit %s", len(parts), formatSize(limits.MaxFileSize))
+			return parts, decision
+		}
+		log.Printf("could not split file %s: %v", attachment.URL, err)
+	}
+	decision.Action = "replaced_with_link"
+	decision.Details = fmt.Sprintf("file is larger than %s", formatSize(limits.MaxFileSize))
+	return nil, decision
+}
+
+// recompressPhoto returns a smaller copy of the photo from the blob store
+func (d *Dispatcher) recompressPhoto(attachment *orm.Attachment, maxSize int64) (*orm.Attachment, error) {
+	options := fmt.Sprintf("recompress size=%d quality=%d", maxSize, config.RecompressedPhotoQuality)
+	variant, err := d.imageVariant(attachment.URL, options, func(data []byte) ([]byte, string, error) {
+		res, err := media.Recompress(data, maxSize, config.RecompressedPhotoQuality)
+		return res, "jpeg", err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return variantAttachment(attachment, variant), nil
+}
+
+// splitFile returns parts of the file saved to the blob store, so that they could be joined back after they are received.
+// The parts are cached by their size, so a file is split once for all the destinations and retries.
+// Like recompressed photos, the parts are kept for the grace period of the blob cleanup
+func (d *Dispatcher) splitFile(attachment *orm.Attachment, partSize int64) ([]*orm.Attachment, error) {
+	cnt := (attachment.Size + partSize - 1) / partSize
+	if cnt > config.MaxSplitParts {
+		return nil, fmt.Errorf("file would be split into %d parts, at most %d are allowed",
+			cnt, config.MaxSplitParts)
+	}
+	hashes, err := d.outbox.FindFileParts(attachment.URL, partSize)
+	if err != nil {
+		log.Printf("could not find cached parts of file %s: %v", attachment.URL, err)
+	}
+	if int64(len(hashes)) != cnt {
+		if hashes, err = d.saveFileParts(attachment.URL, attachment.Size, partSize); err != nil {
+			return nil, err
+		}
+		if err = d.outbox.AddFileParts(attachment.URL, partSize, hashes); err != nil {
+			log.Printf("could not cache parts of file %s: %v", attachment.URL, err)
+		}
+	}
+
+	var parts []*orm.Attachment
+	for i, hash := range hashes {
+		parts = append(parts, &orm.Attachment{
+			Type: "doc",
+			URL:  hash,
+			Name: fmt.Sprintf("%s.%03d", attachment.Name, i+1),
+			Size: min(partSize, attachment.Size-int64(i)*partSize),
+		})
+	}
+	return parts, nil
+}
+
+// saveFileParts splits the file stored under the key into parts of the given size
+// and saves them to the blob store. Keys of the parts are returned
+func (d *Dispatcher) saveFileParts(key string, size int64, partSize int64) ([]string, error) {
+	file, err := d.blobs.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var hashes []string
+	for offset := int64(0); offset < size; offset += partSize {
+		hash, err := d.blobs.Put(io.LimitReader(file, min(partSize, size-offset)))
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// placeholder describes an attachment which could not be sent with a link to download it
+func (d *Dispatcher) placeholder(attachment *orm.Attachment) string {
+	text := fmt.Sprintf("File %s (%s) can not be sent here", attachment.Name, formatSize(attachment.Size))
+	if url := d.fileLink(attachment); url != "" {
+		text += ": " + url
+	}
+	return text
+}
+
+// fileLink returns a link to download the attachment from the file server.
+// Without the file server a temporary link to the blob storage is returned if it is supported
+func (d *Dispatcher) fileLink(attachment *orm.Attachment) string {
+	if d.files != nil {
+		link, err := d.files.Link(attachment.URL, attachment.Name)
+		if err == nil {
+			return link
+		}
+		log.Printf("could not share attachment %s: %v", attachment.URL, err)
+	}
+	return d.blobs.DownloadURL(attachment.URL, attachment.Name)
+}
+
+func formatSize(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+}
@@ -0,0 +1,63 @@
+package messenger
+
+import (
+	"Pelmenner/TransferBot/orm"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// resolveOrigin fills origin of the incoming message.
+// Copies made by the bot inherit the origin of the message they were made of,
+// other messages become origins themselves unless the messenger has already provided one.
+func (c *ControllerServer) resolveOrigin(message *orm.Message, chat *orm.Chat) error {
+	if message.ID != "" {
+		origin, err := c.storage.FindMessageOrigin(chat, message.ID)
+		if err != nil {
+			return err
+		}
+		if origin != nil {
+			// copies made before origins were tracked have no origin id
+			message.Origin = *origin
+		}
+	}
+	if message.OriginID == "" {
+		message.OriginID = newOriginID(chat, message.ID)
+	}
+	return nil
+}
+
+func newOriginID(chat *orm.Chat, messageID string) string {
+	if messageID != "" {
+		return fmt.Sprintf("%s/%d/%s", chat.Type, chat.ID, messageID)
+	}
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	return hex.EncodeToString(random)
+}
+
+type chatKey struct {
+	chatType string
+	id       int64
+}
+
+// filterUnvisited returns the subscriptions of chats which the message with given origin has not passed through yet
+func (c *ControllerServer) filterUnvisited(subscriptions []orm.Subscription, source *orm.Chat, originID string) (
+	[]orm.Subscription, error) {
+	visited, err := c.storage.GetVisitedChats(originID)
+	if err != nil {
+		return nil, err
+	}
+	isVisited := map[chatKey]bool{{source.Type, source.ID}: true}
+	for _, chat := range visited {
+		isVisited[chatKey{chat.Type, chat.ID}] = true
+	}
+
+	var res []orm.Subscription
+	for _, subscription := range subscriptions {
+		if !isVisited[chatKey{subscription.Type, subscription.ID}] {
+			res = append(res, subscription)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,147 @@
+package messenger
+
+import (
+	"Pelmenner/TransferBot/config"
+	"Pelmenner/TransferBot/media"
+	"Pelmenner/TransferBot/orm"
+	"bytes"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ImageCache keeps processed copies of images, so that an image sent to many chats is processed once
+type ImageCache interface {
+	FindImageVariant(sourceHash string, options string) (*orm.ImageVariant, error)
+	AddImageVariant(sourceHash string, options string, variant *orm.ImageVariant) error
+}
+
+// imageProcessor makes a copy of an image and returns it with its format.
+// Nil is returned if the image does not need to be changed
+type imageProcessor func(data []byte) ([]byte, string, error)
+
+// variantCalls keeps copies of images being made, so that the same copy is not made concurrently
+type variantCalls struct {
+	mutex sync.Mutex
+	calls map[string]*variantCall
+}
+
+type variantCall struct {
+	done    chan struct{}
+	variant *orm.ImageVariant
+	err     error
+}
+
+// processPhotos returns the message with photos changed according to the options of the subscription
+// and converted to formats shown by the destination messenger. Photos which can not be processed are sent as they are
+func (d *Dispatcher) processPhotos(message *orm.Message, photoOptions string, messengerType string) *orm.Message {
+	options, err := media.ParseOptions(photoOptions)
+	if err != nil {
+		log.Printf("invalid photo options %q: %v", photoOptions, err)
+	}
+	options.Formats = config.MessengerLimits[messengerType].PhotoFormats
+	if options.String() == "" && len(options.Formats) == 0 {
+		return message
+	}
+
+	processed := *message
+	processed.Attachments = nil
+	for _, attachment := range message.Attachments {
+		if attachment.Type == "photo" {
+			variant, err := d.imageVariant(attachment.URL, options.Key(), func(data []byte) ([]byte, string, error) {
+				return media.Process(data, options, config.ProcessedPhotoQuality)
+			})
+			if err != nil {
+				log.Printf("could not process photo %s: %v", attachment.URL, err)
+			} else if variant != nil {
+				attachment = variantAttachment(attachment, variant)
+			}
+		}
+		processed.Attachments = append(processed.Attachments, attachment)
+	}
+	return &processed
+}
+
+// imageVariant returns a copy of the image stored under the key made by the processor.
+// Copies are cached by the options they are made with, and a copy requested concurrently is made once.
+// The copies are not referred to by queued messages, they are kept for the grace period of the blob cleanup.
+// Nil is returned if the image does not need to be changed
+func (d *Dispatcher) imageVariant(key string, options string, process imageProcessor) (*orm.ImageVariant, error) {
+	callKey := key + "\n" + options
+	d.variants.mutex.Lock()
+	if call, exists := d.variants.calls[callKey]; exists {
+		d.variants.mutex.Unlock()
+		<-call.done
+		return call.variant, call.err
+	}
+	call := &variantCall{done: make(chan struct{})}
+	d.variants.calls[callKey] = call
+	d.variants.mutex.Unlock()
+
+	call.variant, call.err = d.makeImageVariant(key, options, process)
+
+	d.variants.mutex.Lock()
+	delete(d.variants.calls, callKey)
+	d.variants.mutex.Unlock()
+	close(call.done)
+	return call.variant, call.err
+}
+
+func (d *Dispatcher) makeImageVariant(key string, options string, process imageProcessor) (*orm.ImageVariant, error) {
+	variant, err := d.outbox.FindImageVariant(key, options)
+	if err != nil {
+		log.Printf("could not find cached copy of image %s: %v", key, err)
+	} else if variant != nil {
+		// an image which does not need to be changed is cached as its own copy
+		if variant.Hash == key {
+			return nil, nil
+		}
+		return variant, nil
+	}
+
+	file, err := d.blobs.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(file, config.AttachmentMaxSize))
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	processed, format, err := process(data)
+	if err != nil {
+		return nil, err
+	}
+
+	variant = &orm.ImageVariant{Hash: key, Size: int64(len(data))}
+	if processed != nil {
+		hash, err := d.blobs.Put(bytes.NewReader(processed))
+		if err != nil {
+			return nil, err
+		}
+		variant = &orm.ImageVariant{Hash: hash, Size: int64(len(processed)), Format: format}
+	}
+	if err = d.outbox.AddImageVariant(key, options, variant); err != nil {
+		log.Printf("could not cache copy of image %s: %v", key, err)
+	}
+	if processed == nil {
+		return nil, nil
+	}
+	return variant, nil
+}
+
+// variantAttachment returns the attachment with its file replaced with the copy
+func variantAttachment(attachment *orm.Attachment, variant *orm.ImageVariant) *orm.Attachment {
+	extension := variant.Format
+	if extension == "jpeg" {
+		extension = "jpg"
+	}
+	return &orm.Attachment{
+		Type: attachment.Type,
+		URL:  variant.Hash,
+		Name: strings.TrimSuffix(attachment.Name, filepath.Ext(attachment.Name)) + "." + extension,
+		Size: variant.Size,
+	}
+}
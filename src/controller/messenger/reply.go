@@ -0,0 +1,66 @@
+package messenger
+
+import (
+	"Pelmenner/TransferBot/config"
+	"Pelmenner/TransferBot/format"
+	"Pelmenner/TransferBot/orm"
+	"log"
+	"strings"
+)
+
+// copyFinder looks for copies of the messages made by the bot
+type copyFinder interface {
+	FindMessageCopy(source *orm.Chat, sourceMessageID string, destination *orm.Chat) (string, error)
+}
+
+// prepareMessage adapts the message from the source chat to be sent to the destination one
+// and formats its text with the template of the subscription
+func prepareMessage(copies copyFinder, message *orm.Message, source, destination *orm.Chat,
+	template string) *orm.Message {
+	prepared := *message
+	if message.ReplyTo != nil {
+		var quote string
+		prepared.ReplyTo, quote = resolveReply(copies, message, source, destination)
+		prepared.Text = quote + message.Text
+		prepared.Entities = format.ShiftEntities(message.Entities, quote)
+	}
+
+	text, entities, err := format.Render(template, &prepared, source)
+	if err != nil {
+		log.Printf("could not render template of subscription of %+v, using the default one: %v", destination, err)
+		text, entities, err = format.Render(format.DefaultTemplate, &prepared, source)
+	}
+	if err == nil {
+		prepared.Text = text
+		prepared.Entities = entities
+	}
+	return &prepared
+}
+
+// resolveReply finds the replied message in the destination chat.
+// If it is unknown there, the reply is replaced with a quote of the replied message to put before the text.
+func resolveReply(copies copyFinder, message *orm.Message, source, destination *orm.Chat) (*orm.Reply, string) {
+	reply := message.ReplyTo
+	if reply.MessageID != "" {
+		messageID, err := copies.FindMessageCopy(source, reply.MessageID, destination)
+		if err != nil {
+			log.Printf("could not find copy of the replied message: %v", err)
+		} else if messageID != "" {
+			return &orm.Reply{MessageID: messageID, Sender: reply.Sender, Text: reply.Text}, ""
+		}
+	}
+	return nil, quoteReply(reply)
+}
+
+// quoteReply returns a single line with the sender and the beginning of the replied message
+func quoteReply(reply *orm.Reply) string {
+	excerpt := []rune(strings.Join(strings.Fields(reply.Text), " "))
+	if len(excerpt) > config.ReplyQuoteMaxLength {
+		excerpt = append(excerpt[:config.ReplyQuoteMaxLength], '…')
+	}
+	quote := string(excerpt)
+	if reply.Name != "" {
+		quote = strings.TrimSpace(reply.Name + ": " + quote)
+	}
+	return "> " + quote + "\n"
+}
@@ -1,42 +1,72 @@
 package messenger
 
 import (
+	"Pelmenner/TransferBot/blobstore"
+	"Pelmenner/TransferBot/config"
+	"Pelmenner/TransferBot/filter"
+	"Pelmenner/TransferBot/format"
+	"Pelmenner/TransferBot/media"
 	"Pelmenner/TransferBot/orm"
 	"context"
+	"errors"
 	"github.com/Pelmenner/TransferBot/proto/controller"
 	"github.com/Pelmenner/TransferBot/proto/messenger"
 	"github.com/golang/protobuf/ptypes/empty"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"log"
-	"os"
-	"path/filepath"
 )
 
 type Storage interface {
-	GetUnusedAttachments() ([]*orm.Attachment, error)
+	copyFinder
 	Unsubscribe(subscriber *orm.Chat, subscriptionToken string) error
 	Subscribe(subscriber *orm.Chat, subscriptionToken string) error
-	GetUnsentMessages(maxCnt int) ([]orm.QueuedMessage, error)
-	AddUnsentMessage(message orm.QueuedMessage) error
+	Bridge(chat *orm.Chat, token string) error
+	EnqueueMessages(messages []orm.QueuedMessage) error
 	GetChat(chatID int64, chatType string) (*orm.Chat, error)
 	GetChatToken(chatID int64, chatType string) (string, error)
 	CreateChat(chat *orm.Chat) (*orm.Chat, error)
-	FindSubscribedChats(chat orm.Chat) ([]orm.Chat, error)
+	FindSubscriptions(chat orm.Chat) ([]orm.Subscription, error)
+	AddFilter(subscriber *orm.Chat, subscriptionToken string, rule string) error
+	RemoveFilter(subscriber *orm.Chat, subscriptionToken string, rule string) error
+	GetFilters(subscriber *orm.Chat, subscriptionToken string) ([]string, error)
+	SetTemplate(subscriber *orm.Chat, subscriptionToken string, template string) error
+	GetTemplate(subscriber *orm.Chat, subscriptionToken string) (string, error)
+	SetPhotoOptions(subscriber *orm.Chat, subscriptionToken string, options string) error
+	GetPhotoOptions(subscriber *orm.Chat, subscriptionToken string) (string, error)
+	GetDeadLetters(filter orm.DeadLetterFilter, maxCnt int) ([]orm.DeadLetter, error)
+	ReplayDeadLetters(filter orm.DeadLetterFilter) (int, error)
+	PurgeDeadLetters(filter orm.DeadLetterFilter) (int, error)
+	GetForwardedMessages(source *orm.Chat, sourceMessageID string) ([]orm.ForwardedMessage, error)
+	DeleteForwardedMessages(source *orm.Chat, sourceMessageID string) error
+	RemoveUnsentMessages(source *orm.Chat, sourceMessageID string) error
+	FindMessageOrigin(chat *orm.Chat, messageID string) (*orm.Origin, error)
+	GetVisitedChats(originID string) ([]orm.Chat, error)
+	SubscriptionCreatesCycle(subscriber *orm.Chat, subscriptionToken string, maxDepth int) (bool, error)
 }
 
 type Messenger interface {
-	SendMessage(*orm.Message, *orm.Chat) error
+	// SendMessage sends the message to the chat and returns ids of the created messages.
+	// The messenger stops sending when the context is done. If only some parts of the message were sent,
+	// their ids are returned with PartialSendError
+	SendMessage(context.Context, *orm.Message, *orm.Chat) ([]string, error)
+	// EditMessage replaces contents of the previously sent messages with the given ones
+	EditMessage(*orm.Message, *orm.Chat, []string) error
+	// DeleteMessage deletes previously sent messages from the chat
+	DeleteMessage(*orm.Chat, []string) error
 }
 
 type ControllerServer struct {
 	controller.UnimplementedControllerServer
 	messengers map[string]Messenger
 	storage    Storage
+	dispatcher *Dispatcher
+	blobs      *blobstore.Store
 }
 
-func NewControllerServer(storage Storage, messengers map[string]Messenger) controller.ControllerServer {
-	return &ControllerServer{storage: storage, messengers: messengers}
+func NewControllerServer(storage Storage, messengers map[string]Messenger,
+	dispatcher *Dispatcher, blobs *blobstore.Store) controller.ControllerServer {
+	return &ControllerServer{storage: storage, messengers: messengers, dispatcher: dispatcher, blobs: blobs}
 }
 
 func (c *ControllerServer) HandleNewMessage(_ context.Context, request *controller.HandleMessageRequest) (
@@ -44,25 +74,107 @@ func (c *ControllerServer) HandleNewMessage(_ context.Context, request *controll
 	message := messageFromProto(request.Message)
 	chat := chatFromProto(request.Chat)
 
-	subscribed, err := c.storage.FindSubscribedChats(*chat)
+	if err := c.resolveOrigin(message, chat); err != nil {
+		log.Printf("could not resolve message origin: %v", err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	if message.HopCount >= config.MaxHopCount {
+		log.Printf("message %s was relayed too many times, dropping it", message.OriginID)
+		return &empty.Empty{}, nil
+	}
+
+	subscribed, err := c.storage.FindSubscriptions(*chat)
 	if err != nil {
-		log.Printf("could not find subscribed chats: %v", err)
+		log.Printf("could not find subscriptions: %v", err)
 		return &empty.Empty{}, status.Error(codes.Unknown, "something went wrong")
 	}
-	sentToAllSubscribers := true
+	// the copies must not come back to the chats they have already been to, otherwise they would go in circles
+	subscribed, err = c.filterUnvisited(subscribed, chat, message.OriginID)
+	if err != nil {
+		log.Printf("could not find visited chats: %v", err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	message.HopCount++
+	var queued []orm.QueuedMessage
 	for _, subscription := range subscribed {
-		if err = c.messengers[subscription.Type].SendMessage(message, &subscription); err != nil {
-			log.Printf("could not send message %+v: %v", message, err)
-			if err = c.storage.AddUnsentMessage(orm.QueuedMessage{
-				Message: *message, Destination: subscription}); err != nil {
-				log.Printf("could not save unsent message: %v", err)
-			}
-			sentToAllSubscribers = false
+		if !filter.Matches(filter.ParseAll(subscription.Filters), message) {
+			continue
+		}
+		queued = append(queued, orm.QueuedMessage{Message: *message, Source: *chat, Destination: subscription.Chat})
+	}
+	// files of a message nobody receives are not referenced, so they are removed by the blob store cleanup
+	if len(queued) == 0 {
+		return &empty.Empty{}, nil
+	}
+
+	// the messages are delivered by the dispatcher, the files are released as soon as they are delivered
+	if err = c.storage.EnqueueMessages(queued); err != nil {
+		log.Printf("could not enqueue message: %v", err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	c.dispatcher.Notify()
+	return &empty.Empty{}, nil
+}
+
+func (c *ControllerServer) HandleEditedMessage(_ context.Context, request *controller.HandleMessageRequest) (
+	*empty.Empty, error) {
+	message := messageFromProto(request.Message)
+	chat := chatFromProto(request.Chat)
+
+	forwarded, err := c.storage.GetForwardedMessages(chat, message.ID)
+	if err != nil {
+		log.Printf("could not find forwarded messages: %v", err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	for _, copies := range forwarded {
+		destination := copies.Destination
+		destinationMessenger, exists := c.messengers[destination.Type]
+		if !exists {
+			log.Printf("could not edit message %s in chat %+v: unknown messenger", message.ID, destination)
+			continue
+		}
+		preparedMessage := prepareMessage(c.storage, message, chat, &destination, copies.Template)
+		err = destinationMessenger.EditMessage(preparedMessage, &destination, copies.MessageIDs)
+		if err != nil {
+			log.Printf("could not edit message %s in chat %+v: %v", message.ID, destination, err)
+		}
+	}
+	return &empty.Empty{}, nil
+}
+
+func (c *ControllerServer) HandleDeletedMessage(_ context.Context, request *controller.HandleDeletedMessageRequest) (
+	*empty.Empty, error) {
+	chat := chatFromProto(request.Chat)
+	if request.MessageId == "" {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "message id is not specified")
+	}
+
+	if err := c.storage.RemoveUnsentMessages(chat, request.MessageId); err != nil {
+		log.Printf("could not remove unsent messages: %v", err)
+	}
+	forwarded, err := c.storage.GetForwardedMessages(chat, request.MessageId)
+	if err != nil {
+		log.Printf("could not find forwarded messages: %v", err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "something went wrong")
+	}
+	deletedEverywhere := true
+	for _, copies := range forwarded {
+		destination := copies.Destination
+		destinationMessenger, exists := c.messengers[destination.Type]
+		if !exists {
+			log.Printf("could not delete message %s from chat %+v: unknown messenger", request.MessageId, destination)
+			deletedEverywhere = false
+			continue
+		}
+		if err = destinationMessenger.DeleteMessage(&destination, copies.MessageIDs); err != nil {
+			log.Printf("could not delete message %s from chat %+v: %v", request.MessageId, destination, err)
+			deletedEverywhere = false
 		}
 	}
-	if sentToAllSubscribers {
-		for _, attachment := range message.Attachments {
-			deleteAttachment(attachment)
+	// the ids are kept on failure, so that the deletion could be requested once again
+	if deletedEverywhere {
+		if err = c.storage.DeleteForwardedMessages(chat, request.MessageId); err != nil {
+			log.Printf("could not delete forwarded message ids: %v", err)
 		}
 	}
 	return &empty.Empty{}, nil
@@ -73,13 +185,39 @@ func (c *ControllerServer) Subscribe(_ context.Context, request *controller.Subs
 	subscriber := chatFromProto(request.Chat)
 	subscriptionToken := request.Token
 	log.Printf("subscribe %+v on chat with token %s", subscriber, subscriptionToken)
-	err := c.storage.Subscribe(subscriber, subscriptionToken)
+	createsCycle, err := c.storage.SubscriptionCreatesCycle(subscriber, subscriptionToken, config.MaxHopCount)
+	if err != nil {
+		log.Printf("could not check subscription for cycles: %v", err)
+		return &controller.SubscribeResponse{}, status.Error(codes.Internal, "could not subscribe on chat")
+	}
+	err = c.storage.Subscribe(subscriber, subscriptionToken)
 
 	if err != nil {
 		log.Printf("subscription failed: %v", err)
 		return &controller.SubscribeResponse{}, status.Error(400, "could not subscribe on chat with given token")
 	}
-	return &controller.SubscribeResponse{}, nil
+	response := &controller.SubscribeResponse{}
+	// cycles are not rejected, since bridges are cycles as well and loops are stopped by the origins of messages,
+	// the warning is shown to the user in the reply to the command
+	if createsCycle {
+		response.Warning = "messages of this chat already reach the subscribed one through other subscriptions, " +
+			"so they will go in a circle; every message is still delivered to each chat at most once"
+	}
+	return response, nil
+}
+
+func (c *ControllerServer) Bridge(_ context.Context, request *controller.BridgeRequest) (
+	*controller.BridgeResponse, error) {
+	chat := chatFromProto(request.Chat)
+	log.Printf("bridge %+v with chat with token %s", chat, request.Token)
+	err := c.storage.Bridge(chat, request.Token)
+
+	if err != nil {
+		log.Printf("bridging failed: %v", err)
+		return &controller.BridgeResponse{}, status.Error(codes.InvalidArgument,
+			"could not bridge with the chat with given token")
+	}
+	return &controller.BridgeResponse{}, nil
 }
 
 func (c *ControllerServer) Unsubscribe(_ context.Context, request *controller.UnsubscribeRequest) (
@@ -98,24 +236,118 @@ func (c *ControllerServer) Unsubscribe(_ context.Context, request *controller.Un
 	return &controller.UnsubscribeResponse{}, nil
 }
 
-func (c *ControllerServer) GetChatToken(_ context.Context, request *controller.GetChatTokenRequest) (
-	*controller.GetChatTokenResponse, error) {
-	token, err := c.storage.GetChatToken(request.ChatID, request.Messenger)
+func (c *ControllerServer) AddFilter(_ context.Context, request *controller.FilterRequest) (*empty.Empty, error) {
+	subscriber := chatFromProto(request.Chat)
+	rule, err := filter.Parse(request.Rule)
 	if err != nil {
-		return &controller.GetChatTokenResponse{}, status.Error(codes.NotFound, "could not find the chat")
+		return &empty.Empty{}, status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
 	}
-	return &controller.GetChatTokenResponse{Token: token}, nil
+
+	log.Printf("add filter %s to subscription of %+v on chat with token %s", rule, subscriber, request.Token)
+	if err = c.storage.AddFilter(subscriber, request.Token, rule.String()); err != nil {
+		return &empty.Empty{}, subscriptionSettingsError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+func (c *ControllerServer) RemoveFilter(_ context.Context, request *controller.FilterRequest) (*empty.Empty, error) {
+	subscriber := chatFromProto(request.Chat)
+	rule, err := filter.Parse(request.Rule)
+	if err != nil {
+		return &empty.Empty{}, status.Errorf(codes.InvalidArgument, "invalid filter: %v", err)
+	}
+
+	log.Printf("remove filter %s from subscription of %+v on chat with token %s", rule, subscriber, request.Token)
+	if err = c.storage.RemoveFilter(subscriber, request.Token, rule.String()); err != nil {
+		return &empty.Empty{}, subscriptionSettingsError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+func (c *ControllerServer) ListFilters(_ context.Context, request *controller.ListFiltersRequest) (
+	*controller.ListFiltersResponse, error) {
+	subscriber := chatFromProto(request.Chat)
+	rules, err := c.storage.GetFilters(subscriber, request.Token)
+	if err != nil {
+		return &controller.ListFiltersResponse{}, subscriptionSettingsError(err)
+	}
+	return &controller.ListFiltersResponse{Rules: rules}, nil
+}
+
+func (c *ControllerServer) SetTemplate(_ context.Context, request *controller.SetTemplateRequest) (
+	*empty.Empty, error) {
+	subscriber := chatFromProto(request.Chat)
+	if err := format.Validate(request.Template); err != nil {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	log.Printf("set template of subscription of %+v on chat with token %s", subscriber, request.Token)
+	if err := c.storage.SetTemplate(subscriber, request.Token, request.Template); err != nil {
+		return &empty.Empty{}, subscriptionSettingsError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+func (c *ControllerServer) GetTemplate(_ context.Context, request *controller.GetTemplateRequest) (
+	*controller.GetTemplateResponse, error) {
+	subscriber := chatFromProto(request.Chat)
+	template, err := c.storage.GetTemplate(subscriber, request.Token)
+	if err != nil {
+		return &controller.GetTemplateResponse{}, subscriptionSettingsError(err)
+	}
+	if template == "" {
+		template = format.DefaultTemplate
+	}
+	return &controller.GetTemplateResponse{Template: template}, nil
+}
+
+func (c *ControllerServer) SetPhotoOptions(_ context.Context, request *controller.SetPhotoOptionsRequest) (
+	*empty.Empty, error) {
+	subscriber := chatFromProto(request.Chat)
+	options, err := media.ParseOptions(request.Options)
+	if err != nil {
+		return &empty.Empty{}, status.Errorf(codes.InvalidArgument, "invalid photo options: %v", err)
+	}
+
+	log.Printf("set photo options %s of subscription of %+v on chat with token %s",
+		options, subscriber, request.Token)
+	if err = c.storage.SetPhotoOptions(subscriber, request.Token, options.String()); err != nil {
+		return &empty.Empty{}, subscriptionSettingsError(err)
+	}
+	return &empty.Empty{}, nil
 }
 
-func deleteAttachment(attachment *orm.Attachment) {
-	err := os.Remove(attachment.URL)
+func (c *ControllerServer) GetPhotoOptions(_ context.Context, request *controller.GetPhotoOptionsRequest) (
+	*controller.GetPhotoOptionsResponse, error) {
+	subscriber := chatFromProto(request.Chat)
+	options, err := c.storage.GetPhotoOptions(subscriber, request.Token)
 	if err != nil {
-		log.Println("could not delete file", attachment.URL, err)
+		return &controller.GetPhotoOptionsResponse{}, subscriptionSettingsError(err)
 	}
-	err = os.Remove(filepath.Dir(attachment.URL))
+	return &controller.GetPhotoOptionsResponse{Options: options}, nil
+}
+
+// subscriptionSettingsError converts storage errors of subscription settings management
+// to the ones that can be shown to the user
+func subscriptionSettingsError(err error) error {
+	switch {
+	case errors.Is(err, orm.ErrNoSubscription):
+		return status.Error(codes.NotFound, "this chat is not subscribed on the chat with given token")
+	case errors.Is(err, orm.ErrNoFilter):
+		return status.Error(codes.NotFound, "the subscription has no such filter")
+	default:
+		log.Printf("could not access subscription settings: %v", err)
+		return status.Error(codes.Unknown, "something went wrong")
+	}
+}
+
+func (c *ControllerServer) GetChatToken(_ context.Context, request *controller.GetChatTokenRequest) (
+	*controller.GetChatTokenResponse, error) {
+	token, err := c.storage.GetChatToken(request.ChatID, request.Messenger)
 	if err != nil {
-		log.Println("could not delete directory", filepath.Dir(attachment.URL), err)
+		return &controller.GetChatTokenResponse{}, status.Error(codes.NotFound, "could not find the chat")
 	}
+	return &controller.GetChatTokenResponse{Token: token}, nil
 }
 
 func messageFromProto(message *messenger.Message) *orm.Message {
@@ -124,23 +356,77 @@ func messageFromProto(message *messenger.Message) *orm.Message {
 	}
 	ormSender := senderFromProto(message.Sender)
 	ormMessage := orm.Message{
+		ID:     message.Id,
 		Text:   message.Text,
 		Sender: *ormSender,
+		Origin: orm.Origin{
+			OriginID: message.OriginId,
+			HopCount: message.HopCount,
+		},
+		Content: contentFromProto(message),
 	}
 	for _, attachment := range message.Attachments {
 		ormMessage.Attachments = append(ormMessage.Attachments, attachmentFromProto(attachment))
 	}
+	if message.ReplyTo != nil {
+		ormMessage.ReplyTo = replyFromProto(message.ReplyTo)
+	}
 	return &ormMessage
 }
 
+func contentFromProto(message *messenger.Message) orm.Content {
+	var content orm.Content
+	if location := message.Location; location != nil {
+		content.Location = &orm.Location{
+			Latitude:  location.Latitude,
+			Longitude: location.Longitude,
+			Title:     location.Title,
+			Address:   location.Address,
+		}
+	}
+	if contact := message.Contact; contact != nil {
+		content.Contact = &orm.Contact{Name: contact.Name, Phone: contact.Phone}
+	}
+	if poll := message.Poll; poll != nil {
+		content.Poll = &orm.Poll{
+			Question:        poll.Question,
+			Options:         poll.Options,
+			MultipleAnswers: poll.MultipleAnswers,
+			Anonymous:       poll.Anonymous,
+		}
+	}
+	for _, entity := range message.Entities {
+		content.Entities = append(content.Entities, orm.Entity{
+			Type:     entity.Type,
+			Offset:   entity.Offset,
+			Length:   entity.Length,
+			URL:      entity.Url,
+			Language: entity.Language,
+		})
+	}
+	return content
+}
+
+func replyFromProto(reply *messenger.Reply) *orm.Reply {
+	ormReply := orm.Reply{
+		MessageID: reply.MessageId,
+		Text:      reply.Text,
+	}
+	if reply.Sender != nil {
+		ormReply.Sender = *senderFromProto(reply.Sender)
+	}
+	return &ormReply
+}
+
 func chatFromProto(chat *messenger.Chat) *orm.Chat {
 	if chat == nil {
 		return nil
 	}
 	return &orm.Chat{
-		ID:   chat.Id,
-		Type: chat.Type,
-		Name: chat.Name,
+		ID:      chat.Id,
+		Type:    chat.Type,
+		Name:    chat.Name,
+		Address: chat.Address,
 	}
 }
 
@@ -151,6 +437,7 @@ func attachmentFromProto(attachment *messenger.Attachment) *orm.Attachment {
 	return &orm.Attachment{
 		Type: attachment.Type,
 		URL:  attachment.Url,
+		Name: attachment.Name,
 	}
 }
 
@@ -160,6 +447,6 @@ func senderFromProto(sender *messenger.Sender) *orm.Sender {
 	}
 	return &orm.Sender{
 		Name: sender.Name,
-		Chat: sender.Chat.Name,
+		Chat: sender.Chat.GetName(),
 	}
 }
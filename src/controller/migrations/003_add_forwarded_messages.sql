@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS ForwardedMessages
+(
+    source_chat            INTEGER NOT NULL,
+    source_message_id      TEXT    NOT NULL,
+    destination_chat       INTEGER NOT NULL,
+    destination_message_id TEXT    NOT NULL,
+    internal_id            SERIAL PRIMARY KEY,
+    FOREIGN KEY (source_chat) REFERENCES Chats (internal_id),
+    FOREIGN KEY (destination_chat) REFERENCES Chats (internal_id)
+);
+
+CREATE INDEX IF NOT EXISTS forwarded_messages_source
+    ON ForwardedMessages (source_chat, source_message_id);
+
+ALTER TABLE Messages
+ADD COLUMN source_chat INTEGER REFERENCES Chats (internal_id),
+ADD COLUMN source_message_id TEXT;
+
+-- +goose Down
+ALTER TABLE Messages
+DROP COLUMN source_message_id,
+DROP COLUMN source_chat;
+
+DROP INDEX IF EXISTS forwarded_messages_source;
+DROP TABLE IF EXISTS ForwardedMessages;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE Messages
+ADD COLUMN reply_to TEXT;
+
+-- +goose Down
+ALTER TABLE Messages
+DROP COLUMN reply_to;
@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE ForwardedMessages
+ADD COLUMN origin_id TEXT,
+ADD COLUMN hop_count INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS forwarded_messages_origin
+    ON ForwardedMessages (origin_id);
+
+CREATE INDEX IF NOT EXISTS forwarded_messages_destination
+    ON ForwardedMessages (destination_chat, destination_message_id);
+
+ALTER TABLE Messages
+ADD COLUMN origin_id TEXT,
+ADD COLUMN hop_count INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE Messages
+DROP COLUMN hop_count,
+DROP COLUMN origin_id;
+
+DROP INDEX IF EXISTS forwarded_messages_destination;
+DROP INDEX IF EXISTS forwarded_messages_origin;
+
+ALTER TABLE ForwardedMessages
+DROP COLUMN hop_count,
+DROP COLUMN origin_id;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS SubscriptionFilters
+(
+    source_chat      INTEGER NOT NULL,
+    destination_chat INTEGER NOT NULL,
+    rule             TEXT    NOT NULL,
+    internal_id      SERIAL PRIMARY KEY,
+    FOREIGN KEY (source_chat, destination_chat)
+        REFERENCES Subscriptions (source_chat, destination_chat) ON DELETE CASCADE,
+    CONSTRAINT single_filter UNIQUE (source_chat, destination_chat, rule)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS SubscriptionFilters;
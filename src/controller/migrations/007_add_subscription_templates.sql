@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE Subscriptions
+ADD COLUMN template TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE Subscriptions
+DROP COLUMN template;
@@ -0,0 +1,42 @@
+-- +goose Up
+-- rows queued before the outbox are already formatted for their destinations
+ALTER TABLE Messages
+ADD COLUMN prepared          BOOLEAN     NOT NULL DEFAULT TRUE,
+ADD COLUMN reply_sender      TEXT,
+ADD COLUMN reply_sender_chat TEXT,
+ADD COLUMN reply_text        TEXT,
+ADD COLUMN attempts          INTEGER     NOT NULL DEFAULT 0,
+ADD COLUMN next_attempt_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD COLUMN leased_until      TIMESTAMPTZ,
+ADD COLUMN last_error        TEXT,
+ADD COLUMN created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+ADD COLUMN delivered_at      TIMESTAMPTZ;
+
+ALTER TABLE Messages
+ALTER COLUMN prepared SET DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS messages_pending
+    ON Messages (next_attempt_at) WHERE delivered_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS messages_delivered
+    ON Messages (delivered_at) WHERE delivered_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS attachments_parent
+    ON Attachments (parent_message);
+
+-- +goose Down
+DROP INDEX IF EXISTS attachments_parent;
+DROP INDEX IF EXISTS messages_delivered;
+DROP INDEX IF EXISTS messages_pending;
+
+ALTER TABLE Messages
+DROP COLUMN delivered_at,
+DROP COLUMN created_at,
+DROP COLUMN last_error,
+DROP COLUMN leased_until,
+DROP COLUMN next_attempt_at,
+DROP COLUMN attempts,
+DROP COLUMN reply_text,
+DROP COLUMN reply_sender_chat,
+DROP COLUMN reply_sender,
+DROP COLUMN prepared;
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS DeadLetters
+(
+    message   INTEGER     PRIMARY KEY,
+    failed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (message) REFERENCES Messages (internal_id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS DeadLetters;
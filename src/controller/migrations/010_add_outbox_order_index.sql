@@ -0,0 +1,6 @@
+-- +goose Up
+CREATE INDEX IF NOT EXISTS messages_pending_pair
+    ON Messages (destination_chat, source_chat, internal_id) WHERE delivered_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS messages_pending_pair;
@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS Blobs
+(
+    hash       TEXT PRIMARY KEY,
+    size       BIGINT      NOT NULL DEFAULT 0,
+    ref_count  INTEGER     NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS blobs_unused
+    ON Blobs (updated_at) WHERE ref_count <= 0;
+
+ALTER TABLE Attachments
+    ADD COLUMN IF NOT EXISTS data_name TEXT NOT NULL DEFAULT '';
+
+-- names of the attachments stored before are the last part of their urls
+UPDATE Attachments SET data_name = regexp_replace(data_url, '^.*/', '');
+
+-- urls of the attachments stored before are paths of their files, either absolute ones in the downloads directory
+-- or relative to the attachments directory. The controller moves the files still referred to by queued messages
+-- to the blob store when it starts and removes the rest of them along with their attachments
+
+-- +goose Down
+ALTER TABLE Attachments
+    DROP COLUMN IF EXISTS data_name;
+
+DROP INDEX IF EXISTS blobs_unused;
+DROP TABLE IF EXISTS Blobs;
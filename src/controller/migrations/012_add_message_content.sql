@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE Messages
+    ADD COLUMN IF NOT EXISTS content JSONB;
+
+-- +goose Down
+ALTER TABLE Messages
+    DROP COLUMN IF EXISTS content;
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS DeliveryLog
+(
+    internal_id SERIAL      PRIMARY KEY,
+    message     INTEGER     NOT NULL,
+    attempt     INTEGER     NOT NULL,
+    attachment  TEXT        NOT NULL,
+    size        BIGINT      NOT NULL,
+    action      TEXT        NOT NULL,
+    details     TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    FOREIGN KEY (message) REFERENCES Messages (internal_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS delivery_log_message
+    ON DeliveryLog (message);
+
+-- +goose Down
+DROP INDEX IF EXISTS delivery_log_message;
+DROP TABLE IF EXISTS DeliveryLog;
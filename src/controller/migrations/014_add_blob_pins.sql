@@ -0,0 +1,8 @@
+-- +goose Up
+-- files shared by links are kept until the links expire even if no queued message refers to them
+ALTER TABLE Blobs
+    ADD COLUMN IF NOT EXISTS pinned_until TIMESTAMPTZ;
+
+-- +goose Down
+ALTER TABLE Blobs
+    DROP COLUMN IF EXISTS pinned_until;
@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE Subscriptions
+ADD COLUMN photo_options TEXT NOT NULL DEFAULT '';
+
+-- processed copies of images are made once for all the messages they are sent with
+CREATE TABLE IF NOT EXISTS ImageVariants
+(
+    source_hash  TEXT        NOT NULL,
+    options      TEXT        NOT NULL,
+    variant_hash TEXT        NOT NULL,
+    format       TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (source_hash, options),
+    FOREIGN KEY (source_hash) REFERENCES Blobs (hash) ON DELETE CASCADE,
+    FOREIGN KEY (variant_hash) REFERENCES Blobs (hash) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS image_variants_variant
+    ON ImageVariants (variant_hash);
+
+-- +goose Down
+DROP INDEX IF EXISTS image_variants_variant;
+DROP TABLE IF EXISTS ImageVariants;
+
+ALTER TABLE Subscriptions
+DROP COLUMN photo_options;
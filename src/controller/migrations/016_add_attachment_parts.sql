@@ -0,0 +1,19 @@
+-- +goose Up
+-- parts of files split to fit the limits of messengers are made once for all the destinations and retries
+CREATE TABLE IF NOT EXISTS AttachmentParts
+(
+    source_hash TEXT    NOT NULL,
+    part_size   BIGINT  NOT NULL,
+    part_number INTEGER NOT NULL,
+    part_hash   TEXT    NOT NULL,
+    PRIMARY KEY (source_hash, part_size, part_number),
+    FOREIGN KEY (source_hash) REFERENCES Blobs (hash) ON DELETE CASCADE,
+    FOREIGN KEY (part_hash) REFERENCES Blobs (hash) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS attachment_parts_part
+    ON AttachmentParts (part_hash);
+
+-- +goose Down
+DROP INDEX IF EXISTS attachment_parts_part;
+DROP TABLE IF EXISTS AttachmentParts;
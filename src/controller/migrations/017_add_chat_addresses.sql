@@ -0,0 +1,8 @@
+-- +goose Up
+-- messengers whose chat ids are not numeric, e.g. XMPP and IRC, need the original ids of chats to send messages to them
+ALTER TABLE Chats
+ADD COLUMN address TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE Chats
+DROP COLUMN address;
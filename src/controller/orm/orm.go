@@ -5,6 +5,8 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -15,7 +17,13 @@ import (
 
 type Attachment struct {
 	Type string
+	// URL is the key of the attachment file in the blob store
 	URL  string
+	Name string
+	// Size is the size of the file in bytes, it is zero if unknown
+	Size int64
+	// DownloadURL is a temporary link to the file in the blob store, it is not saved
+	DownloadURL string
 }
 
 type Sender struct {
@@ -23,16 +31,94 @@ type Sender struct {
 	Chat string
 }
 
+type Reply struct {
+	MessageID string
+	Sender
+	Text string
+}
+
 type Message struct {
+	ID   string
 	Text string
 	Sender
 	Attachments []*Attachment
+	ReplyTo     *Reply
+	Origin
+	Content
+}
+
+// Origin identifies the message which was initially sent by a user and tracks how many times it was relayed
+type Origin struct {
+	OriginID string
+	HopCount int32
+}
+
+// Content is structured content of a message besides its text and attachments, it is stored as JSON
+type Content struct {
+	Location *Location `json:"location,omitempty"`
+	Contact  *Contact  `json:"contact,omitempty"`
+	Poll     *Poll     `json:"poll,omitempty"`
+	// Entities are formatted parts of the text
+	Entities []Entity `json:"entities,omitempty"`
+}
+
+// Entity is a formatted part of a text, its offset and length are in UTF-16 code units
+type Entity struct {
+	Type     string `json:"type"`
+	Offset   int32  `json:"offset"`
+	Length   int32  `json:"length"`
+	URL      string `json:"url,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Title     string  `json:"title,omitempty"`
+	Address   string  `json:"address,omitempty"`
+}
+
+type Contact struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+type Poll struct {
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	MultipleAnswers bool     `json:"multiple_answers,omitempty"`
+	Anonymous       bool     `json:"anonymous,omitempty"`
+}
+
+// Value implements driver.Valuer, empty content is stored as NULL
+func (c Content) Value() (driver.Value, error) {
+	if c.Location == nil && c.Contact == nil && c.Poll == nil && len(c.Entities) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(c)
+}
+
+// Scan implements sql.Scanner
+func (c *Content) Scan(src any) error {
+	*c = Content{}
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(src, c)
+	case string:
+		return json.Unmarshal([]byte(src), c)
+	default:
+		return fmt.Errorf("unsupported content type %T", src)
+	}
 }
 
 type Chat struct {
-	ID         int64
-	Type       string
-	Name       string
+	ID   int64
+	Type string
+	Name string
+	// Address is the original id of the chat in messengers whose chat ids are not numeric, it is empty in the others
+	Address    string
 	internalID int32
 	complete   bool
 }
@@ -43,7 +129,7 @@ func (c *Chat) fillOrCreate(db *DB) error {
 	if c.complete {
 		return nil
 	}
-	chat, err := db.getOrCreateChat(c.ID, c.Type)
+	chat, err := db.getOrCreateChat(c.ID, c.Type, c.Address)
 	if err != nil {
 		return err
 	}
@@ -51,9 +137,74 @@ func (c *Chat) fillOrCreate(db *DB) error {
 	return err
 }
 
+// Subscription is a chat receiving messages from another chat along with the settings of receiving
+type Subscription struct {
+	Chat
+	// Filters are textual rules a message should pass in order to be forwarded
+	Filters []string
+	// Template describes how the message text is shown in the chat, empty one means the default template
+	Template string
+}
+
+var ErrNoSubscription = errors.New("no subscription")
+
+// QueuedMessage is a delivery of the message to a destination chat stored in the outbox
 type QueuedMessage struct {
 	Message
+	Source      Chat
+	Destination Chat
+	// DeliveryID identifies the delivery in the outbox
+	DeliveryID int32
+	// Attempts is the number of delivery attempts including the current one
+	Attempts int
+	// Prepared is set if the message has already been adapted for the destination chat
+	Prepared bool
+	// Template is the current template of the subscription the message is delivered by
+	Template string
+	// PhotoOptions describe how photos are processed for the subscription the message is delivered by
+	PhotoOptions string
+}
+
+// DeadLetter is a message which could not be delivered after many attempts
+type DeadLetter struct {
+	QueuedMessage
+	LastError string
+	FailedAt  time.Time
+}
+
+// DeadLetterFilter selects dead letters. Empty fields select all of them
+type DeadLetterFilter struct {
+	// Destination is a chat the dead letters were sent to
+	Destination *Chat
+	// DeliveryIDs are ids of the dead letters
+	DeliveryIDs []int32
+}
+
+// ImageVariant is a processed copy of an image kept in the blob store
+type ImageVariant struct {
+	Hash string
+	Size int64
+	// Format is the image format, e.g. "jpeg"
+	Format string
+}
+
+// DeliveryDecision describes how an attachment was changed to fit the limits of the destination messenger
+type DeliveryDecision struct {
+	// Attachment is the name of the attachment file
+	Attachment string
+	Size       int64
+	// Action is what was done with the attachment: "recompressed", "sent_as_file", "split" or "replaced_with_link".
+	// It is "partially_sent" for a message only some parts of which were sent, its Attachment is empty then
+	Action  string
+	Details string
+}
+
+// ForwardedMessage describes copies of a single message that were sent to a destination chat
+type ForwardedMessage struct {
 	Destination Chat
+	MessageIDs  []string
+	// Template is the template of the subscription the copies were made for
+	Template string
 }
 
 type DB struct {
@@ -79,7 +230,9 @@ func (db *DB) transact(txOpts *sql.TxOptions, txFunc func(*sql.Tx) error) (err e
 			err = tx.Rollback()
 			panic(p)
 		} else if err != nil {
-			err = tx.Rollback()
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.Printf("could not rollback transaction: %v", rollbackErr)
+			}
 		} else {
 			err = tx.Commit()
 		}
@@ -89,30 +242,54 @@ func (db *DB) transact(txOpts *sql.TxOptions, txFunc func(*sql.Tx) error) (err e
 	return err
 }
 
-// FindSubscribedChats returns all chats subscribed on the given one
-func (db *DB) FindSubscribedChats(chat Chat) ([]Chat, error) {
+// FindSubscriptions returns all chats subscribed on the given one along with their subscription settings
+func (db *DB) FindSubscriptions(chat Chat) ([]Subscription, error) {
 	if err := chat.fillOrCreate(db); err != nil {
 		return nil, err
 	}
-	rows, err := db.Query(`SELECT chat_id, chat_type, name, Chats.internal_id
+	rows, err := db.Query(`SELECT chat_id, chat_type, name, address, Chats.internal_id, template
 	FROM Subscriptions JOIN Chats ON Subscriptions.destination_chat = Chats.internal_id
 	WHERE source_chat = $1`, chat.internalID)
 	if err != nil {
-		return []Chat{}, err
+		return []Subscription{}, err
 	}
+	defer rows.Close()
 
-	var res []Chat
+	var res []Subscription
+	byDestination := make(map[int32]int)
 	for rows.Next() {
-		buf := Chat{complete: true}
-		err := rows.Scan(&buf.ID, &buf.Type, &buf.Name, &buf.internalID)
+		buf := Subscription{Chat: Chat{complete: true}}
+		err := rows.Scan(&buf.ID, &buf.Type, &buf.Name, &buf.Address, &buf.internalID, &buf.Template)
 		if err != nil {
-			return []Chat{}, err
+			return []Subscription{}, err
 		}
 
+		byDestination[buf.internalID] = len(res)
 		res = append(res, buf)
 	}
+	if err = rows.Err(); err != nil {
+		return []Subscription{}, err
+	}
 
-	return res, nil
+	filterRows, err := db.Query(`SELECT destination_chat, rule FROM SubscriptionFilters
+	WHERE source_chat = $1 ORDER BY internal_id`, chat.internalID)
+	if err != nil {
+		return []Subscription{}, err
+	}
+	defer filterRows.Close()
+
+	for filterRows.Next() {
+		var destinationRowID int32
+		var rule string
+		if err = filterRows.Scan(&destinationRowID, &rule); err != nil {
+			return []Subscription{}, err
+		}
+		if i, exists := byDestination[destinationRowID]; exists {
+			res[i].Filters = append(res[i].Filters, rule)
+		}
+	}
+
+	return res, filterRows.Err()
 }
 
 func generateToken(chatID int64, chatType string) string {
@@ -123,21 +300,33 @@ func generateToken(chatID int64, chatType string) string {
 
 // getOrCreateChat tries to find a chat by id and messenger
 // If it does not exist, a new instance is created.
+// The address of the chat is saved if it is given, chats created before addresses were kept get them this way.
 // In both cases either a complete chat object or an error is returned.
-func (db *DB) getOrCreateChat(id int64, messenger string) (*Chat, error) {
+func (db *DB) getOrCreateChat(id int64, messenger string, address string) (*Chat, error) {
 	chat, err := db.GetChat(id, messenger)
-	if err != nil || chat != nil {
-		return chat, err
+	if err != nil {
+		return nil, err
+	}
+	if chat == nil {
+		return db.CreateChat(&Chat{ID: id, Type: messenger, Address: address})
+	}
+	if address != "" && chat.Address != address {
+		if _, err = db.Exec(`UPDATE Chats SET address = $1 WHERE internal_id = $2`,
+			&address, &chat.internalID); err != nil {
+			return nil, err
+		}
+		chat.Address = address
 	}
-	return db.CreateChat(chat)
+	return chat, nil
 }
 
 // CreateChat creates new chat entry with given id in messenger, type and name
 func (db *DB) CreateChat(chat *Chat) (*Chat, error) {
 	token := generateToken(chat.ID, chat.Type)
 
-	res := db.QueryRow("INSERT INTO Chats VALUES ($1, $2, $3, $4) RETURNING internal_id",
-		&chat.ID, &chat.Type, &token, &chat.Name)
+	res := db.QueryRow(`INSERT INTO Chats (chat_id, chat_type, token, name, address)
+	VALUES ($1, $2, $3, $4, $5) RETURNING internal_id`,
+		&chat.ID, &chat.Type, &token, &chat.Name, &chat.Address)
 
 	var internalID int32
 	err := res.Scan(&internalID)
@@ -150,6 +339,7 @@ func (db *DB) CreateChat(chat *Chat) (*Chat, error) {
 		Type:       chat.Type,
 		internalID: internalID,
 		Name:       chat.Name,
+		Address:    chat.Address,
 		complete:   true,
 	}, nil
 }
@@ -157,10 +347,10 @@ func (db *DB) CreateChat(chat *Chat) (*Chat, error) {
 // GetChat returns chat object with given id in messenger
 func (db *DB) GetChat(chatID int64, chatType string) (*Chat, error) {
 	res := Chat{ID: chatID, Type: chatType, complete: true}
-	row := db.QueryRow(`SELECT name, internal_id FROM Chats
+	row := db.QueryRow(`SELECT name, address, internal_id FROM Chats
 	WHERE chat_id = $1 AND chat_type = $2`, &chatID, &chatType)
 
-	err := row.Scan(&res.Name, &res.internalID)
+	err := row.Scan(&res.Name, &res.Address, &res.internalID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -185,120 +375,319 @@ func (db *DB) GetChatToken(chatID int64, chatType string) (string, error) {
 	return token, nil
 }
 
-// AddUnsentMessage adds message to send later
-func (db *DB) AddUnsentMessage(message QueuedMessage) error {
-	if err := message.Destination.fillOrCreate(db); err != nil {
-		return err
+// EnqueueMessages adds messages to the outbox in order to deliver them to their destinations
+func (db *DB) EnqueueMessages(messages []QueuedMessage) error {
+	for i := range messages {
+		if err := messages[i].Destination.fillOrCreate(db); err != nil {
+			return err
+		}
+		if err := messages[i].Source.fillOrCreate(db); err != nil {
+			return err
+		}
 	}
 	err := db.transact(&sql.TxOptions{
-		Isolation: sql.LevelSerializable,
+		Isolation: sql.LevelReadCommitted,
 		ReadOnly:  false,
 	},
 		func(tx *sql.Tx) error {
-			res := tx.QueryRow(`INSERT INTO Messages
-								VALUES ($1, $2, $3, $4)
-								RETURNING internal_id`,
-				&message.Destination.internalID,
-				&message.Sender.Name, &message.Text, &message.Sender.Chat)
-			var messageRowID int32
-			err := res.Scan(&messageRowID)
+			messageStmt, err := tx.Prepare(`INSERT INTO Messages (destination_chat, sender, message_text, sender_chat,
+											source_chat, source_message_id, reply_to, reply_sender, reply_sender_chat,
+											reply_text, origin_id, hop_count, content)
+											VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+											RETURNING internal_id`)
+			if err != nil {
+				return err
+			}
+			defer messageStmt.Close()
+
+			attachmentStmt, err := tx.Prepare(`INSERT INTO Attachments (data_type, data_url, data_name, parent_message)
+											   VALUES ($1, $2, $3, $4)`)
+			if err != nil {
+				return err
+			}
+			defer attachmentStmt.Close()
+
+			// every queued message holds a reference to the files of its attachments until it is delivered
+			blobStmt, err := tx.Prepare(`INSERT INTO Blobs (hash, ref_count) VALUES ($1, 1)
+										 ON CONFLICT (hash) DO UPDATE
+										 SET ref_count = Blobs.ref_count + 1, updated_at = now()`)
 			if err != nil {
 				return err
 			}
+			defer blobStmt.Close()
 
-			// TODO: remove SQL query from loop
-			for _, attachment := range message.Attachments {
-				_, err := tx.Exec(`INSERT INTO Attachments
-								   VALUES ($1, $2, $3)`,
-					&attachment.Type, &attachment.URL, &messageRowID)
+			for _, message := range messages {
+				var replyTo, replySender, replySenderChat, replyText sql.NullString
+				if message.ReplyTo != nil {
+					replyTo = sql.NullString{String: message.ReplyTo.MessageID, Valid: true}
+					replySender = sql.NullString{String: message.ReplyTo.Name, Valid: true}
+					replySenderChat = sql.NullString{String: message.ReplyTo.Chat, Valid: true}
+					replyText = sql.NullString{String: message.ReplyTo.Text, Valid: true}
+				}
+				var messageRowID int32
+				err = messageStmt.QueryRow(&message.Destination.internalID,
+					&message.Sender.Name, &message.Text, &message.Sender.Chat,
+					&message.Source.internalID, &message.ID, &replyTo, &replySender, &replySenderChat, &replyText,
+					&message.OriginID, &message.HopCount, &message.Content).Scan(&messageRowID)
 				if err != nil {
 					return err
 				}
-			}
 
+				for _, attachment := range message.Attachments {
+					_, err = attachmentStmt.Exec(&attachment.Type, &attachment.URL, &attachment.Name, &messageRowID)
+					if err != nil {
+						return err
+					}
+					if _, err = blobStmt.Exec(&attachment.URL); err != nil {
+						return err
+					}
+				}
+			}
 			return nil
 		})
 
 	return err
 }
 
-func getMessageAttachments(tx *sql.Tx, messageRowID int, attachments []*Attachment) error {
-	rows, err := tx.Query(`SELECT data_type, data_url
+func getMessageAttachments(tx *sql.Tx, messageRowID int32) ([]*Attachment, error) {
+	rows, err := tx.Query(`SELECT data_type, data_url, data_name, COALESCE(Blobs.size, 0)
 						   FROM Attachments
-						   WHERE parent_message = $1`, &messageRowID)
+						   LEFT JOIN Blobs ON Blobs.hash = Attachments.data_url
+						   WHERE parent_message = $1
+						   ORDER BY internal_id`, &messageRowID)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
+	var attachments []*Attachment
 	for rows.Next() {
 		attachment := &Attachment{}
-		err = rows.Scan(&attachment.Type, &attachment.URL)
+		err = rows.Scan(&attachment.Type, &attachment.URL, &attachment.Name, &attachment.Size)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		attachments = append(attachments, attachment)
 	}
 
-	return nil
+	return attachments, rows.Err()
 }
 
-// GetUnsentMessages returns all messages to send and deletes them from db
-func (db *DB) GetUnsentMessages(maxCnt int) ([]QueuedMessage, error) {
+// ClaimMessages leases at most maxCnt due messages of the outbox for the given duration, so that they are not
+// claimed again while being delivered. At most maxPerDestination messages are claimed for a single destination,
+// and destinations with messages leased earlier are skipped.
+// Messages from the same source are claimed in order: a message waiting for a retry holds back the later ones,
+// unless it was moved to the dead letters. Every claim is counted as a delivery attempt.
+// The claimed messages are returned in the order they should be delivered.
+func (db *DB) ClaimMessages(maxCnt int, maxPerDestination int, lease time.Duration) ([]QueuedMessage, error) {
 	var res []QueuedMessage
+	leaseSec := lease.Seconds()
 	err := db.transact(&sql.TxOptions{
-		Isolation: sql.LevelSerializable,
+		Isolation: sql.LevelReadCommitted,
 		ReadOnly:  false,
 	},
 		func(tx *sql.Tx) error {
-			rows, err := tx.Query(`SELECT sender, sender_chat, message_text, Messages.internal_id, chat_id, chat_type, Chats.name, Chats.internal_id
-								   FROM Messages JOIN Chats ON Messages.destination_chat = Chats.internal_id
-								   LIMIT $1`, &maxCnt)
+			rows, err := tx.Query(`WITH Claimed AS (
+									   UPDATE Messages
+									   SET leased_until = now() + $3 * INTERVAL '1 second', attempts = attempts + 1
+									   WHERE internal_id IN (
+										   SELECT internal_id FROM Messages
+										   WHERE internal_id IN (
+											   SELECT internal_id FROM (
+												   SELECT internal_id, ROW_NUMBER() OVER (
+													   PARTITION BY destination_chat ORDER BY internal_id) AS position
+												   FROM Messages
+												   WHERE delivered_at IS NULL AND next_attempt_at <= now()
+												   AND (leased_until IS NULL OR leased_until < now())
+												   AND internal_id NOT IN (SELECT message FROM DeadLetters)
+												   AND NOT EXISTS (
+													   SELECT 1 FROM Messages AS Earlier
+													   WHERE Earlier.destination_chat = Messages.destination_chat
+													   AND Earlier.source_chat IS NOT DISTINCT FROM Messages.source_chat
+													   AND Earlier.internal_id < Messages.internal_id
+													   AND Earlier.delivered_at IS NULL
+													   AND Earlier.next_attempt_at > now()
+													   AND Earlier.internal_id NOT IN (SELECT message FROM DeadLetters))
+												   AND destination_chat NOT IN (
+													   SELECT destination_chat FROM Messages
+													   WHERE delivered_at IS NULL AND leased_until >= now())
+											   ) AS Due
+											   WHERE position <= $2)
+										   ORDER BY internal_id
+										   LIMIT $1
+										   FOR UPDATE SKIP LOCKED)
+									   RETURNING *
+								   )
+								   SELECT sender, sender_chat, message_text, Claimed.internal_id,
+								   Destinations.chat_id, Destinations.chat_type, Destinations.name, Destinations.address,
+								   Destinations.internal_id,
+								   COALESCE(Sources.chat_id, 0), COALESCE(Sources.chat_type, ''),
+								   COALESCE(Sources.name, ''), COALESCE(Sources.internal_id, 0),
+								   COALESCE(source_message_id, ''), reply_to, COALESCE(reply_sender, ''),
+								   COALESCE(reply_sender_chat, ''), COALESCE(reply_text, ''),
+								   COALESCE(origin_id, ''), hop_count, content, prepared, attempts,
+								   COALESCE(Subscriptions.template, ''), COALESCE(Subscriptions.photo_options, '')
+								   FROM Claimed
+								   JOIN Chats AS Destinations ON Claimed.destination_chat = Destinations.internal_id
+								   LEFT JOIN Chats AS Sources ON Claimed.source_chat = Sources.internal_id
+								   LEFT JOIN Subscriptions ON Subscriptions.source_chat = Claimed.source_chat
+								   AND Subscriptions.destination_chat = Claimed.destination_chat
+								   ORDER BY Claimed.internal_id`, &maxCnt, &maxPerDestination, &leaseSec)
 			if err != nil {
 				return err
 			}
+			defer rows.Close()
 
-			var messageRowIDs []int
-			// TODO: remove queries from loop
 			for rows.Next() {
 				message := QueuedMessage{}
-				messageRowID := -1
-				err := rows.Scan(&message.Sender.Name, &message.Sender.Chat, &message.Text, &messageRowID,
+				var replyTo sql.NullString
+				var reply Reply
+				err := rows.Scan(&message.Sender.Name, &message.Sender.Chat, &message.Text, &message.DeliveryID,
 					&message.Destination.ID, &message.Destination.Type,
-					&message.Destination.Name, &message.Destination.internalID)
+					&message.Destination.Name, &message.Destination.Address, &message.Destination.internalID,
+					&message.Source.ID, &message.Source.Type,
+					&message.Source.Name, &message.Source.internalID, &message.ID, &replyTo,
+					&reply.Name, &reply.Chat, &reply.Text,
+					&message.OriginID, &message.HopCount, &message.Content,
+					&message.Prepared, &message.Attempts, &message.Template, &message.PhotoOptions)
 				if err != nil {
 					return err
 				}
+				if replyTo.Valid {
+					reply.MessageID = replyTo.String
+					message.ReplyTo = &reply
+				}
+				message.Destination.complete = true
+				message.Source.complete = message.Source.internalID != 0
 
-				messageRowIDs = append(messageRowIDs, messageRowID)
 				res = append(res, message)
 			}
+			if err = rows.Err(); err != nil {
+				return err
+			}
 
-			for i, message := range res {
-				if err = getMessageAttachments(tx, messageRowIDs[i], message.Attachments); err != nil {
+			for i := range res {
+				res[i].Attachments, err = getMessageAttachments(tx, res[i].DeliveryID)
+				if err != nil {
 					return err
 				}
 			}
+			return nil
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// MarkMessageDelivered removes the message from the outbox.
+// The delivered message is kept for a while, so that its attachments are not deleted too early
+func (db *DB) MarkMessageDelivered(deliveryID int32) error {
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			res, err := tx.Exec(`UPDATE Messages SET delivered_at = now(), leased_until = NULL, last_error = NULL
+								 WHERE internal_id = $1 AND delivered_at IS NULL`, &deliveryID)
+			if err != nil {
+				return err
+			}
+			if cnt, err := res.RowsAffected(); err != nil || cnt == 0 {
+				return err
+			}
+			// a delivered message is never sent again, so it does not need its files anymore
+			return releaseBlobs(tx, "SELECT $1::INTEGER", &deliveryID)
+		})
+
+	return err
+}
+
+// LogDeliveryDecisions saves the decisions made during the delivery attempt to the delivery log.
+// The log of a message is deleted together with it
+func (db *DB) LogDeliveryDecisions(deliveryID int32, attempt int, decisions []DeliveryDecision) error {
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			stmt, err := tx.Prepare(`INSERT INTO DeliveryLog (message, attempt, attachment, size, action, details)
+									 VALUES ($1, $2, $3, $4, $5, $6)`)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
 
-			for _, id := range messageRowIDs {
-				_, err := tx.Exec(`UPDATE Attachments SET parent_message = NULL 
-						WHERE parent_message = $1`, &id)
+			for _, decision := range decisions {
+				_, err = stmt.Exec(&deliveryID, &attempt, &decision.Attachment, &decision.Size,
+					&decision.Action, &decision.Details)
 				if err != nil {
 					return err
 				}
-				_, err = tx.Exec("DELETE FROM Messages WHERE Messages.internal_id = $1", &id)
-				if err != nil {
+			}
+			return nil
+		})
+
+	return err
+}
+
+// MarkMessageFailed releases the claimed message and schedules the next delivery attempt
+func (db *DB) MarkMessageFailed(deliveryID int32, lastError string, nextAttemptAt time.Time) error {
+	_, err := db.Exec(`UPDATE Messages SET leased_until = NULL, last_error = $2, next_attempt_at = $3
+					   WHERE internal_id = $1`, &deliveryID, &lastError, &nextAttemptAt)
+	return err
+}
+
+// PostponeMessages releases the claimed messages which were not tried to be delivered
+// without counting the claim as an attempt
+func (db *DB) PostponeMessages(deliveryIDs []int32, nextAttemptAt time.Time) error {
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			stmt, err := tx.Prepare(`UPDATE Messages
+									 SET leased_until = NULL, attempts = attempts - 1, next_attempt_at = $2
+									 WHERE internal_id = $1`)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+
+			for _, deliveryID := range deliveryIDs {
+				if _, err = stmt.Exec(&deliveryID, &nextAttemptAt); err != nil {
 					return err
 				}
 			}
-
 			return nil
 		})
 
-	if err != nil {
-		return nil, err
-	}
+	return err
+}
 
-	return res, nil
+// PruneDeliveredMessages deletes messages delivered earlier than the given time ago.
+// Their files have already been released on delivery.
+func (db *DB) PruneDeliveredMessages(olderThan time.Duration) error {
+	olderThanSec := olderThan.Seconds()
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DELETE FROM Attachments
+							   WHERE parent_message IN (SELECT internal_id FROM Messages
+							   WHERE delivered_at < now() - $1 * INTERVAL '1 second')`, &olderThanSec)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("DELETE FROM Messages WHERE delivered_at < now() - $1 * INTERVAL '1 second'",
+				&olderThanSec)
+			return err
+		})
+
+	return err
 }
 
 func getChatRowIDByToken(tx *sql.Tx, token string) (int, error) {
@@ -334,6 +723,42 @@ func (db *DB) Subscribe(subscriber *Chat, subscriptionToken string) error {
 	return err
 }
 
+// Bridge subscribes provided chat and the chat with given token on each other.
+// Already existing subscriptions are kept as they are.
+func (db *DB) Bridge(chat *Chat, token string) error {
+	if err := chat.fillOrCreate(db); err != nil {
+		return err
+	}
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			otherRowID, err := getChatRowIDByToken(tx, token)
+			if err != nil {
+				return err
+			}
+			if int32(otherRowID) == chat.internalID {
+				return errors.New("can not bridge a chat with itself")
+			}
+
+			stmt, err := tx.Prepare(`INSERT INTO Subscriptions VALUES ($1, $2)
+									 ON CONFLICT ON CONSTRAINT single_subscription DO NOTHING`)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+
+			if _, err = stmt.Exec(&otherRowID, &chat.internalID); err != nil {
+				return err
+			}
+			_, err = stmt.Exec(&chat.internalID, &otherRowID)
+			return err
+		})
+
+	return err
+}
+
 // Unsubscribe unsubscribes provided chat from another with given token.
 func (db *DB) Unsubscribe(subscriber *Chat, subscriptionToken string) error {
 	if err := subscriber.fillOrCreate(db); err != nil {
@@ -360,7 +785,7 @@ func (db *DB) Unsubscribe(subscriber *Chat, subscriptionToken string) error {
 				return err
 			}
 			if cntRemoved < 1 {
-				return errors.New("no subscription")
+				return ErrNoSubscription
 			}
 
 			return nil
@@ -369,53 +794,847 @@ func (db *DB) Unsubscribe(subscriber *Chat, subscriptionToken string) error {
 	return err
 }
 
-// GetUnusedAttachments returns all attachments which will never be sent anymore and deletes them
-func (db *DB) GetUnusedAttachments() ([]*Attachment, error) {
-	var res []*Attachment
+// RemoveUnsentMessages removes all not yet delivered copies of the source message from the outbox
+// and releases their files.
+func (db *DB) RemoveUnsentMessages(source *Chat, sourceMessageID string) error {
+	if err := source.fillOrCreate(db); err != nil {
+		return err
+	}
 	err := db.transact(&sql.TxOptions{
 		Isolation: sql.LevelSerializable,
 		ReadOnly:  false,
 	},
 		func(tx *sql.Tx) error {
-			rows, err := tx.Query(`SELECT data_type, data_url, Attachments.internal_id
-						   		   FROM Attachments LEFT JOIN Messages
-								   ON Attachments.parent_message = Messages.internal_id
-								   WHERE destination_chat IS NULL`)
+			unsent := `SELECT internal_id FROM Messages
+					   WHERE source_chat = $1 AND source_message_id = $2 AND delivered_at IS NULL`
+			if err := releaseBlobs(tx, unsent, &source.internalID, &sourceMessageID); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM Attachments WHERE parent_message IN (`+unsent+`)`,
+				&source.internalID, &sourceMessageID)
 			if err != nil {
 				return err
 			}
+			_, err = tx.Exec(`DELETE FROM Messages
+							  WHERE source_chat = $1 AND source_message_id = $2 AND delivered_at IS NULL`,
+				&source.internalID, &sourceMessageID)
+			return err
+		})
+
+	return err
+}
+
+// releaseBlobs drops references to the files of attachments of the messages
+// with internal ids returned by the query
+func releaseBlobs(tx *sql.Tx, messagesQuery string, args ...any) error {
+	_, err := tx.Exec(`UPDATE Blobs SET ref_count = Blobs.ref_count - released.cnt, updated_at = now()
+					   FROM (SELECT data_url, count(*) AS cnt FROM Attachments
+					   WHERE parent_message IN (`+messagesQuery+`)
+					   GROUP BY data_url) AS released
+					   WHERE Blobs.hash = released.data_url`, args...)
+	return err
+}
+
+// RegisterBlob remembers a file saved to the blob store. The file is not referenced by any message yet,
+// it is kept for the grace period of the cleanup to let the messages referring to it be queued.
+// If the file is being removed at the moment, RegisterBlob waits until the removal is done.
+func (db *DB) RegisterBlob(hash string, size int64) error {
+	_, err := db.Exec(`INSERT INTO Blobs (hash, size) VALUES ($1, $2)
+					   ON CONFLICT (hash) DO UPDATE SET size = $2, updated_at = now()`, &hash, &size)
+	return err
+}
+
+// PinBlob keeps the file in the blob store at least until the given time whether it is referenced or not.
+// False is returned if there is no such file
+func (db *DB) PinBlob(hash string, until time.Time) (bool, error) {
+	res, err := db.Exec(`UPDATE Blobs SET pinned_until = GREATEST(pinned_until, $2)
+						 WHERE hash = $1`, &hash, &until)
+	if err != nil {
+		return false, err
+	}
+	cnt, err := res.RowsAffected()
+	return cnt > 0, err
+}
 
-			var attachmentRowIDs []int
+// CollectBlobs passes hashes of files which are not pinned and not referenced by any message for longer than
+// the grace period to the remove function and forgets the successfully removed ones.
+// The number of removed files is returned
+func (db *DB) CollectBlobs(gracePeriod time.Duration, remove func(hash string) error) (int, error) {
+	gracePeriodSec := gracePeriod.Seconds()
+	cnt := 0
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			// the rows stay locked until the files are removed, so that the same files can not be saved again meanwhile
+			rows, err := tx.Query(`SELECT hash FROM Blobs
+								   WHERE ref_count <= 0 AND updated_at < now() - $1 * INTERVAL '1 second'
+								   AND (pinned_until IS NULL OR pinned_until < now())
+								   FOR UPDATE SKIP LOCKED`, &gracePeriodSec)
+			if err != nil {
+				return err
+			}
+			var hashes []string
 			for rows.Next() {
-				attachment := Attachment{}
-				rowID := -1
-				err = rows.Scan(&attachment.Type, &attachment.URL, &rowID)
-				if err != nil {
+				var hash string
+				if err = rows.Scan(&hash); err != nil {
+					rows.Close()
 					return err
 				}
-
-				res = append(res, &attachment)
-				attachmentRowIDs = append(attachmentRowIDs, rowID)
+				hashes = append(hashes, hash)
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return err
 			}
 
-			stmt, err := tx.Prepare("DELETE FROM Attachments WHERE internal_id = $1")
+			stmt, err := tx.Prepare("DELETE FROM Blobs WHERE hash = $1")
 			if err != nil {
 				return err
 			}
+			defer stmt.Close()
 
-			for _, rowID := range attachmentRowIDs {
-				_, err = stmt.Exec(&rowID)
-				if err != nil {
+			for _, hash := range hashes {
+				if err = remove(hash); err != nil {
+					log.Printf("could not remove blob %s: %v", hash, err)
+					continue
+				}
+				if _, err = stmt.Exec(&hash); err != nil {
 					return err
 				}
+				cnt++
 			}
-
 			return nil
 		})
 
 	if err != nil {
+		return 0, err
+	}
+	return cnt, nil
+}
+
+// GetLegacyAttachmentURLs returns urls of attachments saved before the blob store, which are paths of their files
+// in the attachments directory, along with the number of not yet delivered messages referring to each of them
+func (db *DB) GetLegacyAttachmentURLs() (map[string]int, error) {
+	rows, err := db.Query(`SELECT data_url, count(Messages.internal_id) FILTER (WHERE delivered_at IS NULL)
+						   FROM Attachments LEFT JOIN Messages ON Attachments.parent_message = Messages.internal_id
+						   WHERE data_url !~ '^[0-9a-f]{64}$'
+						   GROUP BY data_url`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]int)
+	for rows.Next() {
+		var url string
+		var cnt int
+		if err = rows.Scan(&url, &cnt); err != nil {
+			return nil, err
+		}
+		res[url] = cnt
+	}
+	return res, rows.Err()
+}
+
+// ReplaceLegacyAttachment makes the attachments with the legacy url refer to the blob the file was saved as.
+// Not yet delivered messages add their references to the blob, attachments detached from messages are deleted
+func (db *DB) ReplaceLegacyAttachment(url string, hash string) error {
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM Attachments WHERE data_url = $1 AND parent_message IS NULL", &url)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`UPDATE Blobs SET ref_count = Blobs.ref_count + (SELECT count(*) FROM Attachments
+							  JOIN Messages ON Attachments.parent_message = Messages.internal_id
+							  WHERE data_url = $1 AND delivered_at IS NULL), updated_at = now()
+							  WHERE hash = $2`, &url, &hash)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("UPDATE Attachments SET data_url = $2 WHERE data_url = $1", &url, &hash)
+			return err
+		})
+
+	return err
+}
+
+// RemoveLegacyAttachment deletes the attachments with the legacy url, whose file is not needed anymore
+func (db *DB) RemoveLegacyAttachment(url string) error {
+	_, err := db.Exec("DELETE FROM Attachments WHERE data_url = $1", &url)
+	return err
+}
+
+// FindImageVariant returns the copy of the image made with given options, or nil if it has not been made yet.
+// The copy is kept for another grace period of the blob cleanup
+func (db *DB) FindImageVariant(sourceHash string, options string) (*ImageVariant, error) {
+	variant := &ImageVariant{}
+	err := db.QueryRow(`UPDATE Blobs SET updated_at = now()
+						FROM ImageVariants
+						WHERE ImageVariants.source_hash = $1 AND ImageVariants.options = $2
+						AND Blobs.hash = ImageVariants.variant_hash
+						RETURNING Blobs.hash, Blobs.size, ImageVariants.format`,
+		&sourceHash, &options).Scan(&variant.Hash, &variant.Size, &variant.Format)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+// AddImageVariant remembers the copy of the image made with given options.
+// The variant is forgotten as soon as either the image or the copy is removed from the blob store
+func (db *DB) AddImageVariant(sourceHash string, options string, variant *ImageVariant) error {
+	_, err := db.Exec(`INSERT INTO ImageVariants (source_hash, options, variant_hash, format)
+					   VALUES ($1, $2, $3, $4)
+					   ON CONFLICT (source_hash, options) DO UPDATE
+					   SET variant_hash = $3, format = $4, created_at = now()`,
+		&sourceHash, &options, &variant.Hash, &variant.Format)
+	return err
+}
+
+// FindFileParts returns hashes of the parts the file was split into by the part size in their order.
+// The parts are kept for another grace period of the blob cleanup. A part removed from the blob store
+// is forgotten, so fewer parts than expected mean the file has to be split again
+func (db *DB) FindFileParts(sourceHash string, partSize int64) ([]string, error) {
+	rows, err := db.Query(`UPDATE Blobs SET updated_at = now()
+						   FROM AttachmentParts
+						   WHERE AttachmentParts.source_hash = $1 AND AttachmentParts.part_size = $2
+						   AND Blobs.hash = AttachmentParts.part_hash
+						   RETURNING AttachmentParts.part_number, Blobs.hash`, &sourceHash, &partSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partsByNumber := make(map[int]string)
+	for rows.Next() {
+		var number int
+		var hash string
+		if err = rows.Scan(&number, &hash); err != nil {
+			return nil, err
+		}
+		partsByNumber[number] = hash
+	}
+	if err = rows.Err(); err != nil {
 		return nil, err
 	}
+	var res []string
+	for number := 1; number <= len(partsByNumber); number++ {
+		hash, exists := partsByNumber[number]
+		if !exists {
+			return nil, nil
+		}
+		res = append(res, hash)
+	}
+	return res, nil
+}
+
+// AddFileParts remembers the parts the file was split into by the part size.
+// The parts are forgotten as soon as either the file or any of the parts is removed from the blob store
+func (db *DB) AddFileParts(sourceHash string, partSize int64, partHashes []string) error {
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			stmt, err := tx.Prepare(`INSERT INTO AttachmentParts (source_hash, part_size, part_number, part_hash)
+									 VALUES ($1, $2, $3, $4)
+									 ON CONFLICT (source_hash, part_size, part_number) DO UPDATE
+									 SET part_hash = $4`)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+
+			for i, partHash := range partHashes {
+				number := i + 1
+				if _, err = stmt.Exec(&sourceHash, &partSize, &number, &partHash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	return err
+}
+
+// AddForwardedMessage remembers which messages in the destination chat were produced from the source message
+func (db *DB) AddForwardedMessage(source *Chat, message *Message, destination *Chat,
+	destinationMessageIDs []string) error {
+	if err := source.fillOrCreate(db); err != nil {
+		return err
+	}
+	if err := destination.fillOrCreate(db); err != nil {
+		return err
+	}
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			stmt, err := tx.Prepare(`INSERT INTO ForwardedMessages
+									 (source_chat, source_message_id, destination_chat, destination_message_id,
+									 origin_id, hop_count)
+									 VALUES ($1, $2, $3, $4, $5, $6)`)
+			if err != nil {
+				return err
+			}
+			defer stmt.Close()
+
+			for _, destinationMessageID := range destinationMessageIDs {
+				_, err = stmt.Exec(&source.internalID, &message.ID,
+					&destination.internalID, &destinationMessageID, &message.OriginID, &message.HopCount)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	return err
+}
+
+// GetForwardedMessages returns all copies of the source message grouped by destination chat.
+// Message ids of every copy are listed in the order they were sent.
+func (db *DB) GetForwardedMessages(source *Chat, sourceMessageID string) ([]ForwardedMessage, error) {
+	if err := source.fillOrCreate(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT destination_message_id, chat_id, chat_type, name, address, Chats.internal_id,
+		COALESCE(Subscriptions.template, '')
+	FROM ForwardedMessages JOIN Chats ON ForwardedMessages.destination_chat = Chats.internal_id
+	LEFT JOIN Subscriptions ON Subscriptions.source_chat = ForwardedMessages.source_chat
+		AND Subscriptions.destination_chat = ForwardedMessages.destination_chat
+	WHERE ForwardedMessages.source_chat = $1 AND source_message_id = $2
+	ORDER BY ForwardedMessages.internal_id`, &source.internalID, &sourceMessageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []ForwardedMessage
+	byDestination := make(map[int32]int)
+	for rows.Next() {
+		var messageID, template string
+		destination := Chat{complete: true}
+		err := rows.Scan(&messageID, &destination.ID, &destination.Type, &destination.Name, &destination.Address,
+			&destination.internalID, &template)
+		if err != nil {
+			return nil, err
+		}
+
+		i, exists := byDestination[destination.internalID]
+		if !exists {
+			i = len(res)
+			byDestination[destination.internalID] = i
+			res = append(res, ForwardedMessage{Destination: destination, Template: template})
+		}
+		res[i].MessageIDs = append(res[i].MessageIDs, messageID)
+	}
+
+	return res, rows.Err()
+}
+
+// DeleteForwardedMessages forgets all copies of the source message
+func (db *DB) DeleteForwardedMessages(source *Chat, sourceMessageID string) error {
+	if err := source.fillOrCreate(db); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM ForwardedMessages WHERE source_chat = $1 AND source_message_id = $2",
+		&source.internalID, &sourceMessageID)
+	return err
+}
+
+// FindMessageCopy returns id of the message in the destination chat which corresponds to the source message.
+// It is either a forwarded copy of the source message or the original one if the source message is a copy itself.
+// An empty string is returned if there is no such message.
+func (db *DB) FindMessageCopy(source *Chat, sourceMessageID string, destination *Chat) (string, error) {
+	if err := source.fillOrCreate(db); err != nil {
+		return "", err
+	}
+	if err := destination.fillOrCreate(db); err != nil {
+		return "", err
+	}
+	row := db.QueryRow(`SELECT message_id FROM (
+		SELECT destination_message_id AS message_id, internal_id FROM ForwardedMessages
+		WHERE source_chat = $1 AND source_message_id = $2 AND destination_chat = $3
+		UNION ALL
+		SELECT source_message_id AS message_id, internal_id FROM ForwardedMessages
+		WHERE destination_chat = $1 AND destination_message_id = $2 AND source_chat = $3
+	) AS Copies ORDER BY internal_id LIMIT 1`,
+		&source.internalID, &sourceMessageID, &destination.internalID)
+
+	var messageID string
+	err := row.Scan(&messageID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return messageID, err
+}
+
+// FindMessageOrigin returns origin of the message in the chat if it is a copy made by the bot.
+// Nil is returned for original messages.
+func (db *DB) FindMessageOrigin(chat *Chat, messageID string) (*Origin, error) {
+	if err := chat.fillOrCreate(db); err != nil {
+		return nil, err
+	}
+	row := db.QueryRow(`SELECT COALESCE(origin_id, ''), hop_count FROM ForwardedMessages
+	WHERE destination_chat = $1 AND destination_message_id = $2
+	LIMIT 1`, &chat.internalID, &messageID)
+
+	origin := &Origin{}
+	err := row.Scan(&origin.OriginID, &origin.HopCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return origin, nil
+}
+
+// GetVisitedChats returns all chats the message with given origin was sent from or sent (or queued) to
+func (db *DB) GetVisitedChats(originID string) ([]Chat, error) {
+	rows, err := db.Query(`SELECT chat_id, chat_type, name, address, internal_id FROM Chats
+	WHERE internal_id IN (
+		SELECT source_chat FROM ForwardedMessages WHERE origin_id = $1
+		UNION
+		SELECT destination_chat FROM ForwardedMessages WHERE origin_id = $1
+		UNION
+		SELECT destination_chat FROM Messages WHERE origin_id = $1
+	)`, &originID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []Chat
+	for rows.Next() {
+		chat := Chat{complete: true}
+		if err = rows.Scan(&chat.ID, &chat.Type, &chat.Name, &chat.Address, &chat.internalID); err != nil {
+			return nil, err
+		}
+		res = append(res, chat)
+	}
+	return res, rows.Err()
+}
+
+// SubscriptionCreatesCycle checks if subscribing the chat on another one with given token
+// would make the messages go in circles, i.e. the other chat already receives messages from the subscriber.
+// Mutual subscriptions of two chats are not considered cycles.
+// Only paths shorter than maxDepth are looked for.
+func (db *DB) SubscriptionCreatesCycle(subscriber *Chat, subscriptionToken string, maxDepth int) (bool, error) {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return false, err
+	}
+	row := db.QueryRow(`WITH RECURSIVE Reachable (chat, depth) AS (
+		SELECT destination_chat, 1 FROM Subscriptions WHERE source_chat = $1
+		UNION
+		SELECT Subscriptions.destination_chat, Reachable.depth + 1
+		FROM Subscriptions JOIN Reachable ON Subscriptions.source_chat = Reachable.chat
+		WHERE Reachable.depth < $3
+	)
+	SELECT EXISTS (SELECT 1 FROM Reachable JOIN Chats ON Reachable.chat = Chats.internal_id
+	WHERE Chats.token = $2 AND Reachable.depth > 1)`, &subscriber.internalID, &subscriptionToken, &maxDepth)
+
+	var createsCycle bool
+	err := row.Scan(&createsCycle)
+	return createsCycle, err
+}
+
+// getSubscriptionSourceRowID returns internal id of the chat with given token
+// if the subscriber is subscribed on it, otherwise ErrNoSubscription is returned
+func getSubscriptionSourceRowID(tx *sql.Tx, subscriber *Chat, subscriptionToken string) (int, error) {
+	sourceRowID, err := getChatRowIDByToken(tx, subscriptionToken)
+	if err == sql.ErrNoRows {
+		return -1, ErrNoSubscription
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	var exists bool
+	row := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM Subscriptions
+	WHERE source_chat = $1 AND destination_chat = $2)`, &sourceRowID, &subscriber.internalID)
+	if err = row.Scan(&exists); err != nil {
+		return -1, err
+	}
+	if !exists {
+		return -1, ErrNoSubscription
+	}
+	return sourceRowID, nil
+}
+
+// AddFilter adds a filter rule to the subscription of the chat on another one with given token
+func (db *DB) AddFilter(subscriber *Chat, subscriptionToken string, rule string) error {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return err
+	}
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			sourceRowID, err := getSubscriptionSourceRowID(tx, subscriber, subscriptionToken)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`INSERT INTO SubscriptionFilters (source_chat, destination_chat, rule)
+							  VALUES ($1, $2, $3) ON CONFLICT ON CONSTRAINT single_filter DO NOTHING`,
+				&sourceRowID, &subscriber.internalID, &rule)
+			return err
+		})
+
+	return err
+}
+
+// RemoveFilter removes a filter rule from the subscription of the chat on another one with given token
+func (db *DB) RemoveFilter(subscriber *Chat, subscriptionToken string, rule string) error {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return err
+	}
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			sourceRowID, err := getSubscriptionSourceRowID(tx, subscriber, subscriptionToken)
+			if err != nil {
+				return err
+			}
+
+			res, err := tx.Exec(`DELETE FROM SubscriptionFilters
+								 WHERE source_chat = $1 AND destination_chat = $2 AND rule = $3`,
+				&sourceRowID, &subscriber.internalID, &rule)
+			if err != nil {
+				return err
+			}
+
+			cntRemoved, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if cntRemoved < 1 {
+				return ErrNoFilter
+			}
+			return nil
+		})
+
+	return err
+}
+
+var ErrNoFilter = errors.New("no filter")
+
+// GetFilters returns filter rules of the subscription of the chat on another one with given token
+func (db *DB) GetFilters(subscriber *Chat, subscriptionToken string) ([]string, error) {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return nil, err
+	}
+	var res []string
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	},
+		func(tx *sql.Tx) error {
+			sourceRowID, err := getSubscriptionSourceRowID(tx, subscriber, subscriptionToken)
+			if err != nil {
+				return err
+			}
+
+			rows, err := tx.Query(`SELECT rule FROM SubscriptionFilters
+								   WHERE source_chat = $1 AND destination_chat = $2
+								   ORDER BY internal_id`, &sourceRowID, &subscriber.internalID)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var rule string
+				if err = rows.Scan(&rule); err != nil {
+					return err
+				}
+				res = append(res, rule)
+			}
+			return rows.Err()
+		})
+
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetTemplate sets the template of the subscription of the chat on another one with given token.
+// An empty template resets it to the default one
+func (db *DB) SetTemplate(subscriber *Chat, subscriptionToken string, template string) error {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return err
+	}
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			sourceRowID, err := getSubscriptionSourceRowID(tx, subscriber, subscriptionToken)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`UPDATE Subscriptions SET template = $1
+							  WHERE source_chat = $2 AND destination_chat = $3`,
+				&template, &sourceRowID, &subscriber.internalID)
+			return err
+		})
+
+	return err
+}
+
+// GetTemplate returns the template of the subscription of the chat on another one with given token
+func (db *DB) GetTemplate(subscriber *Chat, subscriptionToken string) (string, error) {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return "", err
+	}
+	var template string
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	},
+		func(tx *sql.Tx) error {
+			sourceRowID, err := getSubscriptionSourceRowID(tx, subscriber, subscriptionToken)
+			if err != nil {
+				return err
+			}
+
+			row := tx.QueryRow(`SELECT template FROM Subscriptions
+								WHERE source_chat = $1 AND destination_chat = $2`,
+				&sourceRowID, &subscriber.internalID)
+			return row.Scan(&template)
+		})
+
+	return template, err
+}
+
+// SetPhotoOptions sets options of processing photos sent by the subscription of the chat on another one
+// with given token. Empty options turn the processing off
+func (db *DB) SetPhotoOptions(subscriber *Chat, subscriptionToken string, options string) error {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return err
+	}
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			sourceRowID, err := getSubscriptionSourceRowID(tx, subscriber, subscriptionToken)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`UPDATE Subscriptions SET photo_options = $1
+							  WHERE source_chat = $2 AND destination_chat = $3`,
+				&options, &sourceRowID, &subscriber.internalID)
+			return err
+		})
+
+	return err
+}
+
+// GetPhotoOptions returns options of processing photos sent by the subscription of the chat on another one
+// with given token
+func (db *DB) GetPhotoOptions(subscriber *Chat, subscriptionToken string) (string, error) {
+	if err := subscriber.fillOrCreate(db); err != nil {
+		return "", err
+	}
+	var options string
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  true,
+	},
+		func(tx *sql.Tx) error {
+			sourceRowID, err := getSubscriptionSourceRowID(tx, subscriber, subscriptionToken)
+			if err != nil {
+				return err
+			}
+
+			row := tx.QueryRow(`SELECT photo_options FROM Subscriptions
+								WHERE source_chat = $1 AND destination_chat = $2`,
+				&sourceRowID, &subscriber.internalID)
+			return row.Scan(&options)
+		})
+
+	return options, err
+}
+
+// MarkMessageDead releases the claimed message and moves it to the dead letters, so that it is not retried anymore
+func (db *DB) MarkMessageDead(deliveryID int32, lastError string) error {
+	err := db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			_, err := tx.Exec(`UPDATE Messages SET leased_until = NULL, last_error = $2 WHERE internal_id = $1`,
+				&deliveryID, &lastError)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`INSERT INTO DeadLetters (message) VALUES ($1) ON CONFLICT DO NOTHING`, &deliveryID)
+			return err
+		})
+
+	return err
+}
+
+// deadLetterCondition returns SQL condition on DeadLetters selecting dead letters matching the filter
+// and its arguments starting from $1
+func (db *DB) deadLetterCondition(filter DeadLetterFilter) (string, []any, error) {
+	destinationRowID := int32(0)
+	if filter.Destination != nil {
+		if err := filter.Destination.fillOrCreate(db); err != nil {
+			return "", nil, err
+		}
+		destinationRowID = filter.Destination.internalID
+	}
+	deliveryIDs := filter.DeliveryIDs
+	if deliveryIDs == nil {
+		deliveryIDs = []int32{}
+	}
+	condition := `($1 = 0 OR DeadLetters.message IN (SELECT internal_id FROM Messages WHERE destination_chat = $1))
+				  AND (cardinality($2::INTEGER[]) = 0 OR DeadLetters.message = ANY($2::INTEGER[]))`
+	return condition, []any{&destinationRowID, deliveryIDs}, nil
+}
+
+// GetDeadLetters returns at most maxCnt dead letters matching the filter, the latest ones go first
+func (db *DB) GetDeadLetters(filter DeadLetterFilter, maxCnt int) ([]DeadLetter, error) {
+	condition, args, err := db.deadLetterCondition(filter)
+	if err != nil {
+		return nil, err
+	}
+	var res []DeadLetter
+	err = db.transact(&sql.TxOptions{
+		Isolation: sql.LevelRepeatableRead,
+		ReadOnly:  true,
+	},
+		func(tx *sql.Tx) error {
+			rows, err := tx.Query(`SELECT sender, sender_chat, message_text, Messages.internal_id,
+								   Destinations.chat_id, Destinations.chat_type, Destinations.name, Destinations.internal_id,
+								   COALESCE(Sources.chat_id, 0), COALESCE(Sources.chat_type, ''),
+								   COALESCE(Sources.name, ''), COALESCE(Sources.internal_id, 0),
+								   COALESCE(source_message_id, ''), content, attempts, COALESCE(last_error, ''), failed_at
+								   FROM DeadLetters
+								   JOIN Messages ON DeadLetters.message = Messages.internal_id
+								   JOIN Chats AS Destinations ON Messages.destination_chat = Destinations.internal_id
+								   LEFT JOIN Chats AS Sources ON Messages.source_chat = Sources.internal_id
+								   WHERE `+condition+`
+								   ORDER BY failed_at DESC, Messages.internal_id DESC
+								   LIMIT $3`, append(args, &maxCnt)...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				deadLetter := DeadLetter{}
+				message := &deadLetter.QueuedMessage
+				err = rows.Scan(&message.Sender.Name, &message.Sender.Chat, &message.Text, &message.DeliveryID,
+					&message.Destination.ID, &message.Destination.Type,
+					&message.Destination.Name, &message.Destination.internalID,
+					&message.Source.ID, &message.Source.Type,
+					&message.Source.Name, &message.Source.internalID, &message.ID, &message.Content,
+					&message.Attempts, &deadLetter.LastError, &deadLetter.FailedAt)
+				if err != nil {
+					return err
+				}
+				message.Destination.complete = true
+				message.Source.complete = message.Source.internalID != 0
+
+				res = append(res, deadLetter)
+			}
+			if err = rows.Err(); err != nil {
+				return err
+			}
+
+			for i := range res {
+				res[i].Attachments, err = getMessageAttachments(tx, res[i].DeliveryID)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReplayDeadLetters returns dead letters matching the filter to the outbox to be delivered again.
+// The number of replayed messages is returned
+func (db *DB) ReplayDeadLetters(filter DeadLetterFilter) (int, error) {
+	condition, args, err := db.deadLetterCondition(filter)
+	if err != nil {
+		return 0, err
+	}
+	var cnt int64
+	err = db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			res, err := tx.Exec(`UPDATE Messages SET attempts = 0, next_attempt_at = now(), last_error = NULL
+								 WHERE internal_id IN (SELECT message FROM DeadLetters WHERE `+condition+`)`, args...)
+			if err != nil {
+				return err
+			}
+			if cnt, err = res.RowsAffected(); err != nil {
+				return err
+			}
+			_, err = tx.Exec(`DELETE FROM DeadLetters WHERE `+condition, args...)
+			return err
+		})
+
+	return int(cnt), err
+}
+
+// PurgeDeadLetters deletes dead letters matching the filter and releases their files.
+// The number of deleted messages is returned
+func (db *DB) PurgeDeadLetters(filter DeadLetterFilter) (int, error) {
+	condition, args, err := db.deadLetterCondition(filter)
+	if err != nil {
+		return 0, err
+	}
+	var cnt int64
+	err = db.transact(&sql.TxOptions{
+		Isolation: sql.LevelReadCommitted,
+		ReadOnly:  false,
+	},
+		func(tx *sql.Tx) error {
+			deadLetters := `SELECT message FROM DeadLetters WHERE ` + condition
+			if err := releaseBlobs(tx, deadLetters, args...); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM Attachments WHERE parent_message IN (`+deadLetters+`)`, args...)
+			if err != nil {
+				return err
+			}
+			res, err := tx.Exec(`DELETE FROM Messages
+								 WHERE internal_id IN (SELECT message FROM DeadLetters WHERE `+condition+`)`, args...)
+			if err != nil {
+				return err
+			}
+			cnt, err = res.RowsAffected()
+			return err
+		})
 
-	return res, err
+	return int(cnt), err
 }
@@ -0,0 +1,34 @@
+package discord
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+var Config = struct {
+	Token          string
+	Port           int
+	ControllerHost string
+	// Webhooks tells if messages are sent by webhooks named after their senders instead of the bot itself
+	Webhooks bool
+}{
+	Token:          os.Getenv("DISCORD_TOKEN"),
+	ControllerHost: os.Getenv("CONTROLLER_HOST"),
+	Webhooks:       os.Getenv("DISCORD_WEBHOOKS") != "false",
+}
+
+func init() {
+	if len(Config.Token) == 0 {
+		log.Panic("Discord token not provided")
+	}
+}
+
+func init() {
+	port := os.Getenv("PORT")
+	var err error
+	Config.Port, err = strconv.Atoi(port)
+	if err != nil {
+		log.Panic("Invalid Discord service port")
+	}
+}
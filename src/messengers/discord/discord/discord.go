@@ -0,0 +1,179 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"strconv"
+	"sync"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/bwmarrin/discordgo"
+)
+
+// webhookName is the name of webhooks the bot creates to send messages on behalf of their senders
+const webhookName = "TransferBot"
+
+type Messenger struct {
+	*messenger.BaseMessenger
+	session *discordgo.Session
+	// webhooks of the bot by ids of their channels
+	webhooks map[string]*discordgo.Webhook
+	// ownWebhooks tells if webhooks which sent incoming messages belong to the bot, by ids of the webhooks
+	ownWebhooks  map[string]bool
+	webhookMutex sync.Mutex
+	// updates are processed concurrently, the sequencer keeps them in order when they are passed to the controller
+	sequencer *messenger.Sequencer
+}
+
+func NewMessenger(baseMessenger *messenger.BaseMessenger) (*Messenger, error) {
+	session, err := discordgo.New("Bot " + Config.Token)
+	if err != nil {
+		return nil, fmt.Errorf("could not create discord session: %v", err)
+	}
+	session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsDirectMessages |
+		discordgo.IntentsMessageContent
+	// events are taken in the order they come, the sequencer lets them be processed concurrently after that
+	session.SyncEvents = true
+
+	newMessenger := &Messenger{
+		BaseMessenger: baseMessenger,
+		session:       session,
+		webhooks:      make(map[string]*discordgo.Webhook),
+		ownWebhooks:   make(map[string]bool),
+		sequencer:     messenger.NewSequencer(),
+	}
+	session.AddHandler(newMessenger.onMessageCreate)
+	session.AddHandler(newMessenger.onMessageUpdate)
+	session.AddHandler(newMessenger.onMessageDelete)
+	return newMessenger, nil
+}
+
+// Run connects to the gateway, the session reconnects by itself until the context is done
+func (m *Messenger) Run(ctx context.Context) {
+	if err := m.session.Open(); err != nil {
+		log.Fatalf("could not connect to discord: %v", err)
+	}
+	defer m.session.Close()
+	<-ctx.Done()
+}
+
+func (m *Messenger) onMessageCreate(_ *discordgo.Session, event *discordgo.MessageCreate) {
+	message := event.Message
+	if message.Type != discordgo.MessageTypeDefault && message.Type != discordgo.MessageTypeReply {
+		return
+	}
+	chatID, err := strconv.ParseInt(message.ChannelID, 10, 64)
+	if err != nil {
+		log.Printf("invalid discord channel id %s", message.ChannelID)
+		return
+	}
+	go m.processUpdate(message, m.sequencer.Ticket(chatID), false)
+}
+
+func (m *Messenger) onMessageUpdate(_ *discordgo.Session, event *discordgo.MessageUpdate) {
+	message := event.Message
+	// updates without an author only add embeds of links to the message
+	if message.Author == nil {
+		return
+	}
+	chatID, err := strconv.ParseInt(message.ChannelID, 10, 64)
+	if err != nil {
+		log.Printf("invalid discord channel id %s", message.ChannelID)
+		return
+	}
+	go m.processUpdate(message, m.sequencer.Ticket(chatID), true)
+}
+
+// onMessageDelete deletes forwarded copies of the message. Discord does not tell who sent a deleted message,
+// so deletions of copies sent by the bot are passed to the controller as well, they have no copies themselves
+func (m *Messenger) onMessageDelete(_ *discordgo.Session, event *discordgo.MessageDelete) {
+	chatID, err := strconv.ParseInt(event.ChannelID, 10, 64)
+	if err != nil {
+		log.Printf("invalid discord channel id %s", event.ChannelID)
+		return
+	}
+	ticket := m.sequencer.Ticket(chatID)
+	go func() {
+		defer ticket.Done()
+		ticket.Wait()
+		log.Printf("deleted message: channel id: %s; message id: %s", event.ChannelID, event.ID)
+		chat := &msg.Chat{Id: chatID, Type: "discord", Name: m.channelName(event.ChannelID)}
+		if err := m.DeletedMessageCallback(chat, event.ID); err != nil {
+			log.Printf("error processing deleted message: %v", err)
+		}
+	}()
+}
+
+// channel returns the channel from the state of the session, requesting it if it is not there
+func (m *Messenger) channel(channelID string) (*discordgo.Channel, error) {
+	if channel, err := m.session.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+	return m.session.Channel(channelID)
+}
+
+func (m *Messenger) channelName(channelID string) string {
+	channel, err := m.channel(channelID)
+	if err != nil || channel.Name == "" {
+		return "discord"
+	}
+	return "#" + channel.Name
+}
+
+// webhook returns the webhook of the bot in the channel, it is created if there is none yet.
+// Threads have no webhooks, the webhook of the parent channel is used with the id of the thread instead
+func (m *Messenger) webhook(channelID string) (*discordgo.Webhook, error) {
+	m.webhookMutex.Lock()
+	defer m.webhookMutex.Unlock()
+	if webhook, exists := m.webhooks[channelID]; exists {
+		return webhook, nil
+	}
+	webhooks, err := m.session.ChannelWebhooks(channelID)
+	if err != nil {
+		return nil, err
+	}
+	var webhook *discordgo.Webhook
+	for _, existing := range webhooks {
+		if existing.User != nil && existing.User.ID == m.session.State.User.ID && existing.Token != "" {
+			webhook = existing
+			break
+		}
+	}
+	if webhook == nil {
+		if webhook, err = m.session.WebhookCreate(channelID, webhookName, ""); err != nil {
+			return nil, err
+		}
+	}
+	m.webhooks[channelID] = webhook
+	m.ownWebhooks[webhook.ID] = true
+	return webhook, nil
+}
+
+// isOwnMessage checks if the message was sent by the bot itself or by one of its webhooks, e.g. it is a forwarded copy
+func (m *Messenger) isOwnMessage(message *discordgo.Message) bool {
+	if message.Author != nil && message.Author.ID == m.session.State.User.ID {
+		return true
+	}
+	if message.WebhookID == "" {
+		return false
+	}
+
+	m.webhookMutex.Lock()
+	own, known := m.ownWebhooks[message.WebhookID]
+	m.webhookMutex.Unlock()
+	if known {
+		return own
+	}
+	webhook, err := m.session.Webhook(message.WebhookID)
+	if err != nil {
+		log.Printf("could not get discord webhook %s: %v", message.WebhookID, err)
+		return false
+	}
+	own = webhook.User != nil && webhook.User.ID == m.session.State.User.ID
+	m.webhookMutex.Lock()
+	m.ownWebhooks[message.WebhookID] = own
+	m.webhookMutex.Unlock()
+	return own
+}
@@ -0,0 +1,98 @@
+package discord
+
+import (
+	"github.com/Pelmenner/TransferBot/messenger"
+	"regexp"
+	"strings"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// markdownSyntax is the subset of Markdown discord formats messages with
+var markdownSyntax = messenger.MarkupSyntax{
+	Delimiters: []messenger.Delimiter{
+		{Mark: "**", Type: "bold", Intraword: true},
+		{Mark: "__", Type: "underline", Intraword: true},
+		{Mark: "~~", Type: "strikethrough", Intraword: true},
+		{Mark: "||", Type: "spoiler", Intraword: true},
+		{Mark: "*", Type: "italic", Intraword: true},
+		{Mark: "_", Type: "italic"},
+	},
+	Escapes:       true,
+	Quotes:        true,
+	CodeLanguages: true,
+	Link:          parseLink,
+}
+
+// maskedLinkPattern matches links with texts, angle brackets around a link only hide its preview
+var maskedLinkPattern = regexp.MustCompile(`^(?:\[([^\]\n]+)\]\(<?(https?://[^\s)>]+)>?\)|<(https?://[^\s>]+)>)`)
+
+var urlPattern = regexp.MustCompile(`https?://\S+`)
+
+func parseMarkdown(text string) (string, []*msg.TextEntity) {
+	return messenger.ParseMarkup(text, markdownSyntax)
+}
+
+func parseLink(text string) (string, string, int) {
+	match := maskedLinkPattern.FindStringSubmatch(text)
+	if match == nil {
+		return "", "", 0
+	}
+	if match[3] != "" {
+		return match[3], "", len(match[0])
+	}
+	return match[1], match[2], len(match[0])
+}
+
+// messageText returns the text of the message in Markdown, it is already formatted by the controller
+func messageText(message *msg.Message) string {
+	return messenger.FormatText(message.Text, message.Entities, markdownMarkup, escapeMarkdown)
+}
+
+// markdownMarkup marks formatted parts of texts with Markdown supported by discord
+func markdownMarkup(entity *msg.TextEntity, _ string) (string, string) {
+	switch entity.Type {
+	case "bold":
+		return "**", "**"
+	case "italic":
+		return "*", "*"
+	case "underline":
+		return "__", "__"
+	case "strikethrough":
+		return "~~", "~~"
+	case "spoiler":
+		return "||", "||"
+	case "code":
+		return "`", "`"
+	case "pre":
+		return "```" + entity.Language + "\n", "\n```"
+	case "link":
+		return "[", "](" + strings.ReplaceAll(entity.Url, ")", "%29") + ")"
+	case "blockquote":
+		return "> ", ""
+	}
+	return "", ""
+}
+
+// markdownEscaper escapes characters which may be taken for Markdown
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "|", `\|`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`,
+	"\n>", "\n\\>", "\n#", "\n\\#", "\n-", "\n\\-",
+)
+
+// escapeMarkdown escapes the text except for links, which would stop working.
+// Text inside code is shown as it is anyway
+func escapeMarkdown(text string, code bool) string {
+	if code {
+		return text
+	}
+	var res strings.Builder
+	last := 0
+	for _, link := range urlPattern.FindAllStringIndex(text, -1) {
+		res.WriteString(markdownEscaper.Replace(text[last:link[0]]))
+		res.WriteString(text[link[0]:link[1]])
+		last = link[1]
+	}
+	res.WriteString(markdownEscaper.Replace(text[last:]))
+	return res.String()
+}
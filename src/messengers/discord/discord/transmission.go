@@ -0,0 +1,363 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/bwmarrin/discordgo"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// messageMaxLength is the maximum number of characters in a message
+	messageMaxLength = 2000
+	// messageMaxFiles is the maximum number of files attached to a message
+	messageMaxFiles = 10
+	// usernameMaxLength is the maximum length of names messages are sent with by webhooks
+	usernameMaxLength = 80
+	// pollDuration is the number of hours polls are open for
+	pollDuration = 24
+)
+
+// destination is a channel or a thread messages are sent to
+type destination struct {
+	channelID string
+	guildID   string
+	// webhook sends messages on behalf of their senders, it is nil if messages are sent by the bot
+	webhook *discordgo.Webhook
+	// threadID is set if the destination is a thread, the webhook of its parent channel sends messages there
+	threadID string
+}
+
+// messagePart is a single discord message a forwarded message is split into
+type messagePart struct {
+	content     string
+	attachments []*msg.Attachment
+}
+
+func (m *Messenger) SendMessage(ctx context.Context, request *msg.SendMessageRequest) (*msg.SendMessageResponse, error) {
+	cleanup, err := m.DownloadAttachments(ctx, request.Message.Attachments)
+	if err != nil {
+		log.Printf("could not download attachments: %v", err)
+		return &msg.SendMessageResponse{}, status.Error(codes.Unknown, "could not download attachments")
+	}
+	defer cleanup()
+
+	message := request.Message
+	username := webhookUsername(message.Sender)
+	dest := m.destination(request.Chat, username != "")
+	reference := getReference(dest, message.ReplyTo)
+
+	response := &msg.SendMessageResponse{}
+	for _, part := range splitMessage(m.prepareText(dest, message), message.Attachments) {
+		id, err := m.send(ctx, dest, part, username, reference)
+		if err != nil {
+			log.Printf("could not send discord message: %v", err)
+			return messenger.SendFailed(response, "could not send the message")
+		}
+		response.MessageIds = append(response.MessageIds, id)
+		// only the first part is a reply
+		reference = nil
+	}
+	if message.Poll != nil {
+		id, err := m.sendPoll(ctx, dest.channelID, message.Poll)
+		if err != nil {
+			log.Printf("could not send discord poll: %v", err)
+			return messenger.SendFailed(response, "could not send the poll")
+		}
+		response.MessageIds = append(response.MessageIds, id)
+	}
+	return response, nil
+}
+
+func (m *Messenger) EditMessage(_ context.Context, request *msg.EditMessageRequest) (*empty.Empty, error) {
+	if len(request.MessageIds) == 0 {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "no messages to edit")
+	}
+	messageID := request.MessageIds[0]
+	dest := m.destination(request.Chat, true)
+	content := ""
+	// the rest of the parts of a long message are left as they are
+	if parts := messenger.SplitText(m.prepareText(dest, request.Message), messageMaxLength, countCharacter); len(parts) > 0 {
+		content = parts[0]
+	}
+
+	// the message may have been sent by the bot if the webhook failed
+	err := fmt.Errorf("no webhook in channel %s", dest.channelID)
+	if dest.webhook != nil {
+		_, err = m.session.WebhookMessageEdit(dest.webhook.ID, dest.webhook.Token,
+			webhookMessageID(messageID, dest.threadID), &discordgo.WebhookEdit{
+				Content:         &content,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+	}
+	if err != nil {
+		edit := discordgo.NewMessageEdit(dest.channelID, messageID).SetContent(content)
+		edit.AllowedMentions = &discordgo.MessageAllowedMentions{}
+		_, err = m.session.ChannelMessageEditComplex(edit)
+	}
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not edit the message")
+	}
+	return &empty.Empty{}, nil
+}
+
+func (m *Messenger) DeleteMessage(_ context.Context, request *msg.DeleteMessageRequest) (*empty.Empty, error) {
+	channelID := strconv.FormatInt(request.Chat.Id, 10)
+	var dest *destination
+	for _, id := range request.MessageIds {
+		err := m.session.ChannelMessageDelete(channelID, id)
+		if err != nil {
+			// the bot may have no permission to delete messages of others, but webhooks can delete their own ones
+			if dest == nil {
+				webhookDest := m.destination(request.Chat, true)
+				dest = &webhookDest
+			}
+			if dest.webhook != nil {
+				err = m.session.WebhookMessageDelete(dest.webhook.ID, dest.webhook.Token,
+					webhookMessageID(id, dest.threadID))
+			}
+		}
+		if err != nil {
+			log.Print(err)
+			return &empty.Empty{}, status.Error(codes.Unknown, "could not delete the message")
+		}
+	}
+	return &empty.Empty{}, nil
+}
+
+// destination returns where messages to the chat are sent. Webhooks are used only in channels of servers,
+// direct messages are always sent by the bot
+func (m *Messenger) destination(chat *msg.Chat, useWebhook bool) destination {
+	res := destination{channelID: strconv.FormatInt(chat.Id, 10)}
+	channel, err := m.channel(res.channelID)
+	if err != nil {
+		log.Printf("could not get discord channel %s: %v", res.channelID, err)
+		return res
+	}
+	res.guildID = channel.GuildID
+	if !Config.Webhooks || !useWebhook || channel.GuildID == "" {
+		return res
+	}
+
+	webhookChannelID := res.channelID
+	if channel.IsThread() {
+		webhookChannelID, res.threadID = channel.ParentID, res.channelID
+	}
+	webhook, err := m.webhook(webhookChannelID)
+	if err != nil {
+		log.Printf("could not get webhook of discord channel %s, messages are sent by the bot: %v",
+			webhookChannelID, err)
+		res.threadID = ""
+		return res
+	}
+	res.webhook = webhook
+	return res
+}
+
+// forbiddenUsernamePattern matches words discord does not allow in names of webhook messages
+var forbiddenUsernamePattern = regexp.MustCompile(`(?i)discord|clyde`)
+
+// webhookUsername returns the name a message is sent with by a webhook. The name of the chat of the sender
+// is added, since the name is all users see. It is empty if the message should be sent by the bot
+func webhookUsername(sender *msg.Sender) string {
+	if sender == nil {
+		return ""
+	}
+	name := sender.Name
+	if sender.Chat != nil && sender.Chat.Name != "" {
+		name += " (" + sender.Chat.Name + ")"
+	}
+	name = strings.Join(strings.Fields(forbiddenUsernamePattern.ReplaceAllString(name, "")), " ")
+	if runes := []rune(name); len(runes) > usernameMaxLength {
+		name = string(runes[:usernameMaxLength-1]) + "…"
+	}
+	return name
+}
+
+// getReference returns the message the bot replies to. Webhooks can not reply, so a link is added to the text instead
+func getReference(dest destination, reply *msg.Reply) *discordgo.MessageReference {
+	if reply == nil || dest.webhook != nil {
+		return nil
+	}
+	failIfNotExists := false
+	return &discordgo.MessageReference{
+		MessageID:       reply.MessageId,
+		ChannelID:       dest.channelID,
+		FailIfNotExists: &failIfNotExists,
+	}
+}
+
+// prepareText returns the message text in Markdown with the content discord can not show natively added to it.
+// Polls are sent as separate messages
+func (m *Messenger) prepareText(dest destination, message *msg.Message) string {
+	var parts []string
+	if message.ReplyTo != nil && dest.webhook != nil && dest.guildID != "" {
+		parts = append(parts, fmt.Sprintf("↪ https://discord.com/channels/%s/%s/%s",
+			dest.guildID, dest.channelID, message.ReplyTo.MessageId))
+	}
+	parts = append(parts, messageText(message))
+	if message.Location != nil {
+		parts = append(parts, escapeMarkdown(messenger.LocationText(message.Location), false))
+	}
+	if message.Contact != nil {
+		parts = append(parts, escapeMarkdown(messenger.ContactText(message.Contact), false))
+	}
+
+	var text []string
+	for _, part := range parts {
+		if strings.TrimSpace(part) != "" {
+			text = append(text, part)
+		}
+	}
+	return strings.Join(text, "\n\n")
+}
+
+func countCharacter(rune) int {
+	return 1
+}
+
+// splitMessage splits the text and the attachments into messages discord accepts.
+// The first files are attached to the last part of the text, the rest are sent in separate messages
+func splitMessage(text string, attachments []*msg.Attachment) []messagePart {
+	var parts []messagePart
+	for _, content := range messenger.SplitText(text, messageMaxLength, countCharacter) {
+		parts = append(parts, messagePart{content: content})
+	}
+	for start := 0; start < len(attachments); start += messageMaxFiles {
+		files := attachments[start:min(start+messageMaxFiles, len(attachments))]
+		if start == 0 && len(parts) > 0 {
+			parts[len(parts)-1].attachments = files
+		} else {
+			parts = append(parts, messagePart{attachments: files})
+		}
+	}
+	return parts
+}
+
+// send sends the part by the webhook of the destination, or by the bot if there is no webhook or it fails.
+// Requests are cancelled when the context of the delivery is done
+func (m *Messenger) send(ctx context.Context, dest destination, part messagePart, username string,
+	reference *discordgo.MessageReference) (string, error) {
+	if dest.webhook != nil {
+		id, err := m.sendByWebhook(ctx, dest, part, username)
+		if err == nil {
+			return id, nil
+		}
+		log.Printf("could not send discord message by webhook, sending it by the bot: %v", err)
+	}
+	files, closeFiles, err := openFiles(part.attachments)
+	if err != nil {
+		return "", err
+	}
+	defer closeFiles()
+	sent, err := m.session.ChannelMessageSendComplex(dest.channelID, &discordgo.MessageSend{
+		Content:         part.content,
+		Files:           files,
+		Reference:       reference,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return sent.ID, nil
+}
+
+func (m *Messenger) sendByWebhook(ctx context.Context, dest destination, part messagePart, username string) (string, error) {
+	files, closeFiles, err := openFiles(part.attachments)
+	if err != nil {
+		return "", err
+	}
+	defer closeFiles()
+	sent, err := m.session.WebhookThreadExecute(dest.webhook.ID, dest.webhook.Token, true, dest.threadID,
+		&discordgo.WebhookParams{
+			Content:         part.content,
+			Username:        username,
+			Files:           files,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return sent.ID, nil
+}
+
+// sendPoll sends a native poll by the bot, webhooks can not send polls.
+// Polls discord does not accept are sent as text
+func (m *Messenger) sendPoll(ctx context.Context, channelID string, poll *msg.Poll) (string, error) {
+	answers := make([]discordgo.PollAnswer, 0, len(poll.Options))
+	for _, option := range poll.Options {
+		answers = append(answers, discordgo.PollAnswer{Media: &discordgo.PollMedia{Text: option}})
+	}
+	sent, err := m.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Poll: &discordgo.Poll{
+			Question:         discordgo.PollMedia{Text: poll.Question},
+			Answers:          answers,
+			AllowMultiselect: poll.MultipleAnswers,
+			Duration:         pollDuration,
+		},
+	}, discordgo.WithContext(ctx))
+	if err == nil {
+		return sent.ID, nil
+	}
+	log.Printf("could not create discord poll, sending it as text: %v", err)
+	sent, err = m.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         escapeMarkdown(messenger.PollText(poll), false),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}, discordgo.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	return sent.ID, nil
+}
+
+// openFiles opens local files of the attachments, the returned function closes them
+func openFiles(attachments []*msg.Attachment) ([]*discordgo.File, func(), error) {
+	var opened []*os.File
+	closeFiles := func() {
+		for _, file := range opened {
+			file.Close()
+		}
+	}
+	var files []*discordgo.File
+	for _, attachment := range attachments {
+		file, err := os.Open(attachment.Url)
+		if err != nil {
+			closeFiles()
+			return nil, nil, fmt.Errorf("could not open file %s: %v", attachment.Url, err)
+		}
+		opened = append(opened, file)
+		name := attachment.Name
+		if name == "" {
+			name = filepath.Base(attachment.Url)
+		}
+		files = append(files, &discordgo.File{
+			Name:        name,
+			ContentType: mime.TypeByExtension(filepath.Ext(name)),
+			Reader:      file,
+		})
+	}
+	return files, closeFiles, nil
+}
+
+// webhookMessageID returns the id of a message sent by a webhook to a thread along with the id of the thread.
+// Discordgo has no parameter for threads when editing and deleting messages of webhooks,
+// so it is added to the id, which is the last part of the path
+func webhookMessageID(messageID, threadID string) string {
+	if threadID == "" {
+		return messageID
+	}
+	return messageID + "?thread_id=" + threadID
+}
@@ -0,0 +1,269 @@
+package discord
+
+import (
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"strconv"
+	"strings"
+	"unicode"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/bwmarrin/discordgo"
+)
+
+// processUpdate handles a new or an edited message and marks its ticket as done once it is passed to the controller
+func (m *Messenger) processUpdate(message *discordgo.Message, ticket *messenger.Ticket, edited bool) {
+	defer ticket.Done()
+	if m.isOwnMessage(message) {
+		return
+	}
+	m.logUpdate(message, edited)
+	chat := m.chatFromMessage(message)
+	if edited {
+		ticket.Wait()
+		if err := m.processEditedMessage(message, chat); err != nil {
+			log.Printf("error processing edited message: %v", err)
+		}
+		return
+	}
+	if strings.HasPrefix(message.Content, "/") {
+		ticket.Wait()
+		err := m.processCommand(message, chat)
+		if err != errCommandNotFound {
+			if err != nil {
+				log.Printf("error processing command: %v", err)
+			}
+			return
+		}
+	}
+	if err := m.processMessage(message, chat, ticket); err != nil {
+		log.Printf("error processing message: %v", err)
+	}
+}
+
+func (m *Messenger) logUpdate(message *discordgo.Message, edited bool) {
+	if edited {
+		log.Printf("edited message: channel id: %s; message id: %s", message.ChannelID, message.ID)
+		return
+	}
+	const template = "new message: channel id: %s; message id: %s; attachments: %d; stickers: %d; reply: %t"
+	log.Printf(template, message.ChannelID, message.ID, len(message.Attachments), len(message.StickerItems),
+		message.MessageReference != nil)
+}
+
+// processMessage handles a new message. Files are downloaded concurrently with other updates,
+// but the message is passed to the controller only when its ticket is up.
+func (m *Messenger) processMessage(message *discordgo.Message, chat *msg.Chat, ticket *messenger.Ticket) error {
+	text, entities := parseMarkdown(message.ContentWithMentionsReplaced())
+	standardMessage := msg.Message{
+		Id:       message.ID,
+		Text:     text,
+		Entities: entities,
+		Sender:   m.getSender(message),
+		ReplyTo:  m.getReply(message),
+		Poll:     getPoll(message.Poll),
+	}
+	attachments, err := m.DownloadFiles(getDiscordFiles(message))
+	if err != nil {
+		log.Printf("could not download discord attachments: %v", err)
+	}
+	for _, attachment := range attachments {
+		if attachment != nil {
+			standardMessage.Attachments = append(standardMessage.Attachments, attachment)
+		}
+	}
+	ticket.Wait()
+	return m.MessageCallback(&standardMessage, chat)
+}
+
+func (m *Messenger) processEditedMessage(message *discordgo.Message, chat *msg.Chat) error {
+	text, entities := parseMarkdown(message.ContentWithMentionsReplaced())
+	standardMessage := msg.Message{
+		Id:       message.ID,
+		Text:     text,
+		Entities: entities,
+		Sender:   m.getSender(message),
+		ReplyTo:  m.getReply(message),
+	}
+	return m.EditedMessageCallback(&standardMessage, chat)
+}
+
+func (m *Messenger) chatFromMessage(message *discordgo.Message) *msg.Chat {
+	// the id is checked when the update is received
+	id, _ := strconv.ParseInt(message.ChannelID, 10, 64)
+	return &msg.Chat{
+		Id:   id,
+		Type: "discord",
+		Name: m.channelName(message.ChannelID),
+	}
+}
+
+func (m *Messenger) getSender(message *discordgo.Message) *msg.Sender {
+	return &msg.Sender{
+		Name: getUserName(message),
+		Chat: &msg.Chat{Name: m.channelName(message.ChannelID)},
+	}
+}
+
+// getUserName returns the name the author of the message is shown with in the channel
+func getUserName(message *discordgo.Message) string {
+	if message.Author == nil {
+		return ""
+	}
+	if message.Member != nil && message.Member.Nick != "" {
+		return message.Member.Nick
+	}
+	if message.Author.GlobalName != "" {
+		return message.Author.GlobalName
+	}
+	return message.Author.Username
+}
+
+func (m *Messenger) getReply(message *discordgo.Message) *msg.Reply {
+	reply := message.ReferencedMessage
+	if message.MessageReference == nil || reply == nil {
+		return nil
+	}
+	text, _ := parseMarkdown(reply.ContentWithMentionsReplaced())
+	return &msg.Reply{
+		MessageId: reply.ID,
+		Sender:    m.getSender(reply),
+		Text:      text,
+	}
+}
+
+// stickerExtensions are extensions of files of stickers by their formats.
+// Lottie stickers can only be shown by discord, so they are not forwarded
+var stickerExtensions = map[discordgo.StickerFormat]string{
+	discordgo.StickerFormatTypePNG:  ".png",
+	discordgo.StickerFormatTypeAPNG: ".png",
+	discordgo.StickerFormatTypeGIF:  ".gif",
+}
+
+// getDiscordFiles returns files attached to the message along with their attachment types
+func getDiscordFiles(message *discordgo.Message) []messenger.RemoteFile {
+	var files []messenger.RemoteFile
+	for _, attachment := range message.Attachments {
+		files = append(files, messenger.RemoteFile{
+			URL:  attachment.URL,
+			Name: attachment.Filename,
+			Type: getAttachmentType(message, attachment),
+		})
+	}
+	for _, sticker := range message.StickerItems {
+		if extension, exists := stickerExtensions[sticker.FormatType]; exists {
+			files = append(files, messenger.RemoteFile{
+				URL:  "https://media.discordapp.net/stickers/" + sticker.ID + extension,
+				Name: sticker.ID + extension,
+				Type: "sticker",
+			})
+		}
+	}
+	return files
+}
+
+func getAttachmentType(message *discordgo.Message, attachment *discordgo.MessageAttachment) string {
+	contentType := attachment.ContentType
+	switch {
+	case message.Flags&discordgo.MessageFlagsIsVoiceMessage != 0:
+		return "voice"
+	case contentType == "image/gif":
+		return "animation"
+	case strings.HasPrefix(contentType, "image/"):
+		return "photo"
+	case strings.HasPrefix(contentType, "video/"):
+		return "video"
+	case strings.HasPrefix(contentType, "audio/"):
+		return "audio"
+	}
+	return "doc"
+}
+
+func getPoll(poll *discordgo.Poll) *msg.Poll {
+	if poll == nil {
+		return nil
+	}
+	res := &msg.Poll{
+		Question:        poll.Question.Text,
+		MultipleAnswers: poll.AllowMultiselect,
+	}
+	for _, answer := range poll.Answers {
+		if answer.Media != nil {
+			res.Options = append(res.Options, answer.Media.Text)
+		}
+	}
+	return res
+}
+
+var errCommandNotFound = fmt.Errorf("command not found")
+
+func (m *Messenger) processCommand(message *discordgo.Message, chat *msg.Chat) error {
+	command, args := splitCommand(message.Content)
+	var reply string
+	var err error
+	switch command {
+	case "/get_token":
+		reply, err = m.GetChatToken(chat.Id, chat.Type)
+	case "/subscribe":
+		reply, err = m.SubscribeCallback(chat, args)
+	case "/unsubscribe":
+		err = m.UnsubscribeCallback(chat, args)
+	case "/bridge":
+		err = m.BridgeCallback(chat, args)
+	case "/filter":
+		reply, err = m.ProcessFilterCommand(chat, args)
+	case "/template":
+		reply, err = m.ProcessTemplateCommand(chat, args)
+	case "/photos":
+		reply, err = m.ProcessPhotosCommand(chat, args)
+	case "/failed":
+		reply, err = m.ProcessFailedCommand(chat)
+	default:
+		return errCommandNotFound
+	}
+	if err == nil && reply != "" {
+		err = m.sendReply(message.ChannelID, reply)
+	}
+	return m.processCommandResult(err, message.ChannelID)
+}
+
+// splitCommand splits the text of a command into the command itself and its arguments
+func splitCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return text, ""
+	}
+	return text[:end], strings.TrimSpace(text[end:])
+}
+
+// processCommandResult checks if there is an error that needs to be sent to the user and tries to send it.
+// If the error was internal, it is added to the returned error
+//
+// It might have some messenger-specific logic in the future, so it should not be moved to baseMessenger.
+func (m *Messenger) processCommandResult(err error, channelID string) error {
+	// If err is nil or not a grpc error, it should be returned immediately
+	if status.Code(err) == codes.OK {
+		return err
+	}
+	sendErr := m.sendReply(channelID, status.Convert(err).Message())
+	if !messenger.IsUserInputError(err) {
+		if sendErr != nil {
+			return fmt.Errorf("could not process command: %v, could not send error %v", err, sendErr)
+		}
+		return err
+	}
+	return sendErr
+}
+
+// sendReply sends a reply to a command by the bot itself
+func (m *Messenger) sendReply(channelID string, text string) error {
+	_, err := m.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Content:         escapeMarkdown(text, false),
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	return err
+}
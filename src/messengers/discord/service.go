@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/discord/discord"
+	"github.com/Pelmenner/TransferBot/messenger"
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"log"
+	"net"
+)
+
+func main() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", discord.Config.Port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	discordMessenger := createMessenger()
+	go discordMessenger.Run(context.Background())
+	grpcServer := grpc.NewServer()
+	msg.RegisterChatServiceServer(grpcServer, discordMessenger)
+
+	log.Printf("initializing gRPC server on port %d", discord.Config.Port)
+	if err = grpcServer.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func createMessenger() *discord.Messenger {
+	connection, err := grpc.Dial(discord.Config.ControllerHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("could not connect to controller on %s", discord.Config.ControllerHost)
+	}
+	discordMessenger, err := discord.NewMessenger(messenger.NewBaseMessenger(connection))
+	if err != nil {
+		log.Fatalf("could not create messenger: %v", err)
+	}
+	log.Printf("connected to controller on %s", discord.Config.ControllerHost)
+	return discordMessenger
+}
@@ -0,0 +1,190 @@
+package irc
+
+import (
+	"github.com/Pelmenner/TransferBot/messenger"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// formatting control codes of mIRC, most clients support them
+const (
+	codeBold          = '\x02'
+	codeColor         = '\x03'
+	codeHexColor      = '\x04'
+	codeReset         = '\x0f'
+	codeMonospace     = '\x11'
+	codeReverse       = '\x16'
+	codeItalic        = '\x1d'
+	codeStrikethrough = '\x1e'
+	codeUnderline     = '\x1f'
+)
+
+// toggles are codes which turn formatting on and off, with the types of entities they make
+var toggles = map[byte]string{
+	codeBold:          "bold",
+	codeItalic:        "italic",
+	codeUnderline:     "underline",
+	codeStrikethrough: "strikethrough",
+	codeMonospace:     "code",
+}
+
+// parseFormatting returns the text of a message without control codes along with its formatting.
+// Colors are dropped
+func parseFormatting(text string) (string, []*msg.TextEntity) {
+	var res strings.Builder
+	var units int32
+	var entities []*msg.TextEntity
+	opened := make(map[byte]*msg.TextEntity)
+	closeEntity := func(code byte) {
+		entity := opened[code]
+		delete(opened, code)
+		entity.Length = units - entity.Offset
+		if entity.Length > 0 {
+			entities = append(entities, entity)
+		}
+	}
+
+	for i := 0; i < len(text); {
+		code := text[i]
+		switch {
+		case toggles[code] != "":
+			if opened[code] != nil {
+				closeEntity(code)
+			} else {
+				opened[code] = &msg.TextEntity{Type: toggles[code], Offset: units}
+			}
+			i++
+		case code == codeReset:
+			for toggle := range opened {
+				closeEntity(toggle)
+			}
+			i++
+		case code == codeColor:
+			i += 1 + colorLength(text[i+1:], 2, isDigit)
+		case code == codeHexColor:
+			i += 1 + colorLength(text[i+1:], 6, isHexDigit)
+		case code == codeReverse:
+			i++
+		default:
+			char, size := utf8.DecodeRuneInString(text[i:])
+			res.WriteString(text[i : i+size])
+			units += int32(len(utf16.Encode([]rune{char})))
+			i += size
+		}
+	}
+	for code := range opened {
+		closeEntity(code)
+	}
+	return res.String(), entities
+}
+
+// colorLength returns the length of the foreground and the optional background colors after a color code,
+// e.g. "4", "04,12" or "FF0000,000000"
+func colorLength(text string, digits int, isColorDigit func(byte) bool) int {
+	length := 0
+	for length < digits && length < len(text) && isColorDigit(text[length]) {
+		length++
+	}
+	if length == 0 || length >= len(text) || text[length] != ',' {
+		return length
+	}
+	background := 0
+	for background < digits && length+1+background < len(text) && isColorDigit(text[length+1+background]) {
+		background++
+	}
+	if background == 0 {
+		// the comma is a part of the text
+		return length
+	}
+	return length + 1 + background
+}
+
+func isDigit(char byte) bool {
+	return '0' <= char && char <= '9'
+}
+
+func isHexDigit(char byte) bool {
+	return isDigit(char) || ('a' <= char && char <= 'f') || ('A' <= char && char <= 'F')
+}
+
+// messageText returns the text of the message with control codes, it is already formatted by the controller
+func messageText(message *msg.Message) string {
+	return messenger.FormatText(message.Text, message.Entities, formattingMarkup, stripCodes)
+}
+
+// formattingMarkup marks formatted parts of texts with control codes. Links are shown with their targets
+// and spoilers as black text on black
+func formattingMarkup(entity *msg.TextEntity, content string) (string, string) {
+	switch entity.Type {
+	case "bold":
+		return string(codeBold), string(codeBold)
+	case "italic":
+		return string(codeItalic), string(codeItalic)
+	case "underline":
+		return string(codeUnderline), string(codeUnderline)
+	case "strikethrough":
+		return string(codeStrikethrough), string(codeStrikethrough)
+	case "code", "pre":
+		return string(codeMonospace), string(codeMonospace)
+	case "spoiler":
+		return string(codeColor) + "01,01", string(codeColor)
+	case "link":
+		if content == entity.Url {
+			return "", ""
+		}
+		return "", " (" + entity.Url + ")"
+	case "blockquote":
+		return "> ", ""
+	}
+	return "", ""
+}
+
+// stripCodes removes control codes from the text, so that it could not change the formatting
+func stripCodes(text string, _ bool) string {
+	return strings.Map(func(char rune) rune {
+		if char < ' ' && char != '\n' && char != '\t' {
+			return -1
+		}
+		return char
+	}, text)
+}
+
+// splitText splits the text into lines shorter than the maximum length in bytes, IRC has no multiline messages.
+// Formatting which is turned on at the end of a line is turned on again at the start of the next one,
+// so the maximum length should leave space for these codes
+func splitText(text string, maxLength int) []string {
+	var lines []string
+	active := ""
+	for _, line := range strings.Split(text, "\n") {
+		for _, part := range messenger.SplitText(line, maxLength, utf8.RuneLen) {
+			part = active + part
+			active = activeCodes(part)
+			if strings.TrimSpace(stripCodes(part, false)) != "" {
+				lines = append(lines, part)
+			}
+		}
+	}
+	return lines
+}
+
+// activeCodes returns the toggle codes turned on at the end of the line
+func activeCodes(line string) string {
+	var active []byte
+	for i := 0; i < len(line); i++ {
+		code := line[i]
+		switch {
+		case code == codeReset:
+			active = active[:0]
+		case toggles[code] != "":
+			if index := strings.IndexByte(string(active), code); index >= 0 {
+				active = append(active[:index], active[index+1:]...)
+			} else {
+				active = append(active, code)
+			}
+		}
+	}
+	return string(active)
+}
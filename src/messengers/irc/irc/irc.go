@@ -0,0 +1,149 @@
+package irc
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"strings"
+	"time"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/lrstanley/girc"
+)
+
+// reconnectDelay is the delay before connecting to a network again after the connection was lost
+const reconnectDelay = 30 * time.Second
+
+type Messenger struct {
+	*messenger.BaseMessenger
+	// clients are connections to the networks by their names
+	clients map[string]*girc.Client
+	// chats are addresses of channels and direct chats by ids of their chats, an address is
+	// the name of the network and the channel or the nick of the user, e.g. "libera/#transferbot"
+	chats *messenger.ChatAddresses
+	// updates are processed concurrently, the sequencer keeps them in order when they are passed to the controller
+	sequencer *messenger.Sequencer
+}
+
+func NewMessenger(baseMessenger *messenger.BaseMessenger) (*Messenger, error) {
+	newMessenger := &Messenger{
+		BaseMessenger: baseMessenger,
+		clients:       make(map[string]*girc.Client),
+		chats:         messenger.NewChatAddresses(),
+		sequencer:     messenger.NewSequencer(),
+	}
+	for _, network := range Config.Networks {
+		config := girc.Config{
+			Server: network.Server,
+			Port:   network.Port,
+			SSL:    network.SSL,
+			Nick:   Config.Nick,
+			User:   Config.Nick,
+			Name:   "TransferBot",
+		}
+		if network.User != "" {
+			config.SASL = &girc.SASLPlain{User: network.User, Pass: network.Password}
+		}
+		client := girc.New(config)
+		client.Handlers.Add(girc.CONNECTED, newMessenger.onConnected(network))
+		client.Handlers.Add(girc.PRIVMSG, newMessenger.onMessage(network.Name))
+		newMessenger.clients[network.Name] = client
+		for _, channel := range network.Channels {
+			newMessenger.chats.Add(address(network.Name, channel))
+		}
+	}
+	return newMessenger, nil
+}
+
+// Run keeps connections to all the networks until the context is done
+func (m *Messenger) Run(ctx context.Context) {
+	for name, client := range m.clients {
+		go m.connect(ctx, name, client)
+	}
+	<-ctx.Done()
+	for _, client := range m.clients {
+		client.Close()
+	}
+}
+
+func (m *Messenger) connect(ctx context.Context, name string, client *girc.Client) {
+	for {
+		err := client.Connect()
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("connection to irc network %s stopped: %v", name, err)
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// onConnected joins the configured channels of the network, they are joined again after reconnecting
+func (m *Messenger) onConnected(network *Network) func(*girc.Client, girc.Event) {
+	return func(client *girc.Client, _ girc.Event) {
+		log.Printf("connected to irc network %s as %s", network.Name, client.GetNick())
+		if len(network.Channels) > 0 {
+			client.Cmd.Join(network.Channels...)
+		}
+	}
+}
+
+func (m *Messenger) onMessage(network string) func(*girc.Client, girc.Event) {
+	return func(client *girc.Client, event girc.Event) {
+		if event.Source == nil || event.Source.Name == client.GetNick() || len(event.Params) < 2 {
+			return
+		}
+		// other CTCP requests are answered by the client itself
+		if ok, _ := event.IsCTCP(); ok && !event.IsAction() {
+			return
+		}
+		chatID := m.chats.Add(address(network, target(&event)))
+		go m.processUpdate(network, event, m.sequencer.Ticket(chatID))
+	}
+}
+
+// target returns the channel the message was sent to, or the nick of the sender of a direct message
+func target(event *girc.Event) string {
+	if event.IsFromChannel() {
+		return event.Params[0]
+	}
+	return event.Source.Name
+}
+
+// address returns the address of a channel or a direct chat. IRC names are case-insensitive
+func address(network, target string) string {
+	return network + "/" + strings.ToLower(target)
+}
+
+func (m *Messenger) chat(network, target string) *msg.Chat {
+	name := "irc"
+	if girc.IsValidChannel(target) {
+		name = target
+	}
+	chatAddress := address(network, target)
+	return &msg.Chat{
+		Id:      m.chats.Add(chatAddress),
+		Type:    "irc",
+		Name:    name,
+		Address: chatAddress,
+	}
+}
+
+// destination returns the connection to the network of the chat and the channel or the nick messages are sent to.
+// Only configured channels are known on start, so addresses of direct chats are taken from the controller
+func (m *Messenger) destination(chat *msg.Chat) (*girc.Client, string, error) {
+	chatAddress := chat.GetAddress()
+	if chatAddress == "" {
+		// the controller has not saved the address of the chat yet
+		chatAddress = m.chats.Get(chat.Id)
+	}
+	network, target, found := strings.Cut(chatAddress, "/")
+	if !found {
+		return nil, "", fmt.Errorf("unknown irc chat %d", chat.Id)
+	}
+	client, exists := m.clients[network]
+	if !exists {
+		return nil, "", fmt.Errorf("unknown irc network %s of chat %d", network, chat.Id)
+	}
+	return client, target, nil
+}
@@ -0,0 +1,62 @@
+package irc
+
+import (
+	"context"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"strings"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/lrstanley/girc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SendMessage sends the text of the message line by line. IRC has no attachments, they are replaced
+// with links by the controller. Messages have no ids, so nothing is returned for them
+func (m *Messenger) SendMessage(_ context.Context, request *msg.SendMessageRequest) (*msg.SendMessageResponse, error) {
+	client, target, err := m.destination(request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &msg.SendMessageResponse{}, status.Error(codes.NotFound, "unknown chat")
+	}
+	if !client.IsConnected() {
+		return &msg.SendMessageResponse{}, status.Error(codes.Unavailable, "not connected to the network")
+	}
+	message := request.Message
+	if len(message.Attachments) > 0 {
+		log.Printf("%d attachments can not be sent to irc, they are skipped", len(message.Attachments))
+	}
+	for _, line := range splitText(prepareText(message), lineMaxLength(client, girc.PRIVMSG, target)) {
+		client.Cmd.Message(target, line)
+	}
+	return &msg.SendMessageResponse{}, nil
+}
+
+// EditMessage is not supported, IRC messages can not be changed
+func (m *Messenger) EditMessage(context.Context, *msg.EditMessageRequest) (*empty.Empty, error) {
+	return &empty.Empty{}, status.Error(codes.Unimplemented, "irc messages can not be edited")
+}
+
+// DeleteMessage is not supported, IRC messages can not be deleted
+func (m *Messenger) DeleteMessage(context.Context, *msg.DeleteMessageRequest) (*empty.Empty, error) {
+	return &empty.Empty{}, status.Error(codes.Unimplemented, "irc messages can not be deleted")
+}
+
+// prepareText returns the message text with control codes and the content IRC can not show added to it
+func prepareText(message *msg.Message) string {
+	var text []string
+	for _, part := range []string{messageText(message), stripCodes(messenger.ContentText(message), false)} {
+		if strings.TrimSpace(part) != "" {
+			text = append(text, part)
+		}
+	}
+	return strings.Join(text, "\n\n")
+}
+
+// lineMaxLength returns the maximum length of a line of text sent to the target by the command,
+// so that the line is not cut by the server. Space is left for the formatting continued from the previous line
+func lineMaxLength(client *girc.Client, command, target string) int {
+	return client.MaxEventLength() - len(command+" "+target+" :") - len(toggles)
+}
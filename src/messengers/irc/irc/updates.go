@@ -0,0 +1,141 @@
+package irc
+
+import (
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/lrstanley/girc"
+)
+
+// lastMessageID makes ids of messages on networks which do not give them ids
+var lastMessageID atomic.Int64
+
+func init() {
+	lastMessageID.Store(time.Now().UnixNano())
+}
+
+// processUpdate handles a message and marks its ticket as done once it is passed to the controller
+func (m *Messenger) processUpdate(network string, event girc.Event, ticket *messenger.Ticket) {
+	defer ticket.Done()
+	ticket.Wait()
+	target := target(&event)
+	log.Printf("new message: network: %s; target: %s; action: %t", network, target, event.IsAction())
+	chat := m.chat(network, target)
+	client := m.clients[network]
+	if text := event.Last(); strings.HasPrefix(text, "!") {
+		err := m.processCommand(client, target, text, chat)
+		if err != errCommandNotFound {
+			if err != nil {
+				log.Printf("error processing command: %v", err)
+			}
+			return
+		}
+	}
+	if err := m.processMessage(event, chat); err != nil {
+		log.Printf("error processing message: %v", err)
+	}
+}
+
+func (m *Messenger) processMessage(event girc.Event, chat *msg.Chat) error {
+	text := event.Last()
+	if event.IsAction() {
+		// actions are shown after the name of the sender
+		text = "* " + event.StripAction()
+	}
+	text, entities := parseFormatting(text)
+	standardMessage := msg.Message{
+		Id:       messageID(event),
+		Text:     text,
+		Entities: entities,
+		Sender: &msg.Sender{
+			Name: event.Source.Name,
+			Chat: &msg.Chat{Name: chat.Name},
+		},
+	}
+	return m.MessageCallback(&standardMessage, chat)
+}
+
+// messageID returns the id given to the message by the network, if it supports the msgid tag,
+// or a new unique id otherwise
+func messageID(event girc.Event) string {
+	if id, ok := event.Tags.Get("msgid"); ok && id != "" {
+		return id
+	}
+	return strconv.FormatInt(lastMessageID.Add(1), 10)
+}
+
+var errCommandNotFound = fmt.Errorf("command not found")
+
+// processCommand handles a command. IRC clients handle messages starting with "/" themselves,
+// so commands start with "!", e.g. !subscribe <token>
+func (m *Messenger) processCommand(client *girc.Client, target, text string, chat *msg.Chat) error {
+	command, args := splitCommand(text)
+	var reply string
+	var err error
+	switch command {
+	case "get_token":
+		reply, err = m.GetChatToken(chat.Id, chat.Type)
+	case "subscribe":
+		reply, err = m.SubscribeCallback(chat, args)
+	case "unsubscribe":
+		err = m.UnsubscribeCallback(chat, args)
+	case "bridge":
+		err = m.BridgeCallback(chat, args)
+	case "filter":
+		reply, err = m.ProcessFilterCommand(chat, args)
+	case "template":
+		reply, err = m.ProcessTemplateCommand(chat, args)
+	case "photos":
+		reply, err = m.ProcessPhotosCommand(chat, args)
+	case "failed":
+		reply, err = m.ProcessFailedCommand(chat)
+	default:
+		return errCommandNotFound
+	}
+	if err == nil && reply != "" {
+		sendReply(client, target, reply)
+	}
+	return processCommandResult(err, client, target)
+}
+
+// splitCommand splits the text of a command into the name of the command without its prefix and its arguments
+func splitCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)[1:]
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return text, ""
+	}
+	return text[:end], strings.TrimSpace(text[end:])
+}
+
+// processCommandResult checks if there is an error that needs to be sent to the user and sends it.
+// If the error was internal, it is returned
+//
+// It might have some messenger-specific logic in the future, so it should not be moved to baseMessenger.
+func processCommandResult(err error, client *girc.Client, target string) error {
+	// If err is nil or not a grpc error, it should be returned immediately
+	if status.Code(err) == codes.OK {
+		return err
+	}
+	sendReply(client, target, status.Convert(err).Message())
+	if !messenger.IsUserInputError(err) {
+		return err
+	}
+	return nil
+}
+
+// sendReply sends a reply to a command as notices, which bots do not respond to
+func sendReply(client *girc.Client, target, text string) {
+	for _, line := range splitText(stripCodes(text, false), lineMaxLength(client, "NOTICE", target)) {
+		client.Cmd.Notice(target, line)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/irc/irc"
+	"github.com/Pelmenner/TransferBot/messenger"
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"log"
+	"net"
+)
+
+func main() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", irc.Config.Port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	ircMessenger := createMessenger()
+	go ircMessenger.Run(context.Background())
+	grpcServer := grpc.NewServer()
+	msg.RegisterChatServiceServer(grpcServer, ircMessenger)
+
+	log.Printf("initializing gRPC server on port %d", irc.Config.Port)
+	if err = grpcServer.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func createMessenger() *irc.Messenger {
+	connection, err := grpc.Dial(irc.Config.ControllerHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("could not connect to controller on %s", irc.Config.ControllerHost)
+	}
+	ircMessenger, err := irc.NewMessenger(messenger.NewBaseMessenger(connection))
+	if err != nil {
+		log.Fatalf("could not create messenger: %v", err)
+	}
+	log.Printf("connected to controller on %s", irc.Config.ControllerHost)
+	return ircMessenger
+}
@@ -0,0 +1,34 @@
+module github.com/Pelmenner/TransferBot/matrix
+
+go 1.24.0
+
+replace github.com/Pelmenner/TransferBot/messenger v0.0.0 => ../messenger
+
+replace github.com/Pelmenner/TransferBot/proto v0.0.0 => ../../proto
+
+require (
+	github.com/Pelmenner/TransferBot/messenger v0.0.0
+	github.com/Pelmenner/TransferBot/proto v0.0.0
+	github.com/golang/protobuf v1.5.3
+	golang.org/x/net v0.48.0
+	google.golang.org/grpc v1.56.3
+	maunium.net/go/mautrix v0.22.0
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.19 // indirect
+	github.com/rs/zerolog v1.33.0 // indirect
+	github.com/tidwall/gjson v1.18.0 // indirect
+	github.com/tidwall/match v1.1.1 // indirect
+	github.com/tidwall/pretty v1.2.0 // indirect
+	github.com/tidwall/sjson v1.2.5 // indirect
+	go.mau.fi/util v0.8.2 // indirect
+	golang.org/x/crypto v0.46.0 // indirect
+	golang.org/x/exp v0.0.0-20241108190413-2d47ceb2692f // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/protobuf v1.35.2 // indirect
+)
@@ -0,0 +1,40 @@
+package matrix
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+var Config = struct {
+	// HomeserverURL is the address of the client-server API of the homeserver, e.g. https://matrix.example.com
+	HomeserverURL string
+	// UserID is the id of the bot, it is requested from the homeserver if it is empty
+	UserID         string
+	AccessToken    string
+	Port           int
+	ControllerHost string
+}{
+	HomeserverURL:  os.Getenv("MATRIX_HOMESERVER"),
+	UserID:         os.Getenv("MATRIX_USER_ID"),
+	AccessToken:    os.Getenv("MATRIX_ACCESS_TOKEN"),
+	ControllerHost: os.Getenv("CONTROLLER_HOST"),
+}
+
+func init() {
+	if len(Config.HomeserverURL) == 0 {
+		log.Panic("Matrix homeserver not provided")
+	}
+	if len(Config.AccessToken) == 0 {
+		log.Panic("Matrix access token not provided")
+	}
+}
+
+func init() {
+	port := os.Getenv("PORT")
+	var err error
+	Config.Port, err = strconv.Atoi(port)
+	if err != nil {
+		log.Panic("Invalid Matrix service port")
+	}
+}
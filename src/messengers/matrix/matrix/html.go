@@ -0,0 +1,243 @@
+package matrix
+
+import (
+	"github.com/Pelmenner/TransferBot/messenger"
+	"strings"
+	"unicode/utf16"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"golang.org/x/net/html"
+)
+
+// entityTypes are types of entities made by tags of formatted messages
+var entityTypes = map[string]string{
+	"b":          "bold",
+	"strong":     "bold",
+	"i":          "italic",
+	"em":         "italic",
+	"u":          "underline",
+	"ins":        "underline",
+	"s":          "strikethrough",
+	"del":        "strikethrough",
+	"strike":     "strikethrough",
+	"code":       "code",
+	"pre":        "pre",
+	"a":          "link",
+	"blockquote": "blockquote",
+	"h1":         "bold",
+	"h2":         "bold",
+	"h3":         "bold",
+	"h4":         "bold",
+	"h5":         "bold",
+	"h6":         "bold",
+}
+
+// blockTags are tags which start on a new line
+var blockTags = map[string]bool{
+	"p": true, "div": true, "pre": true, "blockquote": true, "li": true, "ul": true, "ol": true, "table": true,
+	"tr": true, "hr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// openedTag is a tag whose end has not been found yet, entity is nil if the tag does not format the text
+type openedTag struct {
+	name   string
+	entity *msg.TextEntity
+}
+
+// htmlParser keeps the state of parseHTML
+type htmlParser struct {
+	res      strings.Builder
+	units    int32
+	entities []*msg.TextEntity
+	opened   []openedTag
+}
+
+// parseHTML returns the text of a formatted message along with its formatting.
+// Unknown tags are dropped, their text is kept
+func parseHTML(source string) (string, []*msg.TextEntity) {
+	p := &htmlParser{}
+	tokenizer := html.NewTokenizer(strings.NewReader(source))
+	// the fallback of a reply quotes the original message, which is passed to the controller separately
+	skipReply := 0
+	for {
+		tokenType := tokenizer.Next()
+		// the tokenizer stops with an error at the end of the text
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+		switch {
+		case token.Data == "mx-reply" && tokenType == html.StartTagToken:
+			skipReply++
+		case token.Data == "mx-reply" && tokenType == html.EndTagToken:
+			skipReply--
+		case skipReply > 0:
+		case tokenType == html.TextToken:
+			// line breaks between tags only format the source, they are shown in pre-formatted blocks only
+			if p.openedPre() != nil || strings.TrimSpace(token.Data) != "" || !strings.Contains(token.Data, "\n") {
+				p.write(token.Data)
+			}
+		case tokenType == html.StartTagToken || tokenType == html.SelfClosingTagToken:
+			p.open(token, tokenType == html.SelfClosingTagToken)
+		case tokenType == html.EndTagToken:
+			p.close(token.Data)
+		}
+	}
+
+	text := strings.TrimRight(p.res.String(), "\n")
+	units := int32(len(utf16.Encode([]rune(text))))
+	var entities []*msg.TextEntity
+	for _, entity := range p.entities {
+		entity.Length = min(entity.Length, units-entity.Offset)
+		if entity.Length > 0 {
+			entities = append(entities, entity)
+		}
+	}
+	return text, entities
+}
+
+func (p *htmlParser) write(text string) {
+	p.res.WriteString(text)
+	p.units += int32(len(utf16.Encode([]rune(text))))
+}
+
+// newLine starts a new line unless the text is empty or already ends with a line break
+func (p *htmlParser) newLine() {
+	if text := p.res.String(); text != "" && !strings.HasSuffix(text, "\n") {
+		p.write("\n")
+	}
+}
+
+func (p *htmlParser) open(token html.Token, selfClosing bool) {
+	if blockTags[token.Data] {
+		p.newLine()
+	}
+	switch token.Data {
+	case "br":
+		p.write("\n")
+		return
+	case "img":
+		p.write(attribute(token, "alt"))
+		return
+	case "li":
+		p.write("• ")
+	}
+	if selfClosing {
+		return
+	}
+
+	opened := openedTag{name: token.Data}
+	entityType := entityTypes[token.Data]
+	if token.Data == "span" && hasAttribute(token, "data-mx-spoiler") {
+		entityType = "spoiler"
+	}
+	if pre := p.openedPre(); entityType == "code" && pre != nil {
+		// the language of a pre-formatted block is the class of its code
+		pre.Language = strings.TrimPrefix(attribute(token, "class"), "language-")
+		entityType = ""
+	}
+	if entityType != "" {
+		opened.entity = &msg.TextEntity{Type: entityType, Offset: p.units}
+		if entityType == "link" {
+			opened.entity.Url = attribute(token, "href")
+		}
+		p.entities = append(p.entities, opened.entity)
+	}
+	p.opened = append(p.opened, opened)
+}
+
+// close ends the latest tag with the name, tags opened after it are ended as well
+func (p *htmlParser) close(name string) {
+	i := len(p.opened) - 1
+	for i >= 0 && p.opened[i].name != name {
+		i--
+	}
+	if i < 0 {
+		return
+	}
+	// line breaks at the end of blocks are left out of their entities
+	text := p.res.String()
+	lineBreaks := int32(len(text) - len(strings.TrimRight(text, "\n")))
+	for _, opened := range p.opened[i:] {
+		if opened.entity != nil {
+			opened.entity.Length = max(p.units-opened.entity.Offset-lineBreaks, 0)
+		}
+	}
+	p.opened = p.opened[:i]
+	if blockTags[name] {
+		p.newLine()
+	}
+	// paragraphs are separated by empty lines
+	if name == "p" {
+		p.write("\n")
+	}
+}
+
+func (p *htmlParser) openedPre() *msg.TextEntity {
+	for i := len(p.opened) - 1; i >= 0; i-- {
+		if p.opened[i].name == "pre" {
+			return p.opened[i].entity
+		}
+	}
+	return nil
+}
+
+func attribute(token html.Token, key string) string {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasAttribute(token html.Token, key string) bool {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// messageHTML returns the text of the message in HTML supported by matrix clients
+func messageHTML(message *msg.Message) string {
+	return messenger.FormatText(message.Text, message.Entities, htmlMarkup, escapeHTML)
+}
+
+// htmlMarkup marks formatted parts of texts with tags recommended by the matrix specification
+func htmlMarkup(entity *msg.TextEntity, _ string) (string, string) {
+	switch entity.Type {
+	case "bold":
+		return "<strong>", "</strong>"
+	case "italic":
+		return "<em>", "</em>"
+	case "underline":
+		return "<u>", "</u>"
+	case "strikethrough":
+		return "<del>", "</del>"
+	case "spoiler":
+		return "<span data-mx-spoiler>", "</span>"
+	case "code":
+		return "<code>", "</code>"
+	case "pre":
+		if entity.Language != "" {
+			return `<pre><code class="language-` + html.EscapeString(entity.Language) + `">`, "</code></pre>"
+		}
+		return "<pre><code>", "</code></pre>"
+	case "link":
+		return `<a href="` + html.EscapeString(entity.Url) + `">`, "</a>"
+	case "blockquote":
+		return "<blockquote>", "</blockquote>"
+	}
+	return "", ""
+}
+
+// escapeHTML escapes the text, line breaks are kept as they are only in pre-formatted blocks
+func escapeHTML(text string, code bool) string {
+	text = html.EscapeString(text)
+	if code {
+		return text
+	}
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
@@ -0,0 +1,194 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"sync"
+	"time"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// syncRetryDelay is the delay before syncing again after the sync loop stopped with an error
+const syncRetryDelay = 30 * time.Second
+
+type Messenger struct {
+	*messenger.BaseMessenger
+	client *mautrix.Client
+	// rooms are ids of joined rooms by ids of their chats
+	rooms *messenger.ChatAddresses
+	// roomNames are names of rooms shown to the users, they are requested again when rooms are renamed
+	roomNames     map[id.RoomID]string
+	roomNameMutex sync.Mutex
+	// updates are processed concurrently, the sequencer keeps them in order when they are passed to the controller
+	sequencer *messenger.Sequencer
+}
+
+func NewMessenger(baseMessenger *messenger.BaseMessenger) (*Messenger, error) {
+	client, err := mautrix.NewClient(Config.HomeserverURL, id.UserID(Config.UserID), Config.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("could not create matrix client: %v", err)
+	}
+	if client.UserID == "" {
+		whoami, err := client.Whoami(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("could not get matrix user id: %v", err)
+		}
+		client.UserID = whoami.UserID
+	}
+
+	newMessenger := &Messenger{
+		BaseMessenger: baseMessenger,
+		client:        client,
+		rooms:         messenger.NewChatAddresses(),
+		roomNames:     make(map[id.RoomID]string),
+		sequencer:     messenger.NewSequencer(),
+	}
+	syncer := client.Syncer.(*mautrix.DefaultSyncer)
+	syncer.OnSync(newMessenger.onSync)
+	syncer.OnEventType(event.EventMessage, newMessenger.onMessage)
+	syncer.OnEventType(event.EventSticker, newMessenger.onMessage)
+	syncer.OnEventType(event.EventRedaction, newMessenger.onRedaction)
+	syncer.OnEventType(event.StateMember, newMessenger.onMember)
+	syncer.OnEventType(event.StateRoomName, newMessenger.onRoomName)
+	return newMessenger, nil
+}
+
+// Run syncs with the homeserver until the context is done
+func (m *Messenger) Run(ctx context.Context) {
+	joined, err := m.client.JoinedRooms(ctx)
+	if err != nil {
+		log.Fatalf("could not get joined matrix rooms: %v", err)
+	}
+	for _, roomID := range joined.JoinedRooms {
+		m.rooms.Add(roomID.String())
+	}
+	log.Printf("logged in to matrix as %s, joined rooms: %d", m.client.UserID, len(joined.JoinedRooms))
+
+	for {
+		err = m.client.SyncWithContext(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("matrix sync stopped: %v", err)
+		time.Sleep(syncRetryDelay)
+	}
+}
+
+// onSync skips events of the first sync, which are sent before the bot started, as well as events of rooms
+// the bot has just joined. Invitations are accepted anyway
+func (m *Messenger) onSync(ctx context.Context, response *mautrix.RespSync, since string) bool {
+	if since == "" {
+		for roomID := range response.Rooms.Invite {
+			m.joinRoom(ctx, roomID)
+		}
+	}
+	return m.client.DontProcessOldEvents(ctx, response, since)
+}
+
+func (m *Messenger) onMessage(_ context.Context, evt *event.Event) {
+	if evt.Sender == m.client.UserID {
+		return
+	}
+	chatID := m.rooms.Add(evt.RoomID.String())
+	go m.processUpdate(evt, m.sequencer.Ticket(chatID))
+}
+
+// onRedaction deletes forwarded copies of the redacted message
+func (m *Messenger) onRedaction(_ context.Context, evt *event.Event) {
+	if evt.Sender == m.client.UserID {
+		return
+	}
+	redacts := evt.Redacts
+	if content := evt.Content.AsRedaction(); content.Redacts != "" {
+		redacts = content.Redacts
+	}
+	chatID := m.rooms.Add(evt.RoomID.String())
+	ticket := m.sequencer.Ticket(chatID)
+	go func() {
+		defer ticket.Done()
+		ticket.Wait()
+		log.Printf("redacted message: room id: %s; event id: %s", evt.RoomID, redacts)
+		if err := m.DeletedMessageCallback(m.chat(evt.RoomID), redacts.String()); err != nil {
+			log.Printf("error processing deleted message: %v", err)
+		}
+	}()
+}
+
+// onMember joins rooms the bot is invited to
+func (m *Messenger) onMember(ctx context.Context, evt *event.Event) {
+	if evt.GetStateKey() != m.client.UserID.String() {
+		return
+	}
+	switch evt.Content.AsMember().Membership {
+	case event.MembershipInvite:
+		m.joinRoom(ctx, evt.RoomID)
+	case event.MembershipJoin:
+		m.rooms.Add(evt.RoomID.String())
+	}
+}
+
+func (m *Messenger) onRoomName(_ context.Context, evt *event.Event) {
+	m.roomNameMutex.Lock()
+	defer m.roomNameMutex.Unlock()
+	// the name is requested again, the room may have an alias
+	delete(m.roomNames, evt.RoomID)
+}
+
+func (m *Messenger) joinRoom(ctx context.Context, roomID id.RoomID) {
+	if _, err := m.client.JoinRoomByID(ctx, roomID); err != nil {
+		log.Printf("could not join matrix room %s: %v", roomID, err)
+		return
+	}
+	m.rooms.Add(roomID.String())
+	log.Printf("joined matrix room %s", roomID)
+}
+
+func (m *Messenger) chat(roomID id.RoomID) *msg.Chat {
+	return &msg.Chat{
+		Id:   m.rooms.Add(roomID.String()),
+		Type: "matrix",
+		Name: m.roomName(roomID),
+	}
+}
+
+// roomName returns the name of the room, or its main alias if it has no name
+func (m *Messenger) roomName(roomID id.RoomID) string {
+	m.roomNameMutex.Lock()
+	name, known := m.roomNames[roomID]
+	m.roomNameMutex.Unlock()
+	if known {
+		return name
+	}
+
+	var nameContent event.RoomNameEventContent
+	var aliasContent event.CanonicalAliasEventContent
+	ctx := context.Background()
+	if err := m.client.StateEvent(ctx, roomID, event.StateRoomName, "", &nameContent); err == nil &&
+		nameContent.Name != "" {
+		name = nameContent.Name
+	} else if err = m.client.StateEvent(ctx, roomID, event.StateCanonicalAlias, "", &aliasContent); err == nil &&
+		aliasContent.Alias != "" {
+		name = aliasContent.Alias.String()
+	} else {
+		name = "matrix"
+	}
+	m.roomNameMutex.Lock()
+	m.roomNames[roomID] = name
+	m.roomNameMutex.Unlock()
+	return name
+}
+
+// room returns the id of the room of the chat
+func (m *Messenger) room(chat *msg.Chat) (id.RoomID, error) {
+	roomID := m.rooms.Get(chat.Id)
+	if roomID == "" {
+		return "", fmt.Errorf("unknown matrix room of chat %d", chat.Id)
+	}
+	return id.RoomID(roomID), nil
+}
@@ -0,0 +1,202 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// SendMessage sends the text and every attachment of the message as separate events, the first one is the reply
+func (m *Messenger) SendMessage(ctx context.Context, request *msg.SendMessageRequest) (*msg.SendMessageResponse, error) {
+	roomID, err := m.room(request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &msg.SendMessageResponse{}, status.Error(codes.NotFound, "unknown room")
+	}
+	cleanup, err := m.DownloadAttachments(ctx, request.Message.Attachments)
+	if err != nil {
+		log.Printf("could not download attachments: %v", err)
+		return &msg.SendMessageResponse{}, status.Error(codes.Unknown, "could not download attachments")
+	}
+	defer cleanup()
+
+	message := request.Message
+	var contents []*event.MessageEventContent
+	if text := textContent(message); text != nil {
+		contents = append(contents, text)
+	}
+	if message.Location != nil {
+		contents = append(contents, locationContent(message.Location))
+	}
+	for _, attachment := range message.Attachments {
+		content, err := m.uploadAttachment(ctx, attachment)
+		if err != nil {
+			log.Printf("error uploading file of type %s: %v", attachment.Type, err)
+			continue
+		}
+		contents = append(contents, content)
+	}
+	if len(contents) > 0 && message.ReplyTo != nil {
+		contents[0].RelatesTo = (&event.RelatesTo{}).SetReplyTo(id.EventID(message.ReplyTo.MessageId))
+	}
+
+	response := &msg.SendMessageResponse{}
+	for _, content := range contents {
+		sent, err := m.client.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+		if err != nil {
+			log.Printf("could not send matrix event: %v", err)
+			return messenger.SendFailed(response, "could not send the message")
+		}
+		response.MessageIds = append(response.MessageIds, sent.EventID.String())
+	}
+	return response, nil
+}
+
+func (m *Messenger) EditMessage(ctx context.Context, request *msg.EditMessageRequest) (*empty.Empty, error) {
+	if len(request.MessageIds) == 0 {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "no messages to edit")
+	}
+	roomID, err := m.room(request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.NotFound, "unknown room")
+	}
+	content := textContent(request.Message)
+	if content == nil {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "empty message")
+	}
+	content.SetEdit(id.EventID(request.MessageIds[0]))
+	if _, err = m.client.SendMessageEvent(ctx, roomID, event.EventMessage, content); err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not edit the message")
+	}
+	return &empty.Empty{}, nil
+}
+
+func (m *Messenger) DeleteMessage(ctx context.Context, request *msg.DeleteMessageRequest) (*empty.Empty, error) {
+	roomID, err := m.room(request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.NotFound, "unknown room")
+	}
+	for _, eventID := range request.MessageIds {
+		if _, err = m.client.RedactEvent(ctx, roomID, id.EventID(eventID)); err != nil {
+			log.Print(err)
+			return &empty.Empty{}, status.Error(codes.Unknown, "could not delete the message")
+		}
+	}
+	return &empty.Empty{}, nil
+}
+
+// textContent returns the text of the message with the content matrix clients can not show natively added to it.
+// It is nil if there is no text
+func textContent(message *msg.Message) *event.MessageEventContent {
+	var plain, formatted []string
+	add := func(text, html string) {
+		if strings.TrimSpace(text) != "" {
+			plain = append(plain, text)
+			formatted = append(formatted, html)
+		}
+	}
+	add(messenger.PlainText(message.Text, message.Entities), messageHTML(message))
+	if message.Contact != nil {
+		text := messenger.ContactText(message.Contact)
+		add(text, escapeHTML(text, false))
+	}
+	// polls of matrix are not supported by many clients yet
+	if message.Poll != nil {
+		text := messenger.PollText(message.Poll)
+		add(text, escapeHTML(text, false))
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+	return &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          strings.Join(plain, "\n\n"),
+		Format:        event.FormatHTML,
+		FormattedBody: strings.Join(formatted, "<br><br>"),
+	}
+}
+
+func locationContent(location *msg.Location) *event.MessageEventContent {
+	return &event.MessageEventContent{
+		MsgType: event.MsgLocation,
+		Body:    messenger.LocationText(location),
+		GeoURI:  fmt.Sprintf("geo:%f,%f", location.Latitude, location.Longitude),
+	}
+}
+
+// uploadAttachment uploads the file to the content repository of the homeserver
+// and returns the content of the event showing it
+func (m *Messenger) uploadAttachment(ctx context.Context, attachment *msg.Attachment) (*event.MessageEventContent,
+	error) {
+	file, err := os.Open(attachment.Url)
+	if err != nil {
+		return nil, fmt.Errorf("could not open file %s: %v", attachment.Url, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	name := attachment.Name
+	if name == "" {
+		name = filepath.Base(attachment.Url)
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	uploaded, err := m.client.UploadMedia(ctx, mautrix.ReqUploadMedia{
+		Content:       file,
+		ContentLength: info.Size(),
+		ContentType:   mimeType,
+		FileName:      name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	content := &event.MessageEventContent{
+		MsgType:  getMessageType(attachment.Type, mimeType),
+		Body:     name,
+		FileName: name,
+		URL:      uploaded.ContentURI.CUString(),
+		Info:     &event.FileInfo{MimeType: mimeType, Size: int(info.Size())},
+	}
+	if attachment.Type == "voice" {
+		content.MSC1767Audio = &event.MSC1767Audio{}
+		content.MSC3245Voice = &event.MSC3245Voice{}
+	}
+	return content, nil
+}
+
+// getMessageType returns the type of the event showing the file. Documents are sent as files
+// even if they are images, and other files are shown by their mime types
+func getMessageType(attachmentType, mimeType string) event.MessageType {
+	switch {
+	case attachmentType == "doc":
+		return event.MsgFile
+	case strings.HasPrefix(mimeType, "image/"):
+		return event.MsgImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return event.MsgVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return event.MsgAudio
+	}
+	return event.MsgFile
+}
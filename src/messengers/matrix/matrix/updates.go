@@ -0,0 +1,298 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// processUpdate handles a new or an edited message and marks its ticket as done once it is passed to the controller
+func (m *Messenger) processUpdate(evt *event.Event, ticket *messenger.Ticket) {
+	defer ticket.Done()
+	content := evt.Content.AsMessage()
+	if content.MsgType == event.MsgNotice {
+		// notices are sent by bots, including other bridges
+		return
+	}
+	m.logUpdate(evt, content)
+	chat := m.chat(evt.RoomID)
+	if editedID := content.RelatesTo.GetReplaceID(); editedID != "" {
+		ticket.Wait()
+		if err := m.processEditedMessage(evt, editedID, content, chat); err != nil {
+			log.Printf("error processing edited message: %v", err)
+		}
+		return
+	}
+	if isCommand(content) {
+		ticket.Wait()
+		err := m.processCommand(evt.RoomID, content.Body, chat)
+		if err != errCommandNotFound {
+			if err != nil {
+				log.Printf("error processing command: %v", err)
+			}
+			return
+		}
+	}
+	if err := m.processMessage(evt, content, chat, ticket); err != nil {
+		log.Printf("error processing message: %v", err)
+	}
+}
+
+func (m *Messenger) logUpdate(evt *event.Event, content *event.MessageEventContent) {
+	const template = "new event: room id: %s; event id: %s; type: %s; msgtype: %s; reply: %t; edit: %t"
+	log.Printf(template, evt.RoomID, evt.ID, evt.Type.Type, content.MsgType, content.RelatesTo.GetReplyTo() != "",
+		content.RelatesTo.GetReplaceID() != "")
+}
+
+// processMessage handles a new message. Media is downloaded concurrently with other updates,
+// but the message is passed to the controller only when its ticket is up.
+func (m *Messenger) processMessage(evt *event.Event, content *event.MessageEventContent, chat *msg.Chat,
+	ticket *messenger.Ticket) error {
+	text, entities := getText(evt, content)
+	standardMessage := msg.Message{
+		Id:       evt.ID.String(),
+		Text:     text,
+		Entities: entities,
+		Sender:   m.getSender(evt.RoomID, evt.Sender),
+		ReplyTo:  m.getReply(evt.RoomID, content),
+		Location: getLocation(content),
+	}
+	if file, ok := m.getMatrixFile(evt, content); ok {
+		attachments, err := m.DownloadFiles([]messenger.RemoteFile{file})
+		if err != nil {
+			log.Printf("could not download matrix media: %v", err)
+		} else {
+			standardMessage.Attachments = attachments
+		}
+	}
+	ticket.Wait()
+	return m.MessageCallback(&standardMessage, chat)
+}
+
+func (m *Messenger) processEditedMessage(evt *event.Event, editedID id.EventID, content *event.MessageEventContent,
+	chat *msg.Chat) error {
+	if content.NewContent != nil {
+		content = content.NewContent
+	}
+	text, entities := getText(evt, content)
+	standardMessage := msg.Message{
+		Id:       editedID.String(),
+		Text:     text,
+		Entities: entities,
+		Sender:   m.getSender(evt.RoomID, evt.Sender),
+	}
+	return m.EditedMessageCallback(&standardMessage, chat)
+}
+
+// getText returns the text of the message without the quote of the message it replies to.
+// Bodies of media messages are their file names, unless they have captions
+func getText(evt *event.Event, content *event.MessageEventContent) (string, []*msg.TextEntity) {
+	content.RemoveReplyFallback()
+	switch content.MsgType {
+	case event.MsgText, event.MsgEmote:
+	case event.MsgLocation:
+		return "", nil
+	default:
+		if evt.Type == event.EventSticker || content.FileName == "" || content.FileName == content.Body {
+			return "", nil
+		}
+	}
+	text, entities := content.Body, []*msg.TextEntity(nil)
+	if content.Format == event.FormatHTML && content.FormattedBody != "" {
+		text, entities = parseHTML(content.FormattedBody)
+	}
+	if content.MsgType == event.MsgEmote {
+		// emotes are shown after the name of the sender
+		text = "* " + text
+		for _, entity := range entities {
+			entity.Offset += 2
+		}
+	}
+	return text, entities
+}
+
+func (m *Messenger) getSender(roomID id.RoomID, userID id.UserID) *msg.Sender {
+	return &msg.Sender{
+		Name: m.getUserName(roomID, userID),
+		Chat: &msg.Chat{Name: m.roomName(roomID)},
+	}
+}
+
+// getUserName returns the name the user is shown with in the room
+func (m *Messenger) getUserName(roomID id.RoomID, userID id.UserID) string {
+	var member event.MemberEventContent
+	err := m.client.StateEvent(context.Background(), roomID, event.StateMember, userID.String(), &member)
+	if err == nil && member.Displayname != "" {
+		return member.Displayname
+	}
+	return userID.Localpart()
+}
+
+func (m *Messenger) getReply(roomID id.RoomID, content *event.MessageEventContent) *msg.Reply {
+	replyTo := content.RelatesTo.GetNonFallbackReplyTo()
+	if replyTo == "" {
+		return nil
+	}
+	reply := &msg.Reply{MessageId: replyTo.String()}
+	original, err := m.client.GetEvent(context.Background(), roomID, replyTo)
+	if err != nil {
+		log.Printf("could not get matrix event %s: %v", replyTo, err)
+		return reply
+	}
+	if err = original.Content.ParseRaw(original.Type); err == nil {
+		reply.Text, _ = getText(original, original.Content.AsMessage())
+	}
+	reply.Sender = m.getSender(roomID, original.Sender)
+	return reply
+}
+
+// getLocation parses geo URIs of locations, e.g. geo:51.5008,0.1247;u=35
+func getLocation(content *event.MessageEventContent) *msg.Location {
+	if content.MsgType != event.MsgLocation {
+		return nil
+	}
+	coordinates, _, _ := strings.Cut(strings.TrimPrefix(content.GeoURI, "geo:"), ";")
+	latitude, longitude, found := strings.Cut(coordinates, ",")
+	longitude, _, _ = strings.Cut(longitude, ",")
+	if !found {
+		return nil
+	}
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil {
+		return nil
+	}
+	long, err := strconv.ParseFloat(longitude, 64)
+	if err != nil {
+		return nil
+	}
+	return &msg.Location{Latitude: lat, Longitude: long, Title: content.Body}
+}
+
+// getMatrixFile returns the media of the message, it is downloaded from the content repository of the homeserver
+// with the credentials of the bot. Encrypted media is not supported
+func (m *Messenger) getMatrixFile(evt *event.Event, content *event.MessageEventContent) (messenger.RemoteFile, bool) {
+	if content.URL == "" {
+		return messenger.RemoteFile{}, false
+	}
+	uri, err := content.URL.Parse()
+	if err != nil {
+		log.Printf("invalid matrix content uri %s: %v", content.URL, err)
+		return messenger.RemoteFile{}, false
+	}
+	return messenger.RemoteFile{
+		URL:    m.client.BuildClientURL("v1", "media", "download", uri.Homeserver, uri.FileID),
+		Name:   content.GetFileName(),
+		Type:   getAttachmentType(evt, content),
+		Header: http.Header{"Authorization": {"Bearer " + m.client.AccessToken}},
+	}, true
+}
+
+func getAttachmentType(evt *event.Event, content *event.MessageEventContent) string {
+	mimeType := ""
+	if content.Info != nil {
+		mimeType = content.Info.MimeType
+	}
+	switch {
+	case evt.Type == event.EventSticker:
+		return "sticker"
+	case content.MsgType == event.MsgAudio && content.MSC3245Voice != nil:
+		return "voice"
+	case content.MsgType == event.MsgImage && mimeType == "image/gif":
+		return "animation"
+	case content.MsgType == event.MsgImage:
+		return "photo"
+	case content.MsgType == event.MsgVideo:
+		return "video"
+	case content.MsgType == event.MsgAudio:
+		return "audio"
+	}
+	return "doc"
+}
+
+var errCommandNotFound = fmt.Errorf("command not found")
+
+// isCommand checks if the message is a command. Many matrix clients handle messages starting with "/" themselves,
+// so commands may start with "!" as well
+func isCommand(content *event.MessageEventContent) bool {
+	return content.MsgType == event.MsgText &&
+		(strings.HasPrefix(content.Body, "/") || strings.HasPrefix(content.Body, "!"))
+}
+
+func (m *Messenger) processCommand(roomID id.RoomID, text string, chat *msg.Chat) error {
+	command, args := splitCommand(text)
+	var reply string
+	var err error
+	switch command {
+	case "get_token":
+		reply, err = m.GetChatToken(chat.Id, chat.Type)
+	case "subscribe":
+		reply, err = m.SubscribeCallback(chat, args)
+	case "unsubscribe":
+		err = m.UnsubscribeCallback(chat, args)
+	case "bridge":
+		err = m.BridgeCallback(chat, args)
+	case "filter":
+		reply, err = m.ProcessFilterCommand(chat, args)
+	case "template":
+		reply, err = m.ProcessTemplateCommand(chat, args)
+	case "photos":
+		reply, err = m.ProcessPhotosCommand(chat, args)
+	case "failed":
+		reply, err = m.ProcessFailedCommand(chat)
+	default:
+		return errCommandNotFound
+	}
+	if err == nil && reply != "" {
+		err = m.sendReply(roomID, reply)
+	}
+	return m.processCommandResult(err, roomID)
+}
+
+// splitCommand splits the text of a command into the name of the command without its prefix and its arguments
+func splitCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)[1:]
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return text, ""
+	}
+	return text[:end], strings.TrimSpace(text[end:])
+}
+
+// processCommandResult checks if there is an error that needs to be sent to the user and tries to send it.
+// If the error was internal, it is added to the returned error
+//
+// It might have some messenger-specific logic in the future, so it should not be moved to baseMessenger.
+func (m *Messenger) processCommandResult(err error, roomID id.RoomID) error {
+	// If err is nil or not a grpc error, it should be returned immediately
+	if status.Code(err) == codes.OK {
+		return err
+	}
+	sendErr := m.sendReply(roomID, status.Convert(err).Message())
+	if !messenger.IsUserInputError(err) {
+		if sendErr != nil {
+			return fmt.Errorf("could not process command: %v, could not send error %v", err, sendErr)
+		}
+		return err
+	}
+	return sendErr
+}
+
+// sendReply sends a reply to a command as a notice, which other bots do not respond to
+func (m *Messenger) sendReply(roomID id.RoomID, text string) error {
+	_, err := m.client.SendMessageEvent(context.Background(), roomID, event.EventMessage, &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    text,
+	})
+	return err
+}
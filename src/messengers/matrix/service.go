@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/matrix/matrix"
+	"github.com/Pelmenner/TransferBot/messenger"
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"log"
+	"net"
+)
+
+func main() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", matrix.Config.Port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	matrixMessenger := createMessenger()
+	go matrixMessenger.Run(context.Background())
+	grpcServer := grpc.NewServer()
+	msg.RegisterChatServiceServer(grpcServer, matrixMessenger)
+
+	log.Printf("initializing gRPC server on port %d", matrix.Config.Port)
+	if err = grpcServer.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func createMessenger() *matrix.Messenger {
+	connection, err := grpc.Dial(matrix.Config.ControllerHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("could not connect to controller on %s", matrix.Config.ControllerHost)
+	}
+	matrixMessenger, err := matrix.NewMessenger(messenger.NewBaseMessenger(connection))
+	if err != nil {
+		log.Fatalf("could not create messenger: %v", err)
+	}
+	log.Printf("connected to controller on %s", matrix.Config.ControllerHost)
+	return matrixMessenger
+}
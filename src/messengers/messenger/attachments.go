@@ -0,0 +1,172 @@
+package messenger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Pelmenner/TransferBot/proto/controller"
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// attachmentChunkSize is the size of file parts sent to the controller
+const attachmentChunkSize = 64 * 1024
+
+// NewLocalFilePath returns a path to save a file with given name to before passing it to the controller.
+// Every file gets its own temporary directory, which is removed together with the file by RemoveLocalFile.
+func NewLocalFilePath(name string) (string, error) {
+	dir, err := os.MkdirTemp("", "transferbot-*")
+	if err != nil {
+		return "", err
+	}
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = "file"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// RemoveLocalFile removes a file created at a path returned by NewLocalFilePath
+func RemoveLocalFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("could not remove local file %s: %v", path, err)
+	}
+	// the directory is removed only if it is empty, so a path of some other file is not dangerous
+	_ = os.Remove(filepath.Dir(path))
+}
+
+// uploadAttachments sends local files of the attachments to the controller
+// and replaces their urls with the ones given by the controller. The local files are removed.
+func (bm *BaseMessenger) uploadAttachments(attachments []*msg.Attachment) error {
+	for i, attachment := range attachments {
+		localPath := attachment.Url
+		if attachment.Name == "" {
+			attachment.Name = filepath.Base(localPath)
+		}
+		url, err := bm.uploadFile(localPath, attachment.Name)
+		RemoveLocalFile(localPath)
+		if err != nil {
+			for _, rest := range attachments[i+1:] {
+				RemoveLocalFile(rest.Url)
+			}
+			return fmt.Errorf("could not upload %s: %w", attachment.Name, err)
+		}
+		attachment.Url = url
+	}
+	return nil
+}
+
+func (bm *BaseMessenger) uploadFile(path, name string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	stream, err := bm.UploadAttachment(context.TODO())
+	if err != nil {
+		return "", err
+	}
+	buffer := make([]byte, attachmentChunkSize)
+	for first := true; ; first = false {
+		n, err := file.Read(buffer)
+		if err == io.EOF && !first {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		request := &controller.UploadAttachmentRequest{Data: buffer[:n]}
+		if first {
+			request.Name = name
+		}
+		if err = stream.Send(request); err != nil {
+			// the actual error is returned by CloseAndRecv
+			break
+		}
+	}
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		return "", err
+	}
+	return response.Url, nil
+}
+
+// DownloadFiles downloads files received by the messenger to local files, see Downloader.DownloadFiles
+func (bm *BaseMessenger) DownloadFiles(files []RemoteFile) ([]*msg.Attachment, error) {
+	return bm.downloader.DownloadFiles(files)
+}
+
+// DownloadAttachments saves files of the attachments received from the controller to local files
+// and replaces urls of the attachments with paths to them.
+// The returned function removes the local files, it should be called after the attachments are sent.
+// Downloads are stopped when the context of the request to send them is done
+func (bm *BaseMessenger) DownloadAttachments(ctx context.Context, attachments []*msg.Attachment) (func(), error) {
+	var localPaths []string
+	cleanup := func() {
+		for _, path := range localPaths {
+			RemoveLocalFile(path)
+		}
+	}
+	for _, attachment := range attachments {
+		name := attachment.Name
+		if name == "" {
+			name = filepath.Base(attachment.Url)
+		}
+		localPath, err := NewLocalFilePath(name)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		localPaths = append(localPaths, localPath)
+		if attachment.DownloadUrl != "" {
+			err = bm.downloader.Download(ctx, attachment.DownloadUrl, localPath)
+			if err != nil {
+				log.Printf("could not download %s from the storage, asking the controller: %v", name, err)
+			}
+		}
+		if (attachment.DownloadUrl == "" || err != nil) && ctx.Err() == nil {
+			err = bm.downloadFile(ctx, attachment.Url, localPath)
+		}
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("could not download %s: %w", name, err)
+		}
+		attachment.Url = localPath
+		attachment.Name = name
+	}
+	return cleanup, nil
+}
+
+// downloadFile receives the file from the controller
+func (bm *BaseMessenger) downloadFile(ctx context.Context, url, path string) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	stream, err := bm.DownloadAttachment(ctx, &controller.DownloadAttachmentRequest{Url: url})
+	if err != nil {
+		return err
+	}
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(response.Data); err != nil {
+			return err
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/Pelmenner/TransferBot/proto/controller"
 	msg "github.com/Pelmenner/TransferBot/proto/messenger"
@@ -14,14 +17,23 @@ import (
 type BaseMessenger struct {
 	msg.UnimplementedChatServiceServer
 	controller.ControllerClient
+	downloader *Downloader
 }
 
 func NewBaseMessenger(cc grpc.ClientConnInterface) *BaseMessenger {
 	client := controller.NewControllerClient(cc)
-	return &BaseMessenger{ControllerClient: client}
+	return &BaseMessenger{
+		ControllerClient: client,
+		downloader:       NewDownloader(downloadWorkers, downloadMaxSize, downloadTimeout),
+	}
 }
 
+// MessageCallback passes a new message to the controller.
+// Attachment urls of the message should be paths to local files, which are uploaded to the controller and removed.
 func (bm *BaseMessenger) MessageCallback(message *msg.Message, chat *msg.Chat) error {
+	if err := bm.uploadAttachments(message.Attachments); err != nil {
+		return err
+	}
 	_, err := bm.HandleNewMessage(context.TODO(), &controller.HandleMessageRequest{
 		Message: message,
 		Chat:    chat,
@@ -29,11 +41,39 @@ func (bm *BaseMessenger) MessageCallback(message *msg.Message, chat *msg.Chat) e
 	return err
 }
 
-func (bm *BaseMessenger) SubscribeCallback(subscriber *msg.Chat, subscriptionToken string) error {
-	_, err := bm.Subscribe(context.TODO(), &controller.SubscribeRequest{
+func (bm *BaseMessenger) EditedMessageCallback(message *msg.Message, chat *msg.Chat) error {
+	_, err := bm.HandleEditedMessage(context.TODO(), &controller.HandleMessageRequest{
+		Message: message,
+		Chat:    chat,
+	})
+	return err
+}
+
+func (bm *BaseMessenger) DeletedMessageCallback(chat *msg.Chat, messageID string) error {
+	_, err := bm.HandleDeletedMessage(context.TODO(), &controller.HandleDeletedMessageRequest{
+		Chat:      chat,
+		MessageId: messageID,
+	})
+	return err
+}
+
+// SubscribeCallback subscribes the chat on another one and returns a warning to be shown to the user if any
+func (bm *BaseMessenger) SubscribeCallback(subscriber *msg.Chat, subscriptionToken string) (string, error) {
+	resp, err := bm.Subscribe(context.TODO(), &controller.SubscribeRequest{
 		Chat:  subscriber,
 		Token: subscriptionToken,
 	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Warning, nil
+}
+
+func (bm *BaseMessenger) BridgeCallback(chat *msg.Chat, token string) error {
+	_, err := bm.Bridge(context.TODO(), &controller.BridgeRequest{
+		Chat:  chat,
+		Token: token,
+	})
 	return err
 }
 
@@ -45,6 +85,199 @@ func (bm *BaseMessenger) UnsubscribeCallback(subscriber *msg.Chat, subscriptionT
 	return err
 }
 
+// FilterCommandUsage describes arguments of the filter command
+const FilterCommandUsage = "usage: filter add <token> <rule> | filter remove <token> <rule> | filter list <token>\n" +
+	"rules: #tag, hashtag:<tag>, regex:<expression>, sender:<name>, attachment:<type|any>; " +
+	"prefix a rule with ! to negate it"
+
+// ProcessFilterCommand manages filters of the chat subscription according to the command arguments
+// and returns a reply to be shown to the user
+func (bm *BaseMessenger) ProcessFilterCommand(chat *msg.Chat, args string) (string, error) {
+	parts := splitCommandArguments(args, 3)
+	if len(parts) < 2 {
+		return "", status.Error(codes.InvalidArgument, FilterCommandUsage)
+	}
+	action, token := parts[0], parts[1]
+	rule := ""
+	if len(parts) == 3 {
+		rule = parts[2]
+	}
+
+	switch action {
+	case "add", "remove":
+		if rule == "" {
+			return "", status.Error(codes.InvalidArgument, FilterCommandUsage)
+		}
+		request := &controller.FilterRequest{Chat: chat, Token: token, Rule: rule}
+		if action == "add" {
+			if _, err := bm.AddFilter(context.TODO(), request); err != nil {
+				return "", err
+			}
+			return "filter added", nil
+		}
+		if _, err := bm.RemoveFilter(context.TODO(), request); err != nil {
+			return "", err
+		}
+		return "filter removed", nil
+	case "list":
+		resp, err := bm.ListFilters(context.TODO(), &controller.ListFiltersRequest{Chat: chat, Token: token})
+		if err != nil {
+			return "", err
+		}
+		if len(resp.Rules) == 0 {
+			return "no filters, all messages are forwarded", nil
+		}
+		return strings.Join(resp.Rules, "\n"), nil
+	default:
+		return "", status.Error(codes.InvalidArgument, FilterCommandUsage)
+	}
+}
+
+// TemplateCommandUsage describes arguments of the template command
+const TemplateCommandUsage = "usage: template set <token> <template> | template reset <token> | template show <token>\n" +
+	"templates use Go text/template syntax with .Text, .Sender.Name, .Sender.Chat and .Chat.Name fields " +
+	"and stripLinks, trim, upper and lower functions, e.g. {{.Text | stripLinks}}"
+
+// ProcessTemplateCommand manages the template of the chat subscription according to the command arguments
+// and returns a reply to be shown to the user
+func (bm *BaseMessenger) ProcessTemplateCommand(chat *msg.Chat, args string) (string, error) {
+	parts := splitCommandArguments(args, 3)
+	if len(parts) < 2 {
+		return "", status.Error(codes.InvalidArgument, TemplateCommandUsage)
+	}
+	action, token := parts[0], parts[1]
+
+	switch action {
+	case "set", "reset":
+		request := &controller.SetTemplateRequest{Chat: chat, Token: token}
+		if action == "set" {
+			if len(parts) < 3 {
+				return "", status.Error(codes.InvalidArgument, TemplateCommandUsage)
+			}
+			request.Template = parts[2]
+		}
+		if _, err := bm.SetTemplate(context.TODO(), request); err != nil {
+			return "", err
+		}
+		if action == "set" {
+			return "template set", nil
+		}
+		return "template reset to the default one", nil
+	case "show":
+		resp, err := bm.GetTemplate(context.TODO(), &controller.GetTemplateRequest{Chat: chat, Token: token})
+		if err != nil {
+			return "", err
+		}
+		return resp.Template, nil
+	default:
+		return "", status.Error(codes.InvalidArgument, TemplateCommandUsage)
+	}
+}
+
+// PhotosCommandUsage describes arguments of the photos command
+const PhotosCommandUsage = "usage: photos set <token> <options> | photos reset <token> | photos show <token>\n" +
+	"options: resize=<pixels> limits the width and the height of photos, strip removes metadata " +
+	"such as the location a photo was taken at, watermark=<text> draws the text in the corner and goes last"
+
+// ProcessPhotosCommand manages processing of photos forwarded by the chat subscription according to
+// the command arguments and returns a reply to be shown to the user
+func (bm *BaseMessenger) ProcessPhotosCommand(chat *msg.Chat, args string) (string, error) {
+	parts := splitCommandArguments(args, 3)
+	if len(parts) < 2 {
+		return "", status.Error(codes.InvalidArgument, PhotosCommandUsage)
+	}
+	action, token := parts[0], parts[1]
+
+	switch action {
+	case "set", "reset":
+		request := &controller.SetPhotoOptionsRequest{Chat: chat, Token: token}
+		if action == "set" {
+			if len(parts) < 3 {
+				return "", status.Error(codes.InvalidArgument, PhotosCommandUsage)
+			}
+			request.Options = parts[2]
+		}
+		if _, err := bm.SetPhotoOptions(context.TODO(), request); err != nil {
+			return "", err
+		}
+		if action == "set" {
+			return "photo options set", nil
+		}
+		return "photos are forwarded as they are", nil
+	case "show":
+		resp, err := bm.GetPhotoOptions(context.TODO(), &controller.GetPhotoOptionsRequest{Chat: chat, Token: token})
+		if err != nil {
+			return "", err
+		}
+		if resp.Options == "" {
+			return "photos are forwarded as they are", nil
+		}
+		return resp.Options, nil
+	default:
+		return "", status.Error(codes.InvalidArgument, PhotosCommandUsage)
+	}
+}
+
+const (
+	// failedMessagesShownMaxCnt is the maximum number of undelivered messages listed by the failed command
+	failedMessagesShownMaxCnt = 10
+	// failedMessageExcerptLength is the maximum length of the shown text of an undelivered message
+	failedMessageExcerptLength = 50
+)
+
+// ProcessFailedCommand returns a list of messages which could not be delivered to the chat
+func (bm *BaseMessenger) ProcessFailedCommand(chat *msg.Chat) (string, error) {
+	resp, err := bm.ListDeadLetters(context.TODO(), &controller.DeadLettersRequest{
+		Destination: chat,
+		Limit:       failedMessagesShownMaxCnt,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.DeadLetters) == 0 {
+		return "all messages were delivered to this chat", nil
+	}
+
+	var res strings.Builder
+	res.WriteString("messages which could not be delivered to this chat:")
+	for _, deadLetter := range resp.DeadLetters {
+		excerpt := []rune(strings.Join(strings.Fields(deadLetter.Message.GetText()), " "))
+		if len(excerpt) > failedMessageExcerptLength {
+			excerpt = append(excerpt[:failedMessageExcerptLength], '…')
+		}
+		if len(excerpt) == 0 {
+			excerpt = []rune(fmt.Sprintf("%d attachments", len(deadLetter.Message.GetAttachments())))
+		}
+		source := deadLetter.Source.GetName()
+		if source == "" {
+			source = deadLetter.Source.GetType()
+		}
+		fmt.Fprintf(&res, "\n#%d %s from %s: %s (%d attempts, last error: %s)",
+			deadLetter.Id, time.Unix(deadLetter.FailedAt, 0).UTC().Format("2006-01-02 15:04 UTC"),
+			source, string(excerpt), deadLetter.Attempts, deadLetter.LastError)
+	}
+	return res.String(), nil
+}
+
+// splitCommandArguments splits off at most n-1 leading words of the arguments,
+// the last part is the rest of the arguments as is, so that it can contain spaces and line breaks
+func splitCommandArguments(args string, n int) []string {
+	var res []string
+	rest := strings.TrimSpace(args)
+	for rest != "" && len(res) < n-1 {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		res = append(res, rest[:end])
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+	if rest != "" {
+		res = append(res, rest)
+	}
+	return res
+}
+
 func (bm *BaseMessenger) GetChatToken(id int64, messenger string) (string, error) {
 	resp, err := bm.ControllerClient.GetChatToken(context.TODO(), &controller.GetChatTokenRequest{
 		ChatID:    id,
@@ -59,15 +292,17 @@ func (bm *BaseMessenger) GetChatToken(id int64, messenger string) (string, error
 // IsUserInputError checks if the error was caused by invalid user input and not by internal server issues
 func IsUserInputError(err error) bool {
 	code := status.Code(err)
-	return code == codes.NotFound || code == codes.OutOfRange || code == codes.InvalidArgument
+	return code == codes.NotFound || code == codes.OutOfRange || code == codes.InvalidArgument ||
+		code == codes.PermissionDenied
 }
 
-func (bm *BaseMessenger) SenderToString(sender *msg.Sender) string {
-	if sender == nil || sender.Name == "" {
-		return ""
-	}
-	if sender.Chat == nil {
-		return sender.Name
+// SendFailed returns the result of a message which could not be sent completely. If some parts of the message
+// were sent, their ids are returned with the reason the rest were not, so that the controller does not send
+// the whole message again. Otherwise the error is returned to retry the message later
+func SendFailed(response *msg.SendMessageResponse, reason string) (*msg.SendMessageResponse, error) {
+	if len(response.MessageIds) == 0 {
+		return response, status.Error(codes.Unknown, reason)
 	}
-	return fmt.Sprintf("%s (%s):", sender.Name, sender.Chat.Name)
+	response.PartialError = reason
+	return response, nil
 }
@@ -0,0 +1,44 @@
+package messenger
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// ChatID maps an id of a chat given by a messenger as a string, e.g. an id of a Matrix room, onto an id of msg.Chat.
+// The controller keeps only numeric ids, so such messengers remember the string ids in ChatAddresses
+// to send messages back. Messengers which do not learn all their chats on start pass the string ids
+// as addresses of chats as well, the controller keeps them to send messages to the chats after a restart
+func ChatID(address string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(address))
+	// ids of chats of other messengers are mostly positive as well
+	return int64(hash.Sum64() & math.MaxInt64)
+}
+
+// ChatAddresses remembers string ids of chats by their numeric ids
+type ChatAddresses struct {
+	addresses map[int64]string
+	mutex     sync.RWMutex
+}
+
+func NewChatAddresses() *ChatAddresses {
+	return &ChatAddresses{addresses: make(map[int64]string)}
+}
+
+// Add remembers the address and returns the id of its chat
+func (c *ChatAddresses) Add(address string) int64 {
+	id := ChatID(address)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.addresses[id] = address
+	return id
+}
+
+// Get returns the address of the chat, it is empty if the chat is unknown
+func (c *ChatAddresses) Get(id int64) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.addresses[id]
+}
@@ -0,0 +1,87 @@
+package messenger
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// ContentText describes structured content of the message as text for chats which can not show it natively
+func ContentText(message *msg.Message) string {
+	var parts []string
+	if message.Location != nil {
+		parts = append(parts, LocationText(message.Location))
+	}
+	if message.Contact != nil {
+		parts = append(parts, ContactText(message.Contact))
+	}
+	if message.Poll != nil {
+		parts = append(parts, PollText(message.Poll))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// LocationText describes the location with a link to a map
+func LocationText(location *msg.Location) string {
+	var text strings.Builder
+	for _, line := range []string{location.Title, location.Address} {
+		if line != "" {
+			text.WriteString(line + "\n")
+		}
+	}
+	text.WriteString(MapURL(location))
+	return text.String()
+}
+
+// MapURL returns a link to the location on a map
+func MapURL(location *msg.Location) string {
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.6f&mlon=%.6f#map=16/%.6f/%.6f",
+		location.Latitude, location.Longitude, location.Latitude, location.Longitude)
+}
+
+func ContactText(contact *msg.Contact) string {
+	return fmt.Sprintf("Contact: %s %s", contact.Name, contact.Phone)
+}
+
+func PollText(poll *msg.Poll) string {
+	text := "Poll: " + poll.Question
+	for _, option := range poll.Options {
+		text += "\n- " + option
+	}
+	return text
+}
+
+// SplitText splits the text into parts which are not longer than the maximum length,
+// where the length of a text is the sum of lengths of its characters. Texts are split at line breaks or spaces
+// if there are any in the second half of a part
+func SplitText(text string, maxLength int, length func(rune) int) []string {
+	var parts []string
+	for text != "" {
+		end, total := 0, 0
+		for i, char := range text {
+			total += length(char)
+			if total > maxLength {
+				break
+			}
+			end = i + utf8.RuneLen(char)
+		}
+		if end == len(text) {
+			parts = append(parts, text)
+			break
+		}
+		if end == 0 {
+			// a single character is longer than the maximum length
+			_, end = utf8.DecodeRuneInString(text)
+		}
+		if newLine := strings.LastIndex(text[:end], "\n"); newLine > end/2 {
+			end = newLine + 1
+		} else if space := strings.LastIndex(text[:end], " "); space > end/2 {
+			end = space + 1
+		}
+		parts = append(parts, strings.TrimRight(text[:end], " \n"))
+		text = text[end:]
+	}
+	return parts
+}
@@ -0,0 +1,253 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+const (
+	// downloadWorkers is the number of files a messenger downloads at the same time
+	downloadWorkers = 4
+	// downloadTimeout limits a single attempt to download a file
+	downloadTimeout = 5 * time.Minute
+	// downloadAttempts is the number of attempts to download a file before giving up
+	downloadAttempts = 3
+	// downloadRetryDelay is multiplied by the number of the failed attempt
+	downloadRetryDelay = time.Second
+	// downloadMaxSize matches the largest attachment accepted by the controller
+	downloadMaxSize = 100 * 1024 * 1024
+)
+
+// ErrFileTooLarge is returned when a downloaded file is larger than the maximum size
+var ErrFileTooLarge = errors.New("file is too large")
+
+// RemoteFile is a file to be downloaded and passed to the controller as an attachment
+type RemoteFile struct {
+	URL string
+	// Name is the name of the local file, it is shown to the users
+	Name string
+	// Type is the type of the attachment
+	Type string
+	// Header is added to the requests, e.g. with credentials some messengers require to download files
+	Header http.Header
+}
+
+// Downloader downloads files by links to local files. Files are downloaded by a fixed number of workers
+// shared by all the callers, so that busy chats can not open unlimited connections.
+// Interrupted downloads are resumed, and files larger than the maximum size are rejected
+type Downloader struct {
+	client     *http.Client
+	jobs       chan downloadJob
+	maxSize    int64
+	timeout    time.Duration
+	attempts   int
+	retryDelay time.Duration
+}
+
+type downloadJob struct {
+	ctx    context.Context
+	url    string
+	header http.Header
+	path   string
+	done   chan error
+}
+
+// statusError is returned when the server responds with an unexpected status
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + e.status
+}
+
+func NewDownloader(workers int, maxSize int64, timeout time.Duration) *Downloader {
+	d := &Downloader{
+		client:     &http.Client{},
+		jobs:       make(chan downloadJob),
+		maxSize:    maxSize,
+		timeout:    timeout,
+		attempts:   downloadAttempts,
+		retryDelay: downloadRetryDelay,
+	}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (d *Downloader) work() {
+	for job := range d.jobs {
+		job.done <- d.download(job.ctx, job.url, job.header, job.path)
+	}
+}
+
+// Download saves the file by the link to the path. It waits for a free worker, so it may take a while to start.
+// The download is stopped when the context is done
+func (d *Downloader) Download(ctx context.Context, link, path string) error {
+	return d.downloadWithHeader(ctx, link, nil, path)
+}
+
+func (d *Downloader) downloadWithHeader(ctx context.Context, link string, header http.Header, path string) error {
+	if _, err := url.ParseRequestURI(link); err != nil {
+		return errors.New("invalid link")
+	}
+	job := downloadJob{ctx: ctx, url: link, header: header, path: path, done: make(chan error, 1)}
+	select {
+	case d.jobs <- job:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-job.done
+}
+
+// DownloadFiles downloads the files concurrently and returns attachments with paths to the local files
+// in the same order. Attachments of files which could not be downloaded are nil, the error joins the reasons
+func (d *Downloader) DownloadFiles(files []RemoteFile) ([]*msg.Attachment, error) {
+	attachments := make([]*msg.Attachment, len(files))
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attachments[i], errs[i] = d.downloadFile(file)
+		}()
+	}
+	wg.Wait()
+	return attachments, errors.Join(errs...)
+}
+
+func (d *Downloader) downloadFile(file RemoteFile) (*msg.Attachment, error) {
+	path, err := NewLocalFilePath(file.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not create local file for %s: %w", file.Name, err)
+	}
+	if err = d.downloadWithHeader(context.Background(), file.URL, file.Header, path); err != nil {
+		RemoveLocalFile(path)
+		return nil, fmt.Errorf("could not download %s %s: %w", file.Type, file.Name, err)
+	}
+	return &msg.Attachment{Type: file.Type, Url: path, Name: file.Name}, nil
+}
+
+// download saves the file retrying failed attempts. Every next attempt continues from the already received part,
+// unless the server does not support ranges
+func (d *Downloader) download(ctx context.Context, link string, header http.Header, path string) (err error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	var size int64
+	for attempt := 1; ; attempt++ {
+		size, err = d.downloadPart(ctx, file, link, header, size)
+		if err == nil || attempt == d.attempts || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		log.Printf("download attempt %d failed after %d bytes, retrying: %v", attempt, size, err)
+		select {
+		case <-time.After(d.retryDelay * time.Duration(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// downloadPart writes the file to the local file starting from the offset and returns the size written so far
+func (d *Downloader) downloadPart(ctx context.Context, file *os.File, link string, header http.Header,
+	offset int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return offset, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	if offset > 0 {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, err := d.client.Do(request)
+	if err != nil {
+		// the link is not logged, as it may contain credentials, e.g. the token of the telegram bot
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return offset, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusPartialContent && offset > 0 && rangeStart(response) == offset:
+	case response.StatusCode == http.StatusOK:
+		// the server sends the whole file, so the received part is dropped
+		if offset > 0 {
+			if err = file.Truncate(0); err != nil {
+				return offset, err
+			}
+			if _, err = file.Seek(0, io.SeekStart); err != nil {
+				return offset, err
+			}
+			offset = 0
+		}
+	default:
+		return offset, &statusError{code: response.StatusCode, status: response.Status}
+	}
+	if response.ContentLength > d.maxSize-offset {
+		return offset, ErrFileTooLarge
+	}
+
+	written, err := io.Copy(file, io.LimitReader(response.Body, d.maxSize-offset+1))
+	offset += written
+	if err == nil && offset > d.maxSize {
+		err = ErrFileTooLarge
+	}
+	return offset, err
+}
+
+// rangeStart returns the offset of the part sent by the server or -1 if it is unknown
+func rangeStart(response *http.Response) int64 {
+	contentRange, found := strings.CutPrefix(response.Header.Get("Content-Range"), "bytes ")
+	if !found {
+		return -1
+	}
+	start, _, _ := strings.Cut(contentRange, "-")
+	res, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return res
+}
+
+// isRetryable checks if the download may succeed next time
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrFileTooLarge) {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= http.StatusInternalServerError || statusErr.code == http.StatusRequestTimeout ||
+			statusErr.code == http.StatusTooManyRequests
+	}
+	return true
+}
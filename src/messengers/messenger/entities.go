@@ -0,0 +1,109 @@
+package messenger
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// Markup returns strings to put before and after the formatted part of a text.
+// The content is the unescaped text of the part
+type Markup func(entity *msg.TextEntity, content string) (string, string)
+
+// Escape escapes a part of a text which is not markup. Code tells if the part is inside code
+// or a pre-formatted block, where lightweight markups such as Markdown are not parsed
+type Escape func(text string, code bool) string
+
+// FormatText renders the text with its entities using the markup, the rest of the text is escaped by escape.
+// Entities which overlap without nesting are split, so that the markup is always nested properly
+func FormatText(text string, entities []*msg.TextEntity, markup Markup, escape Escape) string {
+	units := utf16.Encode([]rune(text))
+	decode := func(start, end int) string {
+		return string(utf16.Decode(units[start:end]))
+	}
+	end := func(entity *msg.TextEntity) int {
+		return int(entity.Offset + entity.Length)
+	}
+
+	var sorted []*msg.TextEntity
+	for _, entity := range entities {
+		if entity.Offset >= 0 && entity.Length > 0 && end(entity) <= len(units) {
+			sorted = append(sorted, entity)
+		}
+	}
+	// outer entities go first
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Offset != sorted[j].Offset {
+			return sorted[i].Offset < sorted[j].Offset
+		}
+		return sorted[i].Length > sorted[j].Length
+	})
+
+	var res strings.Builder
+	var opened []*msg.TextEntity
+	next, written := 0, 0
+	for position := 0; position <= len(units); position++ {
+		deepest := -1
+		for i, entity := range opened {
+			if end(entity) == position {
+				deepest = i
+				break
+			}
+		}
+		starts := next < len(sorted) && int(sorted[next].Offset) == position
+		if deepest == -1 && !starts {
+			continue
+		}
+		res.WriteString(escape(decode(written, position), isInCode(opened)))
+		written = position
+
+		if deepest != -1 {
+			// the entities opened after the ending one are closed as well and opened again after it
+			var reopened []*msg.TextEntity
+			for i := len(opened) - 1; i >= deepest; i-- {
+				_, closing := markup(opened[i], decode(int(opened[i].Offset), end(opened[i])))
+				res.WriteString(closing)
+				if end(opened[i]) != position {
+					reopened = append(reopened, opened[i])
+				}
+			}
+			opened = opened[:deepest]
+			for i := len(reopened) - 1; i >= 0; i-- {
+				opening, _ := markup(reopened[i], decode(int(reopened[i].Offset), end(reopened[i])))
+				res.WriteString(opening)
+				opened = append(opened, reopened[i])
+			}
+		}
+		for ; next < len(sorted) && int(sorted[next].Offset) == position; next++ {
+			opening, _ := markup(sorted[next], decode(position, end(sorted[next])))
+			res.WriteString(opening)
+			opened = append(opened, sorted[next])
+		}
+	}
+	res.WriteString(escape(decode(written, len(units)), false))
+	return res.String()
+}
+
+func isInCode(opened []*msg.TextEntity) bool {
+	for _, entity := range opened {
+		if entity.Type == "code" || entity.Type == "pre" {
+			return true
+		}
+	}
+	return false
+}
+
+// PlainText renders the text for chats which have no markup. Targets of links are put after their texts,
+// the rest of the formatting is dropped
+func PlainText(text string, entities []*msg.TextEntity) string {
+	return FormatText(text, entities, func(entity *msg.TextEntity, content string) (string, string) {
+		if entity.Type == "link" && entity.Url != "" && !strings.Contains(content, entity.Url) {
+			return "", " (" + entity.Url + ")"
+		}
+		return "", ""
+	}, func(text string, _ bool) string {
+		return text
+	})
+}
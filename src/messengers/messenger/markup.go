@@ -0,0 +1,266 @@
+package messenger
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// MarkupSyntax describes a lightweight markup messages are written in, such as Markdown of Discord
+type MarkupSyntax struct {
+	// Delimiters are put around formatted parts of texts, longer marks should go before their prefixes
+	Delimiters []Delimiter
+	// Escapes tells if a backslash makes the next punctuation character a plain one
+	Escapes bool
+	// Quotes tells if lines starting with "> " are blockquotes
+	Quotes bool
+	// CodeLanguages tells if the first line of a pre-formatted block is the programming language of the block
+	CodeLanguages bool
+	// Link parses a link at the start of the text and returns its text, its target and the length of the markup.
+	// The length is zero if the text does not start with a link, the target is empty if it is the text itself
+	Link func(text string) (content string, url string, length int)
+	// Replacements are sequences standing for other characters, e.g. &amp; in Slack
+	Replacements map[string]string
+}
+
+// Delimiter is a mark put before and after a formatted part of a text
+type Delimiter struct {
+	Mark string
+	// Type is the type of the entities marked by the delimiter
+	Type string
+	// Intraword tells if the mark works inside words, e.g. "_" in snake_case usually does not
+	Intraword bool
+}
+
+// openedEntity is an entity whose end has not been found yet
+type openedEntity struct {
+	entity    *msg.TextEntity
+	delimiter Delimiter
+	// position is the byte offset of the entity in the parsed text
+	position int
+}
+
+// markupParser keeps the state of ParseMarkup
+type markupParser struct {
+	syntax   MarkupSyntax
+	source   string
+	res      strings.Builder
+	units    int32
+	entities []*msg.TextEntity
+	opened   []openedEntity
+	// quote is the blockquote the current line belongs to, if any
+	quote *msg.TextEntity
+}
+
+// ParseMarkup returns the text without the markup along with the formatting the markup describes.
+// Marks which are not closed are kept in the text
+func ParseMarkup(text string, syntax MarkupSyntax) (string, []*msg.TextEntity) {
+	p := &markupParser{syntax: syntax, source: text}
+	lineStart := true
+	for pos := 0; pos < len(text); {
+		if lineStart && syntax.Quotes {
+			pos = p.parseQuote(pos)
+		}
+		lineStart = false
+		next := p.parseMarkup(pos)
+		if next == pos {
+			char, size := utf8.DecodeRuneInString(text[pos:])
+			if char == '\n' && p.quote != nil {
+				p.quote.Length = p.units - p.quote.Offset
+			}
+			p.write(string(char))
+			next, lineStart = pos+size, char == '\n'
+		}
+		pos = next
+	}
+	if p.quote != nil {
+		p.quote.Length = p.units - p.quote.Offset
+	}
+	p.restoreOpened()
+
+	var entities []*msg.TextEntity
+	for _, entity := range p.entities {
+		if entity.Length > 0 {
+			entities = append(entities, entity)
+		}
+	}
+	return p.res.String(), entities
+}
+
+func (p *markupParser) write(text string) {
+	p.res.WriteString(text)
+	p.units += int32(len(utf16.Encode([]rune(text))))
+}
+
+// add adds an entity covering the text written by write
+func (p *markupParser) add(entityType string, write func(), url, language string) {
+	entity := &msg.TextEntity{Type: entityType, Offset: p.units, Url: url, Language: language}
+	write()
+	entity.Length = p.units - entity.Offset
+	p.entities = append(p.entities, entity)
+}
+
+// parseQuote skips the mark of a blockquote at the start of a line and returns the position after it.
+// Lines of the same quote make a single entity
+func (p *markupParser) parseQuote(pos int) int {
+	rest := p.source[pos:]
+	quoted := strings.HasPrefix(rest, "> ") || rest == ">" || strings.HasPrefix(rest, ">\n")
+	if !quoted {
+		p.quote = nil
+		return pos
+	}
+	if p.quote == nil {
+		p.quote = &msg.TextEntity{Type: "blockquote", Offset: p.units}
+		p.entities = append(p.entities, p.quote)
+	}
+	if strings.HasPrefix(rest, "> ") {
+		return pos + 2
+	}
+	return pos + 1
+}
+
+// parseMarkup parses the markup at the position and returns the position after it,
+// the same position is returned if there is no markup there
+func (p *markupParser) parseMarkup(pos int) int {
+	rest := p.source[pos:]
+	if strings.HasPrefix(rest, "```") {
+		if end := strings.Index(rest[3:], "```"); end >= 0 {
+			p.parsePre(rest[3 : 3+end])
+			return pos + 3 + end + 3
+		}
+	}
+	if strings.HasPrefix(rest, "`") {
+		if end := strings.Index(rest[1:], "`"); end > 0 {
+			p.add("code", func() { p.write(rest[1 : 1+end]) }, "", "")
+			return pos + 1 + end + 1
+		}
+	}
+	if p.syntax.Escapes && len(rest) > 1 && rest[0] == '\\' && strings.IndexByte(asciiPunctuation, rest[1]) >= 0 {
+		p.write(rest[1:2])
+		return pos + 2
+	}
+	for sequence, replacement := range p.syntax.Replacements {
+		if strings.HasPrefix(rest, sequence) {
+			p.write(replacement)
+			return pos + len(sequence)
+		}
+	}
+	if p.syntax.Link != nil {
+		if content, url, length := p.syntax.Link(rest); length > 0 {
+			if url == "" || url == content {
+				p.write(content)
+			} else {
+				p.add("link", func() { p.write(content) }, url, "")
+			}
+			return pos + length
+		}
+	}
+	for _, delimiter := range p.syntax.Delimiters {
+		if strings.HasPrefix(rest, delimiter.Mark) {
+			if p.close(pos, delimiter) || p.open(pos, delimiter) {
+				return pos + len(delimiter.Mark)
+			}
+		}
+	}
+	return pos
+}
+
+// parsePre adds a pre-formatted block, the text of the block is not parsed
+func (p *markupParser) parsePre(block string) {
+	language := ""
+	if firstLine, rest, found := strings.Cut(block, "\n"); found {
+		if p.syntax.CodeLanguages && firstLine != "" && !strings.ContainsFunc(firstLine, unicode.IsSpace) &&
+			strings.TrimSpace(rest) != "" {
+			language, block = firstLine, rest
+		} else if strings.TrimSpace(firstLine) == "" {
+			block = rest
+		}
+	}
+	block = strings.TrimSuffix(block, "\n")
+	p.add("pre", func() { p.write(block) }, "", language)
+}
+
+// open starts an entity if the mark at the position can open it
+func (p *markupParser) open(pos int, delimiter Delimiter) bool {
+	after := p.source[pos+len(delimiter.Mark):]
+	next, _ := utf8.DecodeRuneInString(after)
+	if after == "" || unicode.IsSpace(next) || !strings.Contains(after, delimiter.Mark) {
+		return false
+	}
+	if !delimiter.Intraword && isWordCharBefore(p.source, pos) {
+		return false
+	}
+	entity := &msg.TextEntity{Type: delimiter.Type, Offset: p.units}
+	p.entities = append(p.entities, entity)
+	p.opened = append(p.opened, openedEntity{entity: entity, delimiter: delimiter, position: p.res.Len()})
+	return true
+}
+
+// close ends the latest entity opened by the same mark if the mark at the position can close it
+func (p *markupParser) close(pos int, delimiter Delimiter) bool {
+	i := len(p.opened) - 1
+	for i >= 0 && p.opened[i].delimiter.Mark != delimiter.Mark {
+		i--
+	}
+	if i < 0 {
+		return false
+	}
+	previous, _ := utf8.DecodeLastRuneInString(p.source[:pos])
+	if unicode.IsSpace(previous) || p.opened[i].entity.Offset == p.units {
+		return false
+	}
+	next, _ := utf8.DecodeRuneInString(p.source[pos+len(delimiter.Mark):])
+	if !delimiter.Intraword && isWordChar(next) {
+		return false
+	}
+	p.opened[i].entity.Length = p.units - p.opened[i].entity.Offset
+	p.opened = slices.Delete(p.opened, i, i+1)
+	return true
+}
+
+// restoreOpened puts the marks of entities which were not closed back to the text
+func (p *markupParser) restoreOpened() {
+	if len(p.opened) == 0 {
+		return
+	}
+	res := p.res.String()
+	for i := len(p.opened) - 1; i >= 0; i-- {
+		opened := p.opened[i]
+		res = res[:opened.position] + opened.delimiter.Mark + res[opened.position:]
+		shift := int32(len(utf16.Encode([]rune(opened.delimiter.Mark))))
+		offset := opened.entity.Offset
+		// entities added later start after the mark, the earlier ones may contain it
+		added := slices.Index(p.entities, opened.entity)
+		for j, entity := range p.entities {
+			if j > added && entity.Offset >= offset {
+				entity.Offset += shift
+			} else if j < added && entity.Offset <= offset && entity.Offset+entity.Length > offset {
+				entity.Length += shift
+			}
+		}
+		opened.entity.Length = 0
+	}
+	p.res.Reset()
+	p.res.WriteString(res)
+	p.opened = nil
+}
+
+// asciiPunctuation are characters which can be escaped by a backslash
+const asciiPunctuation = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+// isWordCharBefore checks if the character before the position is a letter or a digit
+func isWordCharBefore(text string, pos int) bool {
+	if pos <= 0 || pos > len(text) {
+		return false
+	}
+	previous, _ := utf8.DecodeLastRuneInString(text[:pos])
+	return isWordChar(previous)
+}
+
+func isWordChar(char rune) bool {
+	return unicode.IsLetter(char) || unicode.IsDigit(char)
+}
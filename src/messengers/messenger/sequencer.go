@@ -0,0 +1,63 @@
+package messenger
+
+import "sync"
+
+// Sequencer keeps the order of updates of a chat which are processed concurrently.
+// A ticket is taken for every update in the order they are received,
+// and the update is passed to the controller only after all the earlier updates of the chat are done.
+type Sequencer struct {
+	mutex sync.Mutex
+	last  map[int64]*Ticket
+}
+
+func NewSequencer() *Sequencer {
+	return &Sequencer{last: make(map[int64]*Ticket)}
+}
+
+// Ticket is a place of an update in the queue of the chat updates
+type Ticket struct {
+	previous <-chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	release  func()
+}
+
+// Ticket returns a new ticket which goes after all tickets of the chat taken earlier
+func (s *Sequencer) Ticket(chatID int64) *Ticket {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ticket := &Ticket{done: make(chan struct{})}
+	if last, exists := s.last[chatID]; exists {
+		ticket.previous = last.done
+	}
+	s.last[chatID] = ticket
+	ticket.release = func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if s.last[chatID] == ticket {
+			delete(s.last, chatID)
+		}
+	}
+	return ticket
+}
+
+// Wait blocks until all the earlier tickets of the chat are done
+func (t *Ticket) Wait() {
+	if t.previous != nil {
+		<-t.previous
+	}
+}
+
+// Done lets the later tickets of the chat go. It does not block,
+// but the later tickets are not let go until the earlier ones are done as well.
+// It is safe to call Done more than once.
+func (t *Ticket) Done() {
+	t.once.Do(func() {
+		go func() {
+			t.Wait()
+			close(t.done)
+			t.release()
+		}()
+	})
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/Pelmenner/TransferBot/slack/slack"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"log"
+	"net"
+)
+
+func main() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", slack.Config.Port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	slackMessenger := createMessenger()
+	go slackMessenger.Run(context.Background())
+	grpcServer := grpc.NewServer()
+	msg.RegisterChatServiceServer(grpcServer, slackMessenger)
+
+	log.Printf("initializing gRPC server on port %d", slack.Config.Port)
+	if err = grpcServer.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func createMessenger() *slack.Messenger {
+	connection, err := grpc.Dial(slack.Config.ControllerHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("could not connect to controller on %s", slack.Config.ControllerHost)
+	}
+	slackMessenger, err := slack.NewMessenger(messenger.NewBaseMessenger(connection))
+	if err != nil {
+		log.Fatalf("could not create messenger: %v", err)
+	}
+	log.Printf("connected to controller on %s", slack.Config.ControllerHost)
+	return slackMessenger
+}
@@ -0,0 +1,38 @@
+package slack
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+var Config = struct {
+	// BotToken is the token the bot calls the Web API with, it starts with xoxb-
+	BotToken string
+	// AppToken is the app-level token used to connect in Socket Mode, it starts with xapp-
+	AppToken       string
+	Port           int
+	ControllerHost string
+}{
+	BotToken:       os.Getenv("SLACK_BOT_TOKEN"),
+	AppToken:       os.Getenv("SLACK_APP_TOKEN"),
+	ControllerHost: os.Getenv("CONTROLLER_HOST"),
+}
+
+func init() {
+	if len(Config.BotToken) == 0 {
+		log.Panic("Slack bot token not provided")
+	}
+	if len(Config.AppToken) == 0 {
+		log.Panic("Slack app token not provided")
+	}
+}
+
+func init() {
+	port := os.Getenv("PORT")
+	var err error
+	Config.Port, err = strconv.Atoi(port)
+	if err != nil {
+		log.Panic("Invalid Slack service port")
+	}
+}
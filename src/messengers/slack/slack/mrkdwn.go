@@ -0,0 +1,110 @@
+package slack
+
+import (
+	"github.com/Pelmenner/TransferBot/messenger"
+	"html"
+	"regexp"
+	"strings"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// mrkdwnDelimiters are marks of mrkdwn, the markup slack formats messages with
+var mrkdwnDelimiters = []messenger.Delimiter{
+	{Mark: "*", Type: "bold"},
+	{Mark: "_", Type: "italic"},
+	{Mark: "~", Type: "strikethrough"},
+}
+
+// mrkdwnReplacements are the only characters slack escapes in texts
+var mrkdwnReplacements = map[string]string{"&amp;": "&", "&lt;": "<", "&gt;": ">"}
+
+// quotePattern matches marks of blockquotes, which are escaped in texts of messages
+var quotePattern = regexp.MustCompile(`(?m)^&gt;`)
+
+// parseMrkdwn returns the text of a message without mrkdwn along with its formatting.
+// Mentions of users and channels are replaced with their names
+func (m *Messenger) parseMrkdwn(text string) (string, []*msg.TextEntity) {
+	return messenger.ParseMarkup(quotePattern.ReplaceAllString(text, ">"), messenger.MarkupSyntax{
+		Delimiters:   mrkdwnDelimiters,
+		Quotes:       true,
+		Link:         m.parseLink,
+		Replacements: mrkdwnReplacements,
+	})
+}
+
+// parseLink parses the special sequences in angle brackets: links such as <https://example.com|text>,
+// mentions such as <@U0123> and <#C0123|general>, and special mentions such as <!here>
+func (m *Messenger) parseLink(text string) (string, string, int) {
+	if !strings.HasPrefix(text, "<") {
+		return "", "", 0
+	}
+	end := strings.IndexAny(text, ">\n")
+	if end < 0 || text[end] != '>' {
+		return "", "", 0
+	}
+	target, label, _ := strings.Cut(text[1:end], "|")
+	label = html.UnescapeString(label)
+	length := end + 1
+	switch {
+	case strings.HasPrefix(target, "@"):
+		if label == "" {
+			label = m.userName(target[1:])
+		}
+		return "@" + strings.TrimPrefix(label, "@"), "", length
+	case strings.HasPrefix(target, "#"):
+		if label == "" {
+			return m.channelName(target[1:]), "", length
+		}
+		return "#" + label, "", length
+	case strings.HasPrefix(target, "!"):
+		// user groups have labels, special mentions such as here and channel have not
+		if label == "" {
+			label = "@" + strings.TrimPrefix(target, "!")
+		}
+		return label, "", length
+	}
+	target = html.UnescapeString(target)
+	if label == "" {
+		return strings.TrimPrefix(target, "mailto:"), "", length
+	}
+	return label, target, length
+}
+
+// messageText returns the text of the message in mrkdwn, it is already formatted by the controller
+func messageText(message *msg.Message) string {
+	return messenger.FormatText(message.Text, message.Entities, mrkdwnMarkup, escapeMrkdwn)
+}
+
+// linkEscaper escapes characters which end targets of links
+var linkEscaper = strings.NewReplacer("|", "%7C", ">", "%3E")
+
+// mrkdwnMarkup marks formatted parts of texts with mrkdwn. Slack has no underline and spoilers,
+// these parts are left as they are
+func mrkdwnMarkup(entity *msg.TextEntity, _ string) (string, string) {
+	switch entity.Type {
+	case "bold":
+		return "*", "*"
+	case "italic":
+		return "_", "_"
+	case "strikethrough":
+		return "~", "~"
+	case "code":
+		return "`", "`"
+	case "pre":
+		return "```\n", "\n```"
+	case "link":
+		return "<" + linkEscaper.Replace(entity.Url) + "|", ">"
+	case "blockquote":
+		return "> ", ""
+	}
+	return "", ""
+}
+
+// mrkdwnEscaper escapes characters which slack requires to be escaped. Other marks can not be escaped in mrkdwn
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeMrkdwn escapes the text, code is escaped as well since slack unescapes it
+func escapeMrkdwn(text string, _ bool) string {
+	return mrkdwnEscaper.Replace(text)
+}
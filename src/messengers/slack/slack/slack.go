@@ -0,0 +1,240 @@
+package slack
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"sync"
+	"time"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	slackapi "github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// reconnectDelay is the delay before connecting again after the connection stopped with an error
+const reconnectDelay = 30 * time.Second
+
+type Messenger struct {
+	*messenger.BaseMessenger
+	api    *slackapi.Client
+	socket *socketmode.Client
+	// userID and botID identify messages of the bot itself
+	userID string
+	botID  string
+	// channels are ids of conversations by ids of their chats
+	channels *messenger.ChatAddresses
+	// names of channels and users by their ids
+	names     map[string]string
+	nameMutex sync.Mutex
+	// updates are processed concurrently, the sequencer keeps them in order when they are passed to the controller
+	sequencer *messenger.Sequencer
+}
+
+func NewMessenger(baseMessenger *messenger.BaseMessenger) (*Messenger, error) {
+	api := slackapi.New(Config.BotToken, slackapi.OptionAppLevelToken(Config.AppToken))
+	auth, err := api.AuthTest()
+	if err != nil {
+		return nil, fmt.Errorf("could not authenticate in slack: %v", err)
+	}
+	return &Messenger{
+		BaseMessenger: baseMessenger,
+		api:           api,
+		socket:        socketmode.New(api),
+		userID:        auth.UserID,
+		botID:         auth.BotID,
+		channels:      messenger.NewChatAddresses(),
+		names:         make(map[string]string),
+		sequencer:     messenger.NewSequencer(),
+	}, nil
+}
+
+// Run receives events in Socket Mode until the context is done
+func (m *Messenger) Run(ctx context.Context) {
+	if err := m.loadChannels(); err != nil {
+		log.Fatalf("could not get slack conversations: %v", err)
+	}
+	go m.handleEvents(ctx)
+	for {
+		err := m.socket.RunContext(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("slack connection stopped: %v", err)
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// loadChannels remembers conversations the bot is a member of, so that messages can be sent there after restarts
+func (m *Messenger) loadChannels() error {
+	params := &slackapi.GetConversationsForUserParameters{
+		Types: []string{"public_channel", "private_channel", "mpim", "im"},
+		Limit: 200,
+	}
+	count := 0
+	for {
+		channels, cursor, err := m.api.GetConversationsForUser(params)
+		if err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			m.channels.Add(channel.ID)
+		}
+		count += len(channels)
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+	log.Printf("connected to slack as %s, conversations: %d", m.userID, count)
+	return nil
+}
+
+// handleEvents takes events in the order they come, the sequencer lets them be processed concurrently after that
+func (m *Messenger) handleEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt := <-m.socket.Events:
+			switch evt.Type {
+			case socketmode.EventTypeConnected:
+				log.Print("connected to slack in socket mode")
+			case socketmode.EventTypeEventsAPI:
+				m.socket.Ack(*evt.Request)
+				if eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent); ok {
+					m.onEventsAPIEvent(eventsAPIEvent)
+				}
+			case socketmode.EventTypeSlashCommand:
+				m.socket.Ack(*evt.Request)
+				if command, ok := evt.Data.(slackapi.SlashCommand); ok {
+					m.onSlashCommand(command)
+				}
+			}
+		}
+	}
+}
+
+func (m *Messenger) onEventsAPIEvent(eventsAPIEvent slackevents.EventsAPIEvent) {
+	switch event := eventsAPIEvent.InnerEvent.Data.(type) {
+	case *slackevents.MessageEvent:
+		m.onMessage(event)
+	case *slackevents.MemberJoinedChannelEvent:
+		if event.User == m.userID {
+			m.channels.Add(event.Channel)
+			log.Printf("joined slack channel %s", event.Channel)
+		}
+	}
+}
+
+func (m *Messenger) onMessage(event *slackevents.MessageEvent) {
+	if m.isOwnMessage(event) {
+		return
+	}
+	chatID := m.channels.Add(event.Channel)
+	ticket := m.sequencer.Ticket(chatID)
+	switch event.SubType {
+	case "", "file_share", "thread_broadcast":
+		go m.processMessage(event, ticket)
+	case "message_changed":
+		go m.processEditedMessage(event, ticket)
+	case "message_deleted":
+		go m.processDeletedMessage(event, ticket)
+	default:
+		// joins, topic changes and other service messages are not forwarded
+		ticket.Done()
+	}
+}
+
+func (m *Messenger) onSlashCommand(command slackapi.SlashCommand) {
+	chatID := m.channels.Add(command.ChannelID)
+	ticket := m.sequencer.Ticket(chatID)
+	go func() {
+		defer ticket.Done()
+		ticket.Wait()
+		if err := m.processCommand(command); err != nil {
+			log.Printf("error processing command: %v", err)
+		}
+	}()
+}
+
+// isOwnMessage checks if the message was sent, edited or deleted by the bot itself, e.g. it is a forwarded copy
+func (m *Messenger) isOwnMessage(event *slackevents.MessageEvent) bool {
+	for _, message := range []*slackapi.Msg{event.Message, event.PreviousMessage} {
+		if message != nil && m.isOwn(message.User, message.BotID) {
+			return true
+		}
+	}
+	return m.isOwn(event.User, event.BotID)
+}
+
+func (m *Messenger) isOwn(userID, botID string) bool {
+	return (userID != "" && userID == m.userID) || (botID != "" && botID == m.botID)
+}
+
+func (m *Messenger) chat(channelID string) *msg.Chat {
+	return &msg.Chat{
+		Id:   m.channels.Add(channelID),
+		Type: "slack",
+		Name: m.channelName(channelID),
+	}
+}
+
+// channelName returns the name of the channel, direct messages have no names
+func (m *Messenger) channelName(channelID string) string {
+	return m.name(channelID, func() (string, error) {
+		channel, err := m.api.GetConversationInfo(&slackapi.GetConversationInfoInput{ChannelID: channelID})
+		if err != nil || channel.Name == "" {
+			return "slack", err
+		}
+		return "#" + channel.Name, nil
+	})
+}
+
+// userName returns the name the user is shown with
+func (m *Messenger) userName(userID string) string {
+	return m.name(userID, func() (string, error) {
+		user, err := m.api.GetUserInfo(userID)
+		if err != nil {
+			return userID, err
+		}
+		if user.Profile.DisplayName != "" {
+			return user.Profile.DisplayName, nil
+		}
+		if user.RealName != "" {
+			return user.RealName, nil
+		}
+		return user.Name, nil
+	})
+}
+
+// name returns the cached name of a channel or a user, it is requested if it is not known yet.
+// Names which could not be requested are not cached
+func (m *Messenger) name(id string, request func() (string, error)) string {
+	m.nameMutex.Lock()
+	name, known := m.names[id]
+	m.nameMutex.Unlock()
+	if known {
+		return name
+	}
+	name, err := request()
+	if err != nil {
+		log.Printf("could not get name of slack object %s: %v", id, err)
+		return name
+	}
+	m.nameMutex.Lock()
+	m.names[id] = name
+	m.nameMutex.Unlock()
+	return name
+}
+
+// channel returns the id of the conversation of the chat
+func (m *Messenger) channel(chat *msg.Chat) (string, error) {
+	channelID := m.channels.Get(chat.Id)
+	if channelID == "" {
+		return "", fmt.Errorf("unknown slack conversation of chat %d", chat.Id)
+	}
+	return channelID, nil
+}
@@ -0,0 +1,164 @@
+package slack
+
+import (
+	"context"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/golang/protobuf/ptypes/empty"
+	slackapi "github.com/slack-go/slack"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// messageMaxLength is the length of texts slack recommends, longer messages are split
+const messageMaxLength = 4000
+
+// filePrefix marks ids of uploaded files, they are deleted differently from messages
+const filePrefix = "file:"
+
+// SendMessage posts the text of the message and uploads its files. Replies are posted to threads
+// of the messages they reply to
+func (m *Messenger) SendMessage(ctx context.Context, request *msg.SendMessageRequest) (*msg.SendMessageResponse, error) {
+	channelID, err := m.channel(request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &msg.SendMessageResponse{}, status.Error(codes.NotFound, "unknown conversation")
+	}
+	cleanup, err := m.DownloadAttachments(ctx, request.Message.Attachments)
+	if err != nil {
+		log.Printf("could not download attachments: %v", err)
+		return &msg.SendMessageResponse{}, status.Error(codes.Unknown, "could not download attachments")
+	}
+	defer cleanup()
+
+	message := request.Message
+	threadTS := ""
+	if message.ReplyTo != nil && !strings.HasPrefix(message.ReplyTo.MessageId, filePrefix) {
+		threadTS = message.ReplyTo.MessageId
+	}
+	response := &msg.SendMessageResponse{}
+	for _, text := range messenger.SplitText(prepareText(message), messageMaxLength, countCharacter) {
+		ts, err := m.post(ctx, channelID, text, threadTS)
+		if err != nil {
+			log.Printf("could not post slack message: %v", err)
+			return messenger.SendFailed(response, "could not send the message")
+		}
+		response.MessageIds = append(response.MessageIds, ts)
+	}
+	for _, attachment := range message.Attachments {
+		fileID, err := m.upload(ctx, channelID, attachment, threadTS)
+		if err != nil {
+			log.Printf("error uploading file of type %s: %v", attachment.Type, err)
+			continue
+		}
+		response.MessageIds = append(response.MessageIds, filePrefix+fileID)
+	}
+	return response, nil
+}
+
+func (m *Messenger) EditMessage(ctx context.Context, request *msg.EditMessageRequest) (*empty.Empty, error) {
+	channelID, err := m.channel(request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.NotFound, "unknown conversation")
+	}
+	// files can not be edited, the rest of the parts of a long message are left as they are
+	ts := ""
+	for _, id := range request.MessageIds {
+		if !strings.HasPrefix(id, filePrefix) {
+			ts = id
+			break
+		}
+	}
+	if ts == "" {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "no messages to edit")
+	}
+	text := ""
+	if parts := messenger.SplitText(prepareText(request.Message), messageMaxLength, countCharacter); len(parts) > 0 {
+		text = parts[0]
+	}
+	if _, _, _, err = m.api.UpdateMessageContext(ctx, channelID, ts, slackapi.MsgOptionText(text, false)); err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not edit the message")
+	}
+	return &empty.Empty{}, nil
+}
+
+func (m *Messenger) DeleteMessage(ctx context.Context, request *msg.DeleteMessageRequest) (*empty.Empty, error) {
+	channelID, err := m.channel(request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.NotFound, "unknown conversation")
+	}
+	for _, id := range request.MessageIds {
+		if fileID, isFile := strings.CutPrefix(id, filePrefix); isFile {
+			err = m.api.DeleteFileContext(ctx, fileID)
+		} else {
+			_, _, err = m.api.DeleteMessageContext(ctx, channelID, id)
+		}
+		if err != nil {
+			log.Print(err)
+			return &empty.Empty{}, status.Error(codes.Unknown, "could not delete the message")
+		}
+	}
+	return &empty.Empty{}, nil
+}
+
+// prepareText returns the message text in mrkdwn with the content slack can not show natively added to it
+func prepareText(message *msg.Message) string {
+	var text []string
+	for _, part := range []string{messageText(message), escapeMrkdwn(messenger.ContentText(message), false)} {
+		if strings.TrimSpace(part) != "" {
+			text = append(text, part)
+		}
+	}
+	return strings.Join(text, "\n\n")
+}
+
+func countCharacter(rune) int {
+	return 1
+}
+
+// post posts the text to the thread, or to the conversation itself if the thread can not be found
+func (m *Messenger) post(ctx context.Context, channelID, text, threadTS string) (string, error) {
+	options := []slackapi.MsgOption{slackapi.MsgOptionText(text, false)}
+	if threadTS != "" {
+		_, ts, err := m.api.PostMessageContext(ctx, channelID, append(options, slackapi.MsgOptionTS(threadTS))...)
+		if err == nil {
+			return ts, nil
+		}
+		log.Printf("could not post slack message to thread %s, posting it to the conversation: %v", threadTS, err)
+	}
+	_, ts, err := m.api.PostMessageContext(ctx, channelID, options...)
+	return ts, err
+}
+
+// upload uploads the local file of the attachment and shares it in the conversation
+func (m *Messenger) upload(ctx context.Context, channelID string, attachment *msg.Attachment,
+	threadTS string) (string, error) {
+	info, err := os.Stat(attachment.Url)
+	if err != nil {
+		return "", err
+	}
+	name := attachment.Name
+	if name == "" {
+		name = filepath.Base(attachment.Url)
+	}
+	file, err := m.api.UploadFileV2Context(ctx, slackapi.UploadFileV2Parameters{
+		File:            attachment.Url,
+		FileSize:        int(info.Size()),
+		Filename:        name,
+		Title:           name,
+		Channel:         channelID,
+		ThreadTimestamp: threadTS,
+	})
+	if err != nil {
+		return "", err
+	}
+	return file.ID, nil
+}
@@ -0,0 +1,203 @@
+package slack
+
+import (
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"strings"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	slackapi "github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// processMessage handles a new message. Files are downloaded concurrently with other updates,
+// but the message is passed to the controller only when its ticket is up.
+func (m *Messenger) processMessage(event *slackevents.MessageEvent, ticket *messenger.Ticket) {
+	defer ticket.Done()
+	message := event.Message
+	const template = "new message: channel id: %s; ts: %s; files: %d; thread: %t"
+	log.Printf(template, event.Channel, message.Timestamp, len(message.Files), isThreadReply(message))
+
+	text, entities := m.parseMrkdwn(message.Text)
+	standardMessage := msg.Message{
+		Id:       message.Timestamp,
+		Text:     text,
+		Entities: entities,
+		Sender:   m.getSender(event.Channel, message.User),
+		ReplyTo:  m.getReply(event.Channel, message),
+	}
+	attachments, err := m.DownloadFiles(getSlackFiles(message))
+	if err != nil {
+		log.Printf("could not download slack files: %v", err)
+	}
+	for _, attachment := range attachments {
+		if attachment != nil {
+			standardMessage.Attachments = append(standardMessage.Attachments, attachment)
+		}
+	}
+	ticket.Wait()
+	if err = m.MessageCallback(&standardMessage, m.chat(event.Channel)); err != nil {
+		log.Printf("error processing message: %v", err)
+	}
+}
+
+func (m *Messenger) processEditedMessage(event *slackevents.MessageEvent, ticket *messenger.Ticket) {
+	defer ticket.Done()
+	message := event.Message
+	// slack changes messages when it adds previews of links, their texts stay the same
+	if event.PreviousMessage != nil && event.PreviousMessage.Text == message.Text {
+		return
+	}
+	log.Printf("edited message: channel id: %s; ts: %s", event.Channel, message.Timestamp)
+	text, entities := m.parseMrkdwn(message.Text)
+	standardMessage := msg.Message{
+		Id:       message.Timestamp,
+		Text:     text,
+		Entities: entities,
+		Sender:   m.getSender(event.Channel, message.User),
+	}
+	ticket.Wait()
+	if err := m.EditedMessageCallback(&standardMessage, m.chat(event.Channel)); err != nil {
+		log.Printf("error processing edited message: %v", err)
+	}
+}
+
+func (m *Messenger) processDeletedMessage(event *slackevents.MessageEvent, ticket *messenger.Ticket) {
+	defer ticket.Done()
+	ticket.Wait()
+	log.Printf("deleted message: channel id: %s; ts: %s", event.Channel, event.DeletedTimeStamp)
+	if err := m.DeletedMessageCallback(m.chat(event.Channel), event.DeletedTimeStamp); err != nil {
+		log.Printf("error processing deleted message: %v", err)
+	}
+}
+
+func (m *Messenger) getSender(channelID, userID string) *msg.Sender {
+	return &msg.Sender{
+		Name: m.userName(userID),
+		Chat: &msg.Chat{Name: m.channelName(channelID)},
+	}
+}
+
+// isThreadReply checks if the message is posted to a thread, the first message of a thread is not a reply
+func isThreadReply(message *slackapi.Msg) bool {
+	return message.ThreadTimestamp != "" && message.ThreadTimestamp != message.Timestamp
+}
+
+// getReply returns the first message of the thread the message is posted to, slack has no other replies
+func (m *Messenger) getReply(channelID string, message *slackapi.Msg) *msg.Reply {
+	if !isThreadReply(message) {
+		return nil
+	}
+	reply := &msg.Reply{MessageId: message.ThreadTimestamp}
+	replies, _, _, err := m.api.GetConversationReplies(&slackapi.GetConversationRepliesParameters{
+		ChannelID: channelID,
+		Timestamp: message.ThreadTimestamp,
+		Limit:     1,
+	})
+	if err != nil || len(replies) == 0 {
+		log.Printf("could not get slack message %s: %v", message.ThreadTimestamp, err)
+		return reply
+	}
+	original := replies[0]
+	reply.Text, _ = m.parseMrkdwn(original.Text)
+	reply.Sender = m.getSender(channelID, original.User)
+	return reply
+}
+
+// getSlackFiles returns files shared in the message. They are private,
+// so they are downloaded with the token of the bot
+func getSlackFiles(message *slackapi.Msg) []messenger.RemoteFile {
+	var files []messenger.RemoteFile
+	for _, file := range message.Files {
+		if file.URLPrivateDownload == "" {
+			// files of other workspaces and removed files can not be downloaded
+			continue
+		}
+		files = append(files, messenger.RemoteFile{
+			URL:    file.URLPrivateDownload,
+			Name:   file.Name,
+			Type:   getAttachmentType(file),
+			Header: http.Header{"Authorization": {"Bearer " + Config.BotToken}},
+		})
+	}
+	return files
+}
+
+func getAttachmentType(file slackapi.File) string {
+	switch {
+	case file.Mimetype == "image/gif":
+		return "animation"
+	case strings.HasPrefix(file.Mimetype, "image/"):
+		return "photo"
+	case strings.HasPrefix(file.Mimetype, "video/"):
+		return "video"
+	case strings.HasPrefix(file.Mimetype, "audio/"):
+		return "audio"
+	}
+	return "doc"
+}
+
+// processCommand handles a slash command, replies are only shown to the user who sent the command
+func (m *Messenger) processCommand(command slackapi.SlashCommand) error {
+	log.Printf("new command: channel id: %s; command: %s", command.ChannelID, command.Command)
+	chat := m.chat(command.ChannelID)
+	args := strings.TrimSpace(command.Text)
+	var reply string
+	var err error
+	switch command.Command {
+	case "/get_token":
+		reply, err = m.GetChatToken(chat.Id, chat.Type)
+	case "/subscribe":
+		reply, err = m.SubscribeCallback(chat, args)
+	case "/unsubscribe":
+		err = m.UnsubscribeCallback(chat, args)
+	case "/bridge":
+		err = m.BridgeCallback(chat, args)
+	case "/filter":
+		reply, err = m.ProcessFilterCommand(chat, args)
+	case "/template":
+		reply, err = m.ProcessTemplateCommand(chat, args)
+	case "/photos":
+		reply, err = m.ProcessPhotosCommand(chat, args)
+	case "/failed":
+		reply, err = m.ProcessFailedCommand(chat)
+	default:
+		// commands are registered in the manifest of the app, others are not sent to the bot
+		reply = "Unknown command " + command.Command
+	}
+	if err == nil && reply != "" {
+		err = sendReply(command, reply)
+	}
+	return m.processCommandResult(err, command)
+}
+
+// processCommandResult checks if there is an error that needs to be sent to the user and tries to send it.
+// If the error was internal, it is added to the returned error
+//
+// It might have some messenger-specific logic in the future, so it should not be moved to baseMessenger.
+func (m *Messenger) processCommandResult(err error, command slackapi.SlashCommand) error {
+	// If err is nil or not a grpc error, it should be returned immediately
+	if status.Code(err) == codes.OK {
+		return err
+	}
+	sendErr := sendReply(command, status.Convert(err).Message())
+	if !messenger.IsUserInputError(err) {
+		if sendErr != nil {
+			return fmt.Errorf("could not process command: %v, could not send error %v", err, sendErr)
+		}
+		return err
+	}
+	return sendErr
+}
+
+// sendReply replies to a command with its response url, which works even in conversations the bot is not a member of
+func sendReply(command slackapi.SlashCommand, text string) error {
+	return slackapi.PostWebhook(command.ResponseURL, &slackapi.WebhookMessage{
+		Text:         escapeMrkdwn(text, false),
+		ResponseType: slackapi.ResponseTypeEphemeral,
+	})
+}
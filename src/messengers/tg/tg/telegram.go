@@ -13,6 +13,8 @@ type Messenger struct {
 	tg                 *tgbotapi.BotAPI
 	mediaGroups        Map[string, chan *IndexedAttachment]
 	mediaGroupLoadings Map[string, *sync.WaitGroup]
+	// updates are processed concurrently, the sequencer keeps them in order when they are passed to the controller
+	sequencer *messenger.Sequencer
 }
 
 type IndexedAttachment struct {
@@ -30,5 +32,6 @@ func NewMessenger(baseMessenger *messenger.BaseMessenger) *Messenger {
 		tg:                 bot,
 		mediaGroups:        NewMap[string, chan *IndexedAttachment](),
 		mediaGroupLoadings: NewMap[string, *sync.WaitGroup](),
+		sequencer:          messenger.NewSequencer(),
 	}
 }
@@ -2,12 +2,18 @@ package tg
 
 import (
 	"context"
+	"github.com/Pelmenner/TransferBot/messenger"
 	msg "github.com/Pelmenner/TransferBot/proto/messenger"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/golang/protobuf/ptypes/empty"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"html"
 	"log"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type Requirement int
@@ -18,78 +24,375 @@ const (
 	ReqNever
 )
 
-func (m *Messenger) SendMessage(_ context.Context, request *msg.SendMessageRequest) (*empty.Empty, error) {
-	success, tried := m.sendSpecialAttachmentType(request.Message, request.Chat, "photo", "photo", ReqOptional)
+// attachmentGroups lists types of attachments sent together in the order they are sent.
+// Documents go last, so that the text is sent with them if there was nothing to attach it to before
+var attachmentGroups = [][]string{
+	{"photo", "video"}, {"audio"}, {"animation"}, {"voice"}, {"video_note"}, {"sticker"}, {"doc"},
+}
+
+// ungroupedTypes are attachment types which telegram does not allow in media groups
+var ungroupedTypes = map[string]bool{"animation": true, "voice": true, "video_note": true, "sticker": true}
+
+// uncaptionedTypes are attachment types which can not have captions
+var uncaptionedTypes = map[string]bool{"video_note": true, "sticker": true}
+
+// stickerExtensions are formats of files telegram can send as stickers
+var stickerExtensions = map[string]bool{".webp": true, ".tgs": true, ".webm": true}
+
+// mediaGroupMaxSize is the maximum number of files in a single media group
+const mediaGroupMaxSize = 10
+
+func (m *Messenger) SendMessage(ctx context.Context, request *msg.SendMessageRequest) (*msg.SendMessageResponse, error) {
+	response := &msg.SendMessageResponse{}
+	cleanup, err := m.DownloadAttachments(ctx, request.Message.Attachments)
+	if err != nil {
+		log.Printf("could not download attachments: %v", err)
+		return response, status.Error(codes.Unknown, "could not download attachments")
+	}
+	defer cleanup()
+
+	requirement := ReqOptional
+	for i, attachmentTypes := range attachmentGroups {
+		if i == len(attachmentGroups)-1 && requirement == ReqOptional {
+			requirement = ReqAlways
+		}
+		messageIDs, success, tried := m.sendSpecialAttachmentType(ctx, request.Message, request.Chat,
+			attachmentTypes, requirement)
+		response.MessageIds = append(response.MessageIds, messageIDs...)
+		if !success {
+			return messenger.SendFailed(response, "could not send the message")
+		}
+		if tried {
+			requirement = ReqNever
+		}
+	}
+	messageIDs, success := m.sendContent(ctx, request.Message, request.Chat)
+	response.MessageIds = append(response.MessageIds, messageIDs...)
 	if !success {
-		return &empty.Empty{}, status.Error(codes.Unknown, "could not send the message")
+		return messenger.SendFailed(response, "could not send the message")
+	}
+	return response, nil
+}
+
+// EditMessage replaces the text of previously sent messages.
+// The text is always attached to the first of them, either as a message body or as a caption.
+func (m *Messenger) EditMessage(_ context.Context, request *msg.EditMessageRequest) (*empty.Empty, error) {
+	if len(request.MessageIds) == 0 {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "no messages to edit")
 	}
-	requirement := ReqAlways
-	if tried {
-		requirement = ReqNever
+	messageID, err := strconv.Atoi(request.MessageIds[0])
+	if err != nil {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "invalid message id")
 	}
+	text := m.messageText(request.Message)
 
-	success, _ = m.sendSpecialAttachmentType(request.Message, request.Chat, "doc", "document", requirement)
+	editText := tgbotapi.NewEditMessageText(request.Chat.Id, messageID, text)
+	editText.ParseMode = "HTML"
+	if _, err = m.tg.Send(editText); err == nil {
+		return &empty.Empty{}, nil
+	}
+	// there is no text in the message, so it must be a caption of a media
+	editCaption := tgbotapi.NewEditMessageCaption(request.Chat.Id, messageID, text)
+	editCaption.ParseMode = "HTML"
+	if _, err = m.tg.Send(editCaption); err != nil {
+		log.Print("could not edit tg message:", err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not edit the message")
+	}
+	return &empty.Empty{}, nil
+}
+
+func (m *Messenger) DeleteMessage(_ context.Context, request *msg.DeleteMessageRequest) (*empty.Empty, error) {
+	success := true
+	for _, id := range request.MessageIds {
+		messageID, err := strconv.Atoi(id)
+		if err != nil {
+			return &empty.Empty{}, status.Error(codes.InvalidArgument, "invalid message id")
+		}
+		if _, err = m.tg.Request(tgbotapi.NewDeleteMessage(request.Chat.Id, messageID)); err != nil {
+			log.Print("could not delete tg message:", err)
+			success = false
+		}
+	}
 	if !success {
-		return &empty.Empty{}, status.Error(codes.Unknown, "could not send the message")
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not delete the message")
 	}
 	return &empty.Empty{}, nil
 }
 
-// sendSpecialAttachmentType sends all attachments of given type in a message;
+// messageText returns the HTML text of the message, it is already formatted by the controller
+func (m *Messenger) messageText(message *msg.Message) string {
+	return messenger.FormatText(message.Text, message.Entities, htmlMarkup, func(text string, _ bool) string {
+		return tgbotapi.EscapeText("HTML", text)
+	})
+}
+
+// htmlMarkup marks formatted parts of texts with tags supported by telegram
+func htmlMarkup(entity *msg.TextEntity, _ string) (string, string) {
+	switch entity.Type {
+	case "bold":
+		return "<b>", "</b>"
+	case "italic":
+		return "<i>", "</i>"
+	case "underline":
+		return "<u>", "</u>"
+	case "strikethrough":
+		return "<s>", "</s>"
+	case "spoiler":
+		return "<tg-spoiler>", "</tg-spoiler>"
+	case "code":
+		return "<code>", "</code>"
+	case "pre":
+		if entity.Language != "" {
+			return `<pre><code class="language-` + html.EscapeString(entity.Language) + `">`, "</code></pre>"
+		}
+		return "<pre>", "</pre>"
+	case "link":
+		return `<a href="` + html.EscapeString(entity.Url) + `">`, "</a>"
+	case "blockquote":
+		return "<blockquote>", "</blockquote>"
+	}
+	return "", ""
+}
+
+// sendSpecialAttachmentType sends all attachments of given types in a message;
 //
 //	Sends text from provided message only if sendText is Always
 //	or sendText is optional and there message is already not empty
 //
-// Returns result (success) of sending and a value showing need to do it (was message not empty?)
-func (m *Messenger) sendSpecialAttachmentType(message *msg.Message, chat *msg.Chat, attachmentType,
-	attachmentFullType string, sendText Requirement) (success bool, needToSend bool) {
+// Returns ids of sent messages, result (success) of sending and a value showing need to do it (was message not empty?)
+func (m *Messenger) sendSpecialAttachmentType(ctx context.Context, message *msg.Message, chat *msg.Chat,
+	attachmentTypes []string, sendText Requirement) (messageIDs []string, success bool, needToSend bool) {
 	text := ""
 	if sendText != ReqNever {
-		text = m.SenderToString(message.Sender) + "\n" + tgbotapi.EscapeText("HTML", message.Text)
+		text = m.messageText(message)
+	}
+	attachments := getAttachmentsOfTypes(message, attachmentTypes)
+	// a template may leave nothing of the text, and telegram does not accept empty messages
+	if len(attachments) == 0 && (sendText != ReqAlways || strings.TrimSpace(text) == "") {
+		return nil, true, false
 	}
-	media := getPreparedMediaList(message, attachmentFullType, attachmentType, text)
-	if len(media) == 0 && sendText != ReqAlways {
-		return true, false
+	replyTo := 0
+	if sendText != ReqNever {
+		replyTo = getReplyToID(message)
 	}
-	success = true
-	if len(media) == 0 {
-		tgMessage := tgbotapi.NewMessage(chat.Id, text)
-		tgMessage.ParseMode = "HTML"
-		_, err := m.tg.Send(tgMessage)
+	if len(attachments) == 0 {
+		sent, err := m.sendText(ctx, chat, text, replyTo)
 		if err != nil {
 			log.Print("could not send tg message:", err)
-			success = false
+			return nil, false, true
 		}
-		return success, true
+		return []string{strconv.Itoa(sent.MessageID)}, true, true
+	}
+	if ungroupedTypes[attachmentTypes[0]] {
+		messageIDs, success = m.sendUngroupedMedia(ctx, attachments, chat, text, replyTo)
+		return messageIDs, success, true
 	}
-	mediaGroup := tgbotapi.NewMediaGroup(chat.Id, media)
-	_, err := m.tg.SendMediaGroup(mediaGroup)
+
+	for start := 0; start < len(attachments); start += mediaGroupMaxSize {
+		part := attachments[start:min(start+mediaGroupMaxSize, len(attachments))]
+		sent, err := m.sendMediaGroup(ctx, part, chat, text, replyTo, false)
+		if err != nil && attachmentTypes[0] != "doc" {
+			log.Printf("could not send tg media group, sending it as documents: %v", err)
+			sent, err = m.sendMediaGroup(ctx, part, chat, text, replyTo, true)
+		}
+		if err != nil {
+			log.Print("could not add tg ", attachmentTypes, err)
+			return messageIDs, false, true
+		}
+		for _, sentMessage := range sent {
+			messageIDs = append(messageIDs, strconv.Itoa(sentMessage.MessageID))
+		}
+		// the text and the reply go with the first part only
+		text, replyTo = "", 0
+	}
+	return messageIDs, true, true
+}
+
+func (m *Messenger) sendText(ctx context.Context, chat *msg.Chat, text string, replyTo int) (tgbotapi.Message, error) {
+	tgMessage := tgbotapi.NewMessage(chat.Id, text)
+	tgMessage.ParseMode = "HTML"
+	tgMessage.ReplyToMessageID = replyTo
+	tgMessage.AllowSendingWithoutReply = true
+	return m.send(ctx, tgMessage)
+}
+
+// send sends the request unless the context of the delivery is done.
+// Requests to telegram can not be cancelled once they are started
+func (m *Messenger) send(ctx context.Context, config tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return tgbotapi.Message{}, err
+	}
+	return m.tg.Send(config)
+}
+
+func (m *Messenger) sendMediaGroup(ctx context.Context, attachments []*msg.Attachment, chat *msg.Chat,
+	caption string, replyTo int, asDocuments bool) ([]tgbotapi.Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mediaGroup := tgbotapi.NewMediaGroup(chat.Id, getPreparedMediaList(attachments, caption, asDocuments))
+	mediaGroup.ReplyToMessageID = replyTo
+	return m.tg.SendMediaGroup(mediaGroup)
+}
+
+// sendUngroupedMedia sends attachments which can not be put into media groups one by one.
+// An attachment telegram refuses to send natively, e.g. a voice message to a user who forbids them,
+// is sent as a document
+func (m *Messenger) sendUngroupedMedia(ctx context.Context, attachments []*msg.Attachment, chat *msg.Chat,
+	caption string, replyTo int) (messageIDs []string, success bool) {
+	for _, attachment := range attachments {
+		if uncaptionedTypes[attachment.Type] && strings.TrimSpace(caption) != "" {
+			// the text goes before the attachments which can not have captions
+			sent, err := m.sendText(ctx, chat, caption, replyTo)
+			if err != nil {
+				log.Print("could not send tg message:", err)
+				return messageIDs, false
+			}
+			messageIDs = append(messageIDs, strconv.Itoa(sent.MessageID))
+			caption, replyTo = "", 0
+		}
+		sent, err := m.send(ctx, getPreparedMedia(chat.Id, attachment, caption, replyTo, false))
+		if err != nil {
+			log.Printf("could not send tg %s, sending it as a document: %v", attachment.Type, err)
+			sent, err = m.send(ctx, getPreparedMedia(chat.Id, attachment, caption, replyTo, true))
+		}
+		if err != nil {
+			log.Print("could not add tg ", attachment.Type, err)
+			return messageIDs, false
+		}
+		messageIDs = append(messageIDs, strconv.Itoa(sent.MessageID))
+		caption, replyTo = "", 0
+	}
+	return messageIDs, true
+}
+
+// sendContent sends locations, contacts and polls of the message.
+// Content telegram refuses to show natively, e.g. a poll with a single option, is sent as text
+func (m *Messenger) sendContent(ctx context.Context, message *msg.Message,
+	chat *msg.Chat) (messageIDs []string, success bool) {
+	type content struct {
+		config   tgbotapi.Chattable
+		fallback string
+	}
+	var contents []content
+	if location := message.Location; location != nil {
+		config := tgbotapi.Chattable(tgbotapi.NewLocation(chat.Id, location.Latitude, location.Longitude))
+		if location.Title != "" {
+			config = tgbotapi.NewVenue(chat.Id, location.Title, location.Address, location.Latitude, location.Longitude)
+		}
+		contents = append(contents, content{config, messenger.LocationText(location)})
+	}
+	if contact := message.Contact; contact != nil {
+		contents = append(contents, content{
+			tgbotapi.NewContact(chat.Id, contact.Phone, contact.Name), messenger.ContactText(contact),
+		})
+	}
+	if poll := message.Poll; poll != nil {
+		config := tgbotapi.NewPoll(chat.Id, poll.Question, poll.Options...)
+		config.AllowsMultipleAnswers = poll.MultipleAnswers
+		config.IsAnonymous = poll.Anonymous
+		contents = append(contents, content{config, messenger.PollText(poll)})
+	}
+
+	for _, item := range contents {
+		sent, err := m.send(ctx, item.config)
+		if err != nil {
+			log.Printf("could not send tg content, sending it as text: %v", err)
+			sent, err = m.sendText(ctx, chat, tgbotapi.EscapeText("HTML", item.fallback), 0)
+		}
+		if err != nil {
+			log.Print("could not send tg message:", err)
+			return messageIDs, false
+		}
+		messageIDs = append(messageIDs, strconv.Itoa(sent.MessageID))
+	}
+	return messageIDs, true
+}
+
+// getReplyToID returns id of the message in the destination chat the message replies to or 0 if there is none
+func getReplyToID(message *msg.Message) int {
+	if message.ReplyTo == nil {
+		return 0
+	}
+	replyTo, err := strconv.Atoi(message.ReplyTo.MessageId)
 	if err != nil {
-		log.Print("could not add tg ", attachmentFullType, err)
-		success = false
+		return 0
 	}
-	return success, true
+	return replyTo
 }
 
-// getPreparedMediaList creates tg media attachments for all message's attachments of given type
-func getPreparedMediaList(message *msg.Message, attachmentFullType, attachmentType, caption string) []interface{} {
-	var media []interface{}
+// getAttachmentsOfTypes returns the message's attachments of given types
+func getAttachmentsOfTypes(message *msg.Message, attachmentTypes []string) []*msg.Attachment {
+	var attachments []*msg.Attachment
 	for _, attachment := range message.Attachments {
-		if attachment.Type != attachmentType {
-			continue
-		}
-		currentCaption := ""
-		if len(media) == 0 {
-			currentCaption = caption
-		}
-		media = append(media, tgbotapi.InputMediaDocument{
-			BaseInputMedia: tgbotapi.BaseInputMedia{
-				Type:      attachmentFullType,
-				Media:     tgbotapi.FilePath(attachment.Url),
-				Caption:   currentCaption,
-				ParseMode: "HTML",
-			},
-		})
+		if slices.Contains(attachmentTypes, attachment.Type) {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments
+}
+
+// getPreparedMediaList creates tg media group items for the attachments, the caption is added to the first one
+func getPreparedMediaList(attachments []*msg.Attachment, caption string, asDocuments bool) []interface{} {
+	var media []interface{}
+	for _, attachment := range attachments {
+		base := tgbotapi.BaseInputMedia{
+			Media:     tgbotapi.FilePath(attachment.Url),
+			Caption:   caption,
+			ParseMode: "HTML",
+		}
+		caption = ""
+		switch {
+		case asDocuments || attachment.Type == "doc":
+			base.Type = "document"
+			media = append(media, tgbotapi.InputMediaDocument{BaseInputMedia: base})
+		case attachment.Type == "photo":
+			base.Type = "photo"
+			media = append(media, tgbotapi.InputMediaPhoto{BaseInputMedia: base})
+		case attachment.Type == "video":
+			base.Type = "video"
+			media = append(media, tgbotapi.InputMediaVideo{BaseInputMedia: base})
+		case attachment.Type == "audio":
+			base.Type = "audio"
+			media = append(media, tgbotapi.InputMediaAudio{BaseInputMedia: base})
+		}
 	}
 	return media
 }
+
+// getPreparedMedia creates a tg message with a single attachment which can not be put into a media group
+func getPreparedMedia(chatID int64, attachment *msg.Attachment, caption string, replyTo int,
+	asDocument bool) tgbotapi.Chattable {
+	file := tgbotapi.FilePath(attachment.Url)
+	reply := tgbotapi.BaseChat{ChatID: chatID, ReplyToMessageID: replyTo, AllowSendingWithoutReply: true}
+	switch {
+	case asDocument:
+	case attachment.Type == "animation":
+		animation := tgbotapi.NewAnimation(chatID, file)
+		animation.BaseChat = reply
+		animation.Caption, animation.ParseMode = caption, "HTML"
+		return animation
+	case attachment.Type == "voice":
+		voice := tgbotapi.NewVoice(chatID, file)
+		voice.BaseChat = reply
+		voice.Caption, voice.ParseMode = caption, "HTML"
+		return voice
+	case attachment.Type == "video_note":
+		videoNote := tgbotapi.NewVideoNote(chatID, 0, file)
+		videoNote.BaseChat = reply
+		return videoNote
+	case attachment.Type == "sticker" && stickerExtensions[strings.ToLower(filepath.Ext(attachment.Url))]:
+		sticker := tgbotapi.NewSticker(chatID, file)
+		sticker.BaseChat = reply
+		return sticker
+	case attachment.Type == "sticker":
+		// stickers from other messengers are usual images
+		photo := tgbotapi.NewPhoto(chatID, file)
+		photo.BaseChat = reply
+		return photo
+	}
+	document := tgbotapi.NewDocument(chatID, file)
+	document.BaseChat = reply
+	document.Caption, document.ParseMode = caption, "HTML"
+	return document
+}
@@ -12,8 +12,11 @@ import (
 	"log"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf16"
 )
 
 func (m *Messenger) Run(ctx context.Context) {
@@ -34,30 +37,57 @@ func (m *Messenger) Run(ctx context.Context) {
 			if update.UpdateID > lastUpdateID {
 				lastUpdateID = update.UpdateID
 			}
-			if update.Message == nil {
+			if update.Message == nil && update.EditedMessage == nil {
+				continue
+			}
+			if m.isOwnMessage(update.Message) || m.isOwnMessage(update.EditedMessage) {
 				continue
 			}
 
-			chat := chatFromMessage(update.Message)
-			go m.processUpdate(&update, chat)
+			chatID := update.FromChat().ID
+			go m.processUpdate(&update, m.sequencer.Ticket(chatID))
 		}
 	}
 }
 
-func (m *Messenger) processUpdate(update *tgbotapi.Update, chat *msg.Chat) {
+// isOwnMessage checks if the message was sent by the bot itself, e.g. it is a forwarded copy
+func (m *Messenger) isOwnMessage(message *tgbotapi.Message) bool {
+	return message != nil && message.From != nil && message.From.ID == m.tg.Self.ID
+}
+
+// processUpdate handles the update and marks its ticket as done once the update is passed to the controller
+func (m *Messenger) processUpdate(update *tgbotapi.Update, ticket *messenger.Ticket) {
 	m.logUpdate(update)
+	if update.EditedMessage != nil {
+		defer ticket.Done()
+		ticket.Wait()
+		chat := chatFromMessage(update.EditedMessage)
+		if err := m.processEditedMessage(update.EditedMessage, chat); err != nil {
+			log.Printf("error processing edited message: %v", err)
+		}
+		return
+	}
+
+	chat := chatFromMessage(update.Message)
 	if update.Message.IsCommand() {
+		defer ticket.Done()
+		ticket.Wait()
 		if err := m.processCommand(update.Message, chat); err != nil && err != errCommandNotFound {
 			log.Printf("error processing command: %v", err)
 		}
 	} else { // If we got a message
-		if err := m.processMessage(update.Message, chat); err != nil {
+		if err := m.processMessage(update.Message, chat, ticket); err != nil {
 			log.Printf("error processing message: %v", err)
 		}
 	}
 }
 
 func (m *Messenger) logUpdate(update *tgbotapi.Update) {
+	if update.EditedMessage != nil {
+		message := update.EditedMessage
+		log.Printf("edited message: chat id: %d; message id: %d", message.Chat.ID, message.MessageID)
+		return
+	}
 	message := update.Message
 	if message.IsCommand() {
 		log.Printf("new command: chat id: %d; message id: %d", message.Chat.ID, message.MessageID)
@@ -80,6 +110,18 @@ func (m *Messenger) processCommand(message *tgbotapi.Message, chat *msg.Chat) er
 		err = m.processSubscribe(message, chat)
 	case "unsubscribe":
 		err = m.processUnsubscribe(message, chat)
+	case "bridge":
+		err = m.processBridge(message, chat)
+	case "delete":
+		err = m.processDelete(message, chat)
+	case "filter":
+		err = m.processFilter(message, chat)
+	case "template":
+		err = m.processTemplate(message, chat)
+	case "photos":
+		err = m.processPhotos(message, chat)
+	case "failed":
+		err = m.processFailed(message, chat)
 	default:
 		return errCommandNotFound
 	}
@@ -117,103 +159,297 @@ func (m *Messenger) processGetToken(message *tgbotapi.Message, chat *msg.Chat) e
 }
 
 func (m *Messenger) processSubscribe(message *tgbotapi.Message, chat *msg.Chat) error {
-	return m.SubscribeCallback(chat, message.CommandArguments())
+	warning, err := m.SubscribeCallback(chat, message.CommandArguments())
+	if err != nil || warning == "" {
+		return err
+	}
+	_, err = m.tg.Send(tgbotapi.NewMessage(message.Chat.ID, warning))
+	return err
 }
 
 func (m *Messenger) processUnsubscribe(message *tgbotapi.Message, chat *msg.Chat) error {
 	return m.UnsubscribeCallback(chat, message.CommandArguments())
 }
 
-func (m *Messenger) processMessage(message *tgbotapi.Message, chat *msg.Chat) (err error) {
-	if message.ReplyToMessage != nil {
-		message.Text += "\nin reply to..."
+func (m *Messenger) processBridge(message *tgbotapi.Message, chat *msg.Chat) error {
+	return m.BridgeCallback(chat, message.CommandArguments())
+}
+
+func (m *Messenger) processFilter(message *tgbotapi.Message, chat *msg.Chat) error {
+	reply, err := m.ProcessFilterCommand(chat, message.CommandArguments())
+	if err != nil {
+		return err
 	}
-	if message.MediaGroupID == "" {
-		err = m.processSingleMessage(message, chat)
-	} else {
-		err = m.processPartOfGroupMessage(message, chat)
+	_, err = m.tg.Send(tgbotapi.NewMessage(message.Chat.ID, reply))
+	return err
+}
+
+func (m *Messenger) processTemplate(message *tgbotapi.Message, chat *msg.Chat) error {
+	reply, err := m.ProcessTemplateCommand(chat, message.CommandArguments())
+	if err != nil {
+		return err
 	}
-	if message.ReplyToMessage != nil {
-		return m.processMessage(message.ReplyToMessage, chat)
+	_, err = m.tg.Send(tgbotapi.NewMessage(message.Chat.ID, reply))
+	return err
+}
+
+func (m *Messenger) processPhotos(message *tgbotapi.Message, chat *msg.Chat) error {
+	reply, err := m.ProcessPhotosCommand(chat, message.CommandArguments())
+	if err != nil {
+		return err
 	}
+	_, err = m.tg.Send(tgbotapi.NewMessage(message.Chat.ID, reply))
 	return err
 }
 
-func (m *Messenger) processSingleMessage(message *tgbotapi.Message, chat *msg.Chat) (err error) {
-	standardMessage := msg.Message{
-		Text:   message.Text + message.Caption,
-		Sender: getTGSender(message),
+func (m *Messenger) processFailed(message *tgbotapi.Message, chat *msg.Chat) error {
+	reply, err := m.ProcessFailedCommand(chat)
+	if err != nil {
+		return err
 	}
-	if message.Photo != nil {
-		standardMessage.Attachments, err = m.addAttachment(
-			standardMessage.Attachments, message.Photo[len(message.Photo)-1].FileID, "", "photo")
-		if err != nil {
-			return err
-		}
+	_, err = m.tg.Send(tgbotapi.NewMessage(message.Chat.ID, reply))
+	return err
+}
+
+// processDelete deletes the message the command replies to together with all its forwarded copies.
+// Bot API does not report deleted messages, so the deletion has to be requested explicitly.
+func (m *Messenger) processDelete(message *tgbotapi.Message, chat *msg.Chat) error {
+	if message.ReplyToMessage == nil {
+		return status.Error(codes.InvalidArgument, "reply to the message which should be deleted")
 	}
-	if message.Document != nil {
-		standardMessage.Attachments, err = m.addAttachment(
-			standardMessage.Attachments, message.Document.FileID, message.Document.FileName, "doc")
-		if err != nil {
-			return err
+	isAdmin, err := m.isChatAdmin(message.Chat, message.From)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return status.Error(codes.PermissionDenied, "only chat administrators can delete messages")
+	}
+	if err = m.DeletedMessageCallback(chat, strconv.Itoa(message.ReplyToMessage.MessageID)); err != nil {
+		return err
+	}
+	// the bot may have no rights to delete messages here, but the copies are already deleted anyway
+	for _, messageID := range []int{message.ReplyToMessage.MessageID, message.MessageID} {
+		if _, err = m.tg.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, messageID)); err != nil {
+			log.Printf("could not delete tg message %d: %v", messageID, err)
 		}
 	}
-	return m.MessageCallback(&standardMessage, chat)
+	return nil
 }
 
-func (m *Messenger) addAttachment(attachments []*msg.Attachment, fileID, fileName, fileType string) ([]*msg.Attachment, error) {
-	url, err := m.saveTelegramFile(tgbotapi.FileConfig{FileID: fileID}, fileName)
+func (m *Messenger) isChatAdmin(chat *tgbotapi.Chat, user *tgbotapi.User) (bool, error) {
+	if chat.IsPrivate() {
+		return true, nil
+	}
+	if user == nil {
+		return false, nil
+	}
+	member, err := m.tg.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: user.ID},
+	})
 	if err != nil {
-		return attachments, err
+		return false, fmt.Errorf("could not get chat member: %w", err)
+	}
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
+
+// processMessage handles a new message. Files are downloaded concurrently with other updates,
+// but the message is passed to the controller only when its ticket is up.
+func (m *Messenger) processMessage(message *tgbotapi.Message, chat *msg.Chat, ticket *messenger.Ticket) error {
+	if message.MediaGroupID == "" {
+		defer ticket.Done()
+		return m.processSingleMessage(message, chat, ticket)
+	}
+	return m.processPartOfGroupMessage(message, chat, ticket)
+}
+
+func (m *Messenger) processEditedMessage(message *tgbotapi.Message, chat *msg.Chat) error {
+	standardMessage := msg.Message{
+		Id:       strconv.Itoa(message.MessageID),
+		Text:     message.Text + message.Caption,
+		Entities: getTGEntities(message),
+		Sender:   getTGSender(message),
+		ReplyTo:  getTGReply(message),
 	}
-	return append(attachments,
-		&msg.Attachment{
-			Type: fileType,
-			Url:  url,
-		}), nil
+	return m.EditedMessageCallback(&standardMessage, chat)
 }
 
-func (m *Messenger) processPartOfGroupMessage(message *tgbotapi.Message, chat *msg.Chat) error {
+func (m *Messenger) processSingleMessage(message *tgbotapi.Message, chat *msg.Chat,
+	ticket *messenger.Ticket) (err error) {
+	standardMessage := msg.Message{
+		Id:       strconv.Itoa(message.MessageID),
+		Text:     message.Text + message.Caption,
+		Entities: getTGEntities(message),
+		Sender:   getTGSender(message),
+		ReplyTo:  getTGReply(message),
+	}
+	fillTGContent(message, &standardMessage)
+	standardMessage.Attachments, err = m.downloadTelegramFiles(getTGFiles(message))
+	if err != nil {
+		return err
+	}
+	ticket.Wait()
+	return m.MessageCallback(&standardMessage, chat)
+}
+
+// processPartOfGroupMessage adds the message to its media group.
+// The whole group is passed to the controller at once using the ticket of the part that came first,
+// tickets of the other parts are done right away.
+func (m *Messenger) processPartOfGroupMessage(message *tgbotapi.Message, chat *msg.Chat,
+	ticket *messenger.Ticket) error {
 	if !m.mediaGroups.Contains(message.MediaGroupID) {
 		m.mediaGroups.Set(message.MediaGroupID, make(chan *IndexedAttachment))
 		m.mediaGroupLoadings.Set(message.MediaGroupID, &sync.WaitGroup{})
 		// media group is split into different messages, we need to catch them all before processing it
-		go m.processMediaGroup(message, chat)
+		go m.processMediaGroup(message, chat, ticket)
+	} else {
+		ticket.Done()
 	}
 
-	if message.Photo != nil {
-		if err := m.addMediaGroupAttachment(message.Photo[len(message.Photo)-1].FileID, "",
-			"photo", message.MediaGroupID, message.MessageID); err != nil {
+	for _, file := range getTGFiles(message) {
+		if err := m.addMediaGroupAttachment(file, message.MediaGroupID, message.MessageID); err != nil {
 			return err
 		}
 	}
-	if message.Document != nil {
-		if err := m.addMediaGroupAttachment(message.Document.FileID, message.Document.FileName,
-			"doc", message.MediaGroupID, message.MessageID); err != nil {
-			return err
+	return nil
+}
+
+// tgFile is a file attached to a telegram message
+type tgFile struct {
+	fileID   string
+	fileName string
+	fileType string
+}
+
+// getTGFiles returns all files attached to the message along with their attachment types
+func getTGFiles(message *tgbotapi.Message) []tgFile {
+	var files []tgFile
+	if message.Photo != nil {
+		files = append(files, tgFile{message.Photo[len(message.Photo)-1].FileID, "", "photo"})
+	}
+	// animations are duplicated as documents for old clients
+	if message.Animation != nil {
+		files = append(files, tgFile{message.Animation.FileID, message.Animation.FileName, "animation"})
+	} else if message.Document != nil {
+		files = append(files, tgFile{message.Document.FileID, message.Document.FileName, "doc"})
+	}
+	if message.Video != nil {
+		files = append(files, tgFile{message.Video.FileID, message.Video.FileName, "video"})
+	}
+	if message.Audio != nil {
+		files = append(files, tgFile{message.Audio.FileID, message.Audio.FileName, "audio"})
+	}
+	if message.Voice != nil {
+		files = append(files, tgFile{message.Voice.FileID, "", "voice"})
+	}
+	if message.VideoNote != nil {
+		files = append(files, tgFile{message.VideoNote.FileID, "", "video_note"})
+	}
+	if sticker := message.Sticker; sticker != nil {
+		// animated stickers can only be shown by telegram, so their previews are sent instead
+		if sticker.IsAnimated && sticker.Thumbnail != nil {
+			files = append(files, tgFile{sticker.Thumbnail.FileID, "", "sticker"})
+		} else {
+			files = append(files, tgFile{sticker.FileID, "", "sticker"})
 		}
 	}
-	return nil
+	return files
+}
+
+// tgEntityTypes maps types of telegram entities to the standard ones.
+// Entities which are detected by messengers automatically, such as urls and hashtags, are not kept
+var tgEntityTypes = map[string]string{
+	"bold":          "bold",
+	"italic":        "italic",
+	"underline":     "underline",
+	"strikethrough": "strikethrough",
+	"spoiler":       "spoiler",
+	"code":          "code",
+	"pre":           "pre",
+	"text_link":     "link",
+	"blockquote":    "blockquote",
 }
 
-func (m *Messenger) addMediaGroupAttachment(fileID, fileName, fileType, mediaGroupID string, messageID int) error {
+// getTGEntities returns formatting of the message text or caption
+func getTGEntities(message *tgbotapi.Message) []*msg.TextEntity {
+	var entities []*msg.TextEntity
+	// the caption goes after the text, though there is hardly ever both of them
+	captionOffset := len(utf16.Encode([]rune(message.Text)))
+	for _, entity := range message.Entities {
+		entities = appendTGEntity(entities, entity, 0)
+	}
+	for _, entity := range message.CaptionEntities {
+		entities = appendTGEntity(entities, entity, captionOffset)
+	}
+	return entities
+}
+
+func appendTGEntity(entities []*msg.TextEntity, entity tgbotapi.MessageEntity, offset int) []*msg.TextEntity {
+	entityType, exists := tgEntityTypes[entity.Type]
+	if !exists {
+		return entities
+	}
+	return append(entities, &msg.TextEntity{
+		Type:     entityType,
+		Offset:   int32(entity.Offset + offset),
+		Length:   int32(entity.Length),
+		Url:      entity.URL,
+		Language: entity.Language,
+	})
+}
+
+// fillTGContent adds locations, contacts and polls of the telegram message to the standard message
+func fillTGContent(message *tgbotapi.Message, standardMessage *msg.Message) {
+	if message.Venue != nil {
+		standardMessage.Location = &msg.Location{
+			Latitude:  message.Venue.Location.Latitude,
+			Longitude: message.Venue.Location.Longitude,
+			Title:     message.Venue.Title,
+			Address:   message.Venue.Address,
+		}
+	} else if message.Location != nil {
+		standardMessage.Location = &msg.Location{
+			Latitude:  message.Location.Latitude,
+			Longitude: message.Location.Longitude,
+		}
+	}
+	if contact := message.Contact; contact != nil {
+		standardMessage.Contact = &msg.Contact{
+			Name:  strings.TrimSpace(contact.FirstName + " " + contact.LastName),
+			Phone: contact.PhoneNumber,
+		}
+	}
+	if poll := message.Poll; poll != nil {
+		standardMessage.Poll = &msg.Poll{
+			Question:        poll.Question,
+			MultipleAnswers: poll.AllowsMultipleAnswers,
+			Anonymous:       poll.IsAnonymous,
+		}
+		for _, option := range poll.Options {
+			standardMessage.Poll.Options = append(standardMessage.Poll.Options, option.Text)
+		}
+	}
+}
+
+func (m *Messenger) addMediaGroupAttachment(file tgFile, mediaGroupID string, messageID int) error {
 	m.mediaGroupLoadings.Get(mediaGroupID).Add(1)
 
-	url, err := m.saveTelegramFile(tgbotapi.FileConfig{FileID: fileID}, fileName)
+	attachments, err := m.downloadTelegramFiles([]tgFile{file})
 	if err != nil {
 		m.mediaGroupLoadings.Get(mediaGroupID).Done()
 		return err
 	}
 
+	attachment := attachments[0]
 	m.mediaGroups.Get(mediaGroupID) <- &IndexedAttachment{
-		Attachment: msg.Attachment{Type: fileType, Url: url},
+		Attachment: msg.Attachment{Type: attachment.Type, Url: attachment.Url, Name: attachment.Name},
 		ID:         messageID,
 	}
 	m.mediaGroupLoadings.Get(mediaGroupID).Done()
 	return nil
 }
 
-func (m *Messenger) processMediaGroup(message *tgbotapi.Message, chat *msg.Chat) {
+func (m *Messenger) processMediaGroup(message *tgbotapi.Message, chat *msg.Chat, ticket *messenger.Ticket) {
+	defer ticket.Done()
 	// wait for all media in a group to be received and processed (in another goroutine)
 	// we don't know when it ends, so just wait fixed time
 	mediaGroupID := message.MediaGroupID
@@ -228,9 +464,12 @@ func (m *Messenger) processMediaGroup(message *tgbotapi.Message, chat *msg.Chat)
 	mediaGroup := m.mediaGroups.Get(mediaGroupID)
 
 	standardMessage := msg.Message{
+		Id:          strconv.Itoa(message.MessageID),
 		Text:        message.Text + message.Caption,
+		Entities:    getTGEntities(message),
 		Sender:      getTGSender(message),
 		Attachments: []*msg.Attachment{},
+		ReplyTo:     getTGReply(message),
 	}
 	var indexedAttachments []*IndexedAttachment
 	for attachment := range mediaGroup {
@@ -243,6 +482,7 @@ func (m *Messenger) processMediaGroup(message *tgbotapi.Message, chat *msg.Chat)
 		attachment := &indexedAttachment.Attachment
 		standardMessage.Attachments = append(standardMessage.Attachments, attachment)
 	}
+	ticket.Wait()
 	if err := m.MessageCallback(&standardMessage, chat); err != nil {
 		log.Printf("message callback error: %v", err)
 	}
@@ -250,22 +490,35 @@ func (m *Messenger) processMediaGroup(message *tgbotapi.Message, chat *msg.Chat)
 	m.mediaGroupLoadings.Delete(mediaGroupID)
 }
 
-// saveTelegramFile saves a file to local storage and returns the path to saved file
-func (m *Messenger) saveTelegramFile(config tgbotapi.FileConfig, fileName string) (string, error) {
-	file, err := m.tg.GetFile(config)
-	if err != nil {
-		return "", fmt.Errorf("error loading file: %w", err)
-	}
-
-	if fileName == "" {
-		fileName = filepath.Base(file.FilePath)
+// downloadTelegramFiles saves the files to local storage concurrently
+// and returns attachments with paths to the saved files. Either all files are saved or none
+func (m *Messenger) downloadTelegramFiles(files []tgFile) ([]*msg.Attachment, error) {
+	var remoteFiles []messenger.RemoteFile
+	for _, file := range files {
+		info, err := m.tg.GetFile(tgbotapi.FileConfig{FileID: file.fileID})
+		if err != nil {
+			return nil, fmt.Errorf("error loading file: %w", err)
+		}
+		name := file.fileName
+		if name == "" {
+			name = filepath.Base(info.FilePath)
+		}
+		remoteFiles = append(remoteFiles, messenger.RemoteFile{
+			URL:  info.Link(m.tg.Token),
+			Name: name,
+			Type: file.fileType,
+		})
 	}
-	filePath := fmt.Sprintf("/transferbot/data/downloads/tg/%s/%s", file.FileID, fileName)
-	err = DownloadFile(filePath, file.Link(m.tg.Token))
+	attachments, err := m.DownloadFiles(remoteFiles)
 	if err != nil {
-		return "", fmt.Errorf("error downloading file: %w", err)
+		for _, attachment := range attachments {
+			if attachment != nil {
+				messenger.RemoveLocalFile(attachment.Url)
+			}
+		}
+		return nil, fmt.Errorf("error downloading file: %w", err)
 	}
-	return filePath, nil
+	return attachments, nil
 }
 
 func getTGSender(message *tgbotapi.Message) *msg.Sender {
@@ -285,6 +538,18 @@ func getTGSender(message *tgbotapi.Message) *msg.Sender {
 	return &sender
 }
 
+func getTGReply(message *tgbotapi.Message) *msg.Reply {
+	reply := message.ReplyToMessage
+	if reply == nil {
+		return nil
+	}
+	return &msg.Reply{
+		MessageId: strconv.Itoa(reply.MessageID),
+		Sender:    getTGSender(reply),
+		Text:      reply.Text + reply.Caption,
+	}
+}
+
 func chatFromMessage(message *tgbotapi.Message) *msg.Chat {
 	return &msg.Chat{
 		Id:   message.Chat.ID,
@@ -294,5 +559,8 @@ func chatFromMessage(message *tgbotapi.Message) *msg.Chat {
 }
 
 func getTGUserName(user *tgbotapi.User) string {
+	if user == nil {
+		return ""
+	}
 	return fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 }
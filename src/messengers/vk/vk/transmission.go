@@ -2,28 +2,53 @@ package vk
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/SevereCloud/vksdk/v2/api"
 	"github.com/SevereCloud/vksdk/v2/api/params"
 	"github.com/golang/protobuf/ptypes/empty"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-func (m *Messenger) SendMessage(_ context.Context, request *msg.SendMessageRequest) (*empty.Empty, error) {
+func (m *Messenger) SendMessage(ctx context.Context, request *msg.SendMessageRequest) (*msg.SendMessageResponse, error) {
 	destinationChatID := int(request.Chat.Id)
+	cleanup, err := m.DownloadAttachments(ctx, request.Message.Attachments)
+	if err != nil {
+		log.Printf("could not download attachments: %v", err)
+		return &msg.SendMessageResponse{}, status.Error(codes.Unknown, "could not download attachments")
+	}
+	defer cleanup()
+
+	text, contentAttachments := m.prepareContent(request.Message)
 	messageBuilder := params.NewMessagesSendBuilder()
-	messageBuilder.Message(m.SenderToString(request.Message.Sender) + "\n" + request.Message.Text)
+	messageBuilder.Message(text)
 	messageBuilder.RandomID(0)
-	messageBuilder.PeerID(destinationChatID)
+	// peer_ids make VK return conversation message ids, which are needed to edit the message later
+	messageBuilder.PeerIDs([]int{destinationChatID})
+	if forward := getReplyForward(destinationChatID, request.Message); forward != "" {
+		messageBuilder.Forward(forward)
+	}
+	if location := request.Message.Location; location != nil {
+		messageBuilder.Lat(location.Latitude)
+		messageBuilder.Long(location.Longitude)
+	}
 
 	var attachmentStringBuilder strings.Builder
+	attachmentStringBuilder.WriteString(contentAttachments)
 	for _, attachment := range request.Message.Attachments {
+		// uploads can not be cancelled once they are started, so the rest are not started after the deadline
+		if err = ctx.Err(); err != nil {
+			return &msg.SendMessageResponse{}, status.FromContextError(err).Err()
+		}
 		attachmentString, err := m.uploadAttachment(destinationChatID, attachment)
 		if err != nil {
 			log.Printf("error uploading file of type %s: %v", attachment.Type, err)
@@ -32,28 +57,214 @@ func (m *Messenger) SendMessage(_ context.Context, request *msg.SendMessageReque
 		attachmentStringBuilder.WriteString(attachmentString)
 	}
 	messageBuilder.Attachment(attachmentStringBuilder.String())
+	// a template may leave nothing of the text, and vk does not accept empty messages
+	if strings.TrimSpace(text) == "" && attachmentStringBuilder.Len() == 0 && request.Message.Location == nil {
+		return &msg.SendMessageResponse{}, nil
+	}
 
-	_, err := m.vk.MessagesSend(messageBuilder.Params)
+	response, err := m.vk.MessagesSendPeerIDs(messageBuilder.Params.WithContext(ctx))
+	if err == nil && len(response) == 0 {
+		err = fmt.Errorf("empty response")
+	} else if err == nil && response[0].Error.Code != 0 {
+		err = &response[0].Error
+	}
 	if err != nil {
 		log.Print(err)
-		return &empty.Empty{}, status.Error(codes.Unknown, "could not send the message")
+		return &msg.SendMessageResponse{}, status.Error(codes.Unknown, "could not send the message")
+	}
+	return &msg.SendMessageResponse{
+		MessageIds: []string{strconv.Itoa(response[0].ConversationMessageID)},
+	}, nil
+}
+
+func (m *Messenger) EditMessage(_ context.Context, request *msg.EditMessageRequest) (*empty.Empty, error) {
+	if len(request.MessageIds) == 0 {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "no messages to edit")
+	}
+	conversationMessageID, err := strconv.Atoi(request.MessageIds[0])
+	if err != nil {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "invalid message id")
+	}
+	chatID := int(request.Chat.Id)
+	editParams := api.Params{
+		"peer_id":                 chatID,
+		"conversation_message_id": conversationMessageID,
+		"message":                 messenger.PlainText(request.Message.Text, request.Message.Entities),
+		"keep_forward_messages":   true,
+		"keep_snippets":           true,
+	}
+	// attachments which are not listed explicitly are removed from the edited message
+	attachments, err := m.getMessageAttachments(chatID, conversationMessageID)
+	if err != nil {
+		log.Printf("could not get attachments of the edited message: %v", err)
+	} else if attachments != "" {
+		editParams["attachment"] = attachments
+	}
+
+	if _, err = m.vk.MessagesEdit(editParams); err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not edit the message")
 	}
 	return &empty.Empty{}, nil
 }
 
+func (m *Messenger) DeleteMessage(_ context.Context, request *msg.DeleteMessageRequest) (*empty.Empty, error) {
+	conversationMessageIDs := make([]int, 0, len(request.MessageIds))
+	for _, id := range request.MessageIds {
+		conversationMessageID, err := strconv.Atoi(id)
+		if err != nil {
+			return &empty.Empty{}, status.Error(codes.InvalidArgument, "invalid message id")
+		}
+		conversationMessageIDs = append(conversationMessageIDs, conversationMessageID)
+	}
+	_, err := m.vk.MessagesDelete(api.Params{
+		"peer_id":        request.Chat.Id,
+		"cmids":          conversationMessageIDs,
+		"delete_for_all": true,
+	})
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not delete the message")
+	}
+	return &empty.Empty{}, nil
+}
+
+// getReplyForward returns the value of messages.send forward parameter which makes the message a reply.
+// Bots can not reply using message ids in group chats, so conversation message ids are used instead.
+func getReplyForward(chatID int, message *msg.Message) string {
+	if message.ReplyTo == nil {
+		return ""
+	}
+	conversationMessageID, err := strconv.Atoi(message.ReplyTo.MessageId)
+	if err != nil {
+		return ""
+	}
+	forward, err := json.Marshal(struct {
+		PeerID                 int   `json:"peer_id"`
+		ConversationMessageIDs []int `json:"conversation_message_ids"`
+		IsReply                bool  `json:"is_reply"`
+	}{chatID, []int{conversationMessageID}, true})
+	if err != nil {
+		return ""
+	}
+	return string(forward)
+}
+
+// getMessageAttachments returns attachments of a sent message in a format accepted by messages.send
+func (m *Messenger) getMessageAttachments(chatID, conversationMessageID int) (string, error) {
+	messageResponse, err := m.vk.MessagesGetByConversationMessageID(api.Params{
+		"conversation_message_ids": conversationMessageID,
+		"peer_id":                  chatID,
+	})
+	if err != nil {
+		return "", err
+	}
+	if messageResponse.Count == 0 {
+		return "", fmt.Errorf("message %d not found in chat %d", conversationMessageID, chatID)
+	}
+	var attachments []string
+	for _, attachment := range messageResponse.Items[0].Attachments {
+		switch attachment.Type {
+		case "photo":
+			attachments = append(attachments, attachment.Photo.ToAttachment())
+		case "doc":
+			attachments = append(attachments, attachment.Doc.ToAttachment())
+		case "video":
+			attachments = append(attachments, attachment.Video.ToAttachment())
+		case "audio":
+			attachments = append(attachments, attachment.Audio.ToAttachment())
+		case "audio_message":
+			attachments = append(attachments, attachment.AudioMessage.ToAttachment())
+		case "poll":
+			attachments = append(attachments, attachment.Poll.ToAttachment())
+		}
+	}
+	return strings.Join(attachments, ","), nil
+}
+
 func (m *Messenger) uploadAttachment(chatID int, attachment *msg.Attachment) (string, error) {
+	switch attachment.Type {
+	case "photo":
+		return uploadFile(attachment, func(file io.Reader) (string, error) {
+			return m.uploadPhoto(chatID, file)
+		})
+	case "voice":
+		uploaded, err := uploadFile(attachment, func(file io.Reader) (string, error) {
+			return m.uploadAudioMessage(chatID, attachment.Name, file)
+		})
+		if err == nil {
+			return uploaded, nil
+		}
+		log.Printf("could not upload voice message, sending it as a document: %v", err)
+	case "sticker":
+		// stickers of other messengers are shown as images
+		uploaded, err := uploadFile(attachment, func(file io.Reader) (string, error) {
+			return m.uploadPhoto(chatID, file)
+		})
+		if err == nil {
+			return uploaded, nil
+		}
+		log.Printf("could not upload sticker as a photo, sending it as a document: %v", err)
+	}
+	// vk does not let bots upload videos and audios, so the rest of the files are sent as documents
+	return uploadFile(attachment, func(file io.Reader) (string, error) {
+		return m.uploadDocument(chatID, attachment.Name, file)
+	})
+}
+
+// prepareContent returns the message text with the content vk can not show natively added to it
+// and the attachments made for the rest of the content. Locations are shown on a map by the message itself
+func (m *Messenger) prepareContent(message *msg.Message) (string, string) {
+	parts := []string{messenger.PlainText(message.Text, message.Entities)}
+	if location := message.Location; location != nil {
+		// names of places are not shown on the map
+		parts = append(parts, strings.TrimSpace(location.Title+"\n"+location.Address))
+	}
+	if message.Contact != nil {
+		parts = append(parts, messenger.ContactText(message.Contact))
+	}
+	attachments := ""
+	if poll := message.Poll; poll != nil {
+		var err error
+		if attachments, err = m.createPoll(poll); err != nil {
+			log.Printf("could not create vk poll, sending it as text: %v", err)
+			parts = append(parts, messenger.PollText(poll))
+		}
+	}
+
+	var text []string
+	for _, part := range parts {
+		if strings.TrimSpace(part) != "" {
+			text = append(text, part)
+		}
+	}
+	return strings.Join(text, "\n\n"), attachments
+}
+
+func (m *Messenger) createPoll(poll *msg.Poll) (string, error) {
+	answers, err := json.Marshal(poll.Options)
+	if err != nil {
+		return "", err
+	}
+	response, err := m.vk.PollsCreate(api.Params{
+		"question":     poll.Question,
+		"is_anonymous": poll.Anonymous,
+		"is_multiple":  poll.MultipleAnswers,
+		"add_answers":  string(answers),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d_%d,", "poll", response.OwnerID, response.ID), nil
+}
+
+func uploadFile(attachment *msg.Attachment, upload func(file io.Reader) (string, error)) (string, error) {
 	file, err := os.Open(attachment.Url)
 	if err != nil {
 		return "", fmt.Errorf("could not open file %s: %v", attachment.Url, err)
 	}
 	defer file.Close()
-
-	if attachment.Type == "photo" {
-		return m.uploadPhoto(chatID, file)
-	} else if attachment.Type == "doc" {
-		return m.uploadDocument(chatID, attachment.Url, file)
-	}
-	return "", fmt.Errorf("unknown attachment type: %s", attachment.Type)
+	return upload(file)
 }
 
 func (m *Messenger) uploadPhoto(chatID int, file io.Reader) (string, error) {
@@ -65,6 +276,16 @@ func (m *Messenger) uploadPhoto(chatID int, file io.Reader) (string, error) {
 		response[len(response)-1].OwnerID, response[len(response)-1].ID), nil
 }
 
+// uploadAudioMessage uploads a voice message, it should be in ogg format with opus codec
+func (m *Messenger) uploadAudioMessage(chatID int, title string, file io.Reader) (string, error) {
+	response, err := m.vk.UploadMessagesDoc(chatID, "audio_message", title, "", file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d_%d,", "doc",
+		response.AudioMessage.OwnerID, response.AudioMessage.ID), nil
+}
+
 func (m *Messenger) uploadDocument(chatID int, title string, file io.Reader) (string, error) {
 	response, err := m.vk.UploadMessagesDoc(chatID, "doc", title, "", file)
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 	"google.golang.org/grpc/status"
 	"log"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	msg "github.com/Pelmenner/TransferBot/proto/messenger"
@@ -32,29 +33,68 @@ func (m *Messenger) processMessage(message object.MessagesMessage, chat *msg.Cha
 	if message.IsCropped {
 		message = m.getFullMessage(message)
 	}
-	if err := m.processCommand(message, chat); err != nil && err != errCommandNotFound {
+	// commands are handled by the bot and are not transferred to the subscribers
+	if err := m.processCommand(message, chat); err != errCommandNotFound {
 		return err
 	}
 	standardMessage := msg.Message{
+		Id:   getMessageID(message),
 		Text: message.Text,
 		Sender: &msg.Sender{
 			Name: m.getSenderName(message),
 			Chat: &msg.Chat{Name: "vk"},
 		},
+		ReplyTo: m.getReply(message),
 	}
 	var walls []*object.WallWallpost
+	var files []messenger.RemoteFile
+	// links to videos by indices of their files, the links are sent if the files can not be downloaded
+	videoLinks := make(map[int]string)
 	for _, attachment := range message.Attachments {
 		switch attachment.Type {
 		case "photo":
-			standardMessage.Attachments = m.processPhoto(attachment.Photo, chat.Id, standardMessage.Attachments)
+			files = append(files, getPhotoFile(attachment.Photo))
 		case "wall":
 			walls = append(walls, &attachment.Wall)
 		case "doc":
-			standardMessage.Attachments = m.processDocument(attachment.Doc, chat.Id, standardMessage.Attachments)
+			files = append(files, messenger.RemoteFile{URL: attachment.Doc.URL, Name: attachment.Doc.Title, Type: "doc"})
+		case "video":
+			link := "https://vk.com/" + attachment.Video.ToAttachment()
+			if file, exists := getVideoFile(attachment.Video); exists {
+				videoLinks[len(files)] = link
+				files = append(files, file)
+			} else {
+				// bots usually have no access to the files of videos
+				addLink(&standardMessage, link)
+			}
+		case "audio":
+			if file, exists := getAudioFile(attachment.Audio); exists {
+				files = append(files, file)
+			} else {
+				log.Printf("no access to vk audio %s", attachment.Audio.ToAttachment())
+			}
+		case "audio_message":
+			files = append(files, getAudioMessageFile(attachment.AudioMessage))
+		case "sticker":
+			files = append(files, getStickerFile(attachment.Sticker))
+		case "poll":
+			standardMessage.Poll = getPoll(attachment.Poll)
 		}
 	}
-	if message.ReplyMessage != nil {
-		standardMessage.Text += "\nin reply to..."
+	for i, attachment := range m.downloadVKFiles(files) {
+		if attachment != nil {
+			standardMessage.Attachments = append(standardMessage.Attachments, attachment)
+		} else if link, exists := videoLinks[i]; exists {
+			addLink(&standardMessage, link)
+		}
+	}
+	if message.Geo.Type != "" {
+		standardMessage.Location = &msg.Location{
+			Latitude:  message.Geo.Coordinates.Latitude,
+			Longitude: message.Geo.Coordinates.Longitude,
+			Title:     message.Geo.Place.Title,
+			Address:   message.Geo.Place.Address,
+		}
 	}
 	err := m.MessageCallback(&standardMessage, chat)
 	for _, wall := range walls {
@@ -62,17 +102,51 @@ func (m *Messenger) processMessage(message object.MessagesMessage, chat *msg.Cha
 			return err
 		}
 	}
-	if message.ReplyMessage != nil {
-		if err = m.processMessage(*message.ReplyMessage, chat); err != nil {
-			return err
-		}
-	}
 	for _, message := range message.FwdMessages {
+		// forwarded messages belong to other conversations, so their ids mean nothing in this chat
+		message.ConversationMessageID = 0
 		_ = m.processMessage(message, chat)
 	}
 	return nil
 }
 
+func (m *Messenger) processEditedMessage(message object.MessagesMessage, chat *msg.Chat) error {
+	standardMessage := msg.Message{
+		Id:   getMessageID(message),
+		Text: message.Text,
+		Sender: &msg.Sender{
+			Name: m.getSenderName(message),
+			Chat: &msg.Chat{Name: "vk"},
+		},
+		ReplyTo: m.getReply(message),
+	}
+	return m.EditedMessageCallback(&standardMessage, chat)
+}
+
+func (m *Messenger) getReply(message object.MessagesMessage) *msg.Reply {
+	reply := message.ReplyMessage
+	if reply == nil {
+		return nil
+	}
+	return &msg.Reply{
+		MessageId: getMessageID(*reply),
+		Sender: &msg.Sender{
+			Name: m.getSenderName(*reply),
+			Chat: &msg.Chat{Name: "vk"},
+		},
+		Text: reply.Text,
+	}
+}
+
+// getMessageID returns id of the message inside its conversation.
+// Unlike global message ids, conversation ones are available to bots in group chats.
+func getMessageID(message object.MessagesMessage) string {
+	if message.ConversationMessageID == 0 {
+		return ""
+	}
+	return strconv.Itoa(message.ConversationMessageID)
+}
+
 // Returns not cropped message requesting it by id (extracted from given message)
 func (m *Messenger) getFullMessage(message object.MessagesMessage) object.MessagesMessage {
 	messageResponse, err := m.vk.MessagesGetByConversationMessageID(api.Params{
@@ -105,6 +179,18 @@ func (m *Messenger) processCommand(message object.MessagesMessage, chat *msg.Cha
 		err = m.processSubscribe(message, chat)
 	} else if strings.HasPrefix(message.Text, "/unsubscribe") {
 		err = m.processUnsubscribe(message, chat)
+	} else if strings.HasPrefix(message.Text, "/bridge") {
+		err = m.processBridge(message, chat)
+	} else if strings.HasPrefix(message.Text, "/delete") {
+		err = m.processDelete(message, chat)
+	} else if strings.HasPrefix(message.Text, "/filter") {
+		err = m.processFilter(message, chat)
+	} else if strings.HasPrefix(message.Text, "/template") {
+		err = m.processTemplate(message, chat)
+	} else if strings.HasPrefix(message.Text, "/photos") {
+		err = m.processPhotos(message, chat)
+	} else if strings.HasPrefix(message.Text, "/failed") {
+		err = m.processFailed(message, chat)
 	} else {
 		return errCommandNotFound
 	}
@@ -147,7 +233,15 @@ func (m *Messenger) processGetToken(_ object.MessagesMessage, chat *msg.Chat) er
 
 func (m *Messenger) processSubscribe(message object.MessagesMessage, chat *msg.Chat) error {
 	s := strings.Split(message.Text, " ")
-	return m.SubscribeCallback(chat, s[len(s)-1])
+	warning, err := m.SubscribeCallback(chat, s[len(s)-1])
+	if err != nil || warning == "" {
+		return err
+	}
+	_, err = m.SendMessage(context.TODO(), &msg.SendMessageRequest{
+		Message: &msg.Message{Text: warning},
+		Chat:    chat,
+	})
+	return err
 }
 
 func (m *Messenger) processUnsubscribe(message object.MessagesMessage, chat *msg.Chat) error {
@@ -155,6 +249,113 @@ func (m *Messenger) processUnsubscribe(message object.MessagesMessage, chat *msg
 	return m.UnsubscribeCallback(chat, s[len(s)-1])
 }
 
+func (m *Messenger) processBridge(message object.MessagesMessage, chat *msg.Chat) error {
+	s := strings.Split(message.Text, " ")
+	return m.BridgeCallback(chat, s[len(s)-1])
+}
+
+func (m *Messenger) processFilter(message object.MessagesMessage, chat *msg.Chat) error {
+	args := strings.TrimPrefix(message.Text, "/filter")
+	reply, err := m.ProcessFilterCommand(chat, args)
+	if err != nil {
+		return err
+	}
+	_, err = m.SendMessage(context.TODO(), &msg.SendMessageRequest{
+		Message: &msg.Message{Text: reply},
+		Chat:    chat,
+	})
+	return err
+}
+
+func (m *Messenger) processTemplate(message object.MessagesMessage, chat *msg.Chat) error {
+	args := strings.TrimPrefix(message.Text, "/template")
+	reply, err := m.ProcessTemplateCommand(chat, args)
+	if err != nil {
+		return err
+	}
+	_, err = m.SendMessage(context.TODO(), &msg.SendMessageRequest{
+		Message: &msg.Message{Text: reply},
+		Chat:    chat,
+	})
+	return err
+}
+
+func (m *Messenger) processPhotos(message object.MessagesMessage, chat *msg.Chat) error {
+	args := strings.TrimPrefix(message.Text, "/photos")
+	reply, err := m.ProcessPhotosCommand(chat, args)
+	if err != nil {
+		return err
+	}
+	_, err = m.SendMessage(context.TODO(), &msg.SendMessageRequest{
+		Message: &msg.Message{Text: reply},
+		Chat:    chat,
+	})
+	return err
+}
+
+func (m *Messenger) processFailed(_ object.MessagesMessage, chat *msg.Chat) error {
+	reply, err := m.ProcessFailedCommand(chat)
+	if err != nil {
+		return err
+	}
+	_, err = m.SendMessage(context.TODO(), &msg.SendMessageRequest{
+		Message: &msg.Message{Text: reply},
+		Chat:    chat,
+	})
+	return err
+}
+
+// isOwnMessage checks if the message was sent by the bot community itself, e.g. it is a forwarded copy
+func (m *Messenger) isOwnMessage(message object.MessagesMessage) bool {
+	return bool(message.Out) || message.FromID == -m.groupID
+}
+
+// processDelete deletes the message the command replies to together with all its forwarded copies.
+// VK does not notify bots about deleted messages, so the deletion has to be requested explicitly.
+func (m *Messenger) processDelete(message object.MessagesMessage, chat *msg.Chat) error {
+	if message.ReplyMessage == nil {
+		return status.Error(codes.InvalidArgument, "reply to the message which should be deleted")
+	}
+	isAdmin, err := m.isChatAdmin(message.PeerID, message.FromID)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return status.Error(codes.PermissionDenied, "only chat administrators can delete messages")
+	}
+	if err = m.DeletedMessageCallback(chat, getMessageID(*message.ReplyMessage)); err != nil {
+		return err
+	}
+	// the bot may have no rights to delete messages here, but the copies are already deleted anyway
+	_, err = m.vk.MessagesDelete(api.Params{
+		"peer_id":        message.PeerID,
+		"cmids":          []int{message.ReplyMessage.ConversationMessageID, message.ConversationMessageID},
+		"delete_for_all": true,
+	})
+	if err != nil {
+		log.Printf("could not delete vk messages: %v", err)
+	}
+	return nil
+}
+
+func (m *Messenger) isChatAdmin(peerID, userID int) (bool, error) {
+	// peer ids of group chats start from this value, other conversations are private
+	const chatPeerIDStart = 2000000000
+	if peerID < chatPeerIDStart {
+		return true, nil
+	}
+	members, err := m.vk.MessagesGetConversationMembers(api.Params{"peer_id": peerID})
+	if err != nil {
+		return false, fmt.Errorf("could not get conversation members: %w", err)
+	}
+	for _, member := range members.Items {
+		if member.MemberID == userID {
+			return bool(member.IsAdmin || member.IsOwner), nil
+		}
+	}
+	return false, nil
+}
+
 func (m *Messenger) processWall(wall object.WallWallpost, chat *msg.Chat) error {
 	message := msg.Message{
 		Text: wall.Text,
@@ -164,9 +365,15 @@ func (m *Messenger) processWall(wall object.WallWallpost, chat *msg.Chat) error
 		},
 	}
 
+	var files []messenger.RemoteFile
 	for _, attachment := range wall.Attachments {
 		if attachment.Type == "photo" {
-			message.Attachments = m.processPhoto(attachment.Photo, chat.Id, message.Attachments)
+			files = append(files, getPhotoFile(attachment.Photo))
+		}
+	}
+	for _, attachment := range m.downloadVKFiles(files) {
+		if attachment != nil {
+			message.Attachments = append(message.Attachments, attachment)
 		}
 	}
 	return m.MessageCallback(&message, chat)
@@ -204,33 +411,72 @@ func (m *Messenger) getUserNameByID(id int) (string, error) {
 	return userResponse[0].FirstName + " " + userResponse[0].LastName, nil
 }
 
-func (m *Messenger) processPhoto(photo object.PhotosPhoto, chatID int64, attachments []*msg.Attachment) []*msg.Attachment {
+func getPhotoFile(photo object.PhotosPhoto) messenger.RemoteFile {
 	url := photo.MaxSize().URL
-	ext := filepath.Ext(url)
-	attachment := downloadVKFile(url, photo.ID, chatID, ext, "photo")
-	if attachment != nil {
-		return append(attachments, attachment)
+	return messenger.RemoteFile{URL: url, Name: strconv.Itoa(photo.ID) + filepath.Ext(url), Type: "photo"}
+}
+
+// getVideoFile returns the video file of the best available quality up to 720p.
+// Bots usually have no access to the files of videos, false is returned then
+func getVideoFile(video object.VideoVideo) (messenger.RemoteFile, bool) {
+	for _, url := range []string{video.Files.Mp4_720, video.Files.Mp4_480, video.Files.Mp4_1080,
+		video.Files.Mp4_360, video.Files.Mp4_240} {
+		if url != "" {
+			return messenger.RemoteFile{URL: url, Name: video.Title + ".mp4", Type: "video"}, true
+		}
 	}
-	return attachments
+	return messenger.RemoteFile{}, false
 }
 
-func (m *Messenger) processDocument(document object.DocsDoc, chatID int64, attachments []*msg.Attachment) []*msg.Attachment {
-	attachment := downloadVKFile(document.URL, document.ID, chatID, document.Title, "doc")
-	if attachment != nil {
-		return append(attachments, attachment)
+func getAudioFile(audio object.AudioAudio) (messenger.RemoteFile, bool) {
+	if audio.URL == "" {
+		return messenger.RemoteFile{}, false
 	}
-	return attachments
+	return messenger.RemoteFile{URL: audio.URL, Name: audio.Artist + " - " + audio.Title + ".mp3", Type: "audio"}, true
 }
 
-func downloadVKFile(url string, fileID int, chatID int64, fileTitle string, attachmentType string) *msg.Attachment {
-	path := fmt.Sprintf("/transferbot/data/downloads/vk/%d/%d/%s", chatID, fileID, fileTitle)
-	err := DownloadFile(path, url)
-	if err != nil {
-		log.Print("could not download vk", attachmentType, ": ", err)
-		return nil
+func getAudioMessageFile(audioMessage object.DocsDoc) messenger.RemoteFile {
+	if audioMessage.LinkOgg == "" {
+		return messenger.RemoteFile{URL: audioMessage.LinkMp3, Name: "voice.mp3", Type: "voice"}
+	}
+	return messenger.RemoteFile{URL: audioMessage.LinkOgg, Name: "voice.ogg", Type: "voice"}
+}
+
+func getStickerFile(sticker object.BaseSticker) messenger.RemoteFile {
+	// sticker images are in png format, their urls have no extensions
+	return messenger.RemoteFile{
+		URL:  sticker.MaxSize().URL,
+		Name: strconv.Itoa(sticker.StickerID) + ".png",
+		Type: "sticker",
 	}
-	return &msg.Attachment{
-		Type: attachmentType,
-		Url:  path,
+}
+
+// addLink adds the link to the text of the message on a new line
+func addLink(message *msg.Message, link string) {
+	if message.Text != "" {
+		message.Text += "\n"
+	}
+	message.Text += link
+}
+
+func getPoll(poll object.PollsPoll) *msg.Poll {
+	res := &msg.Poll{
+		Question:        poll.Question,
+		MultipleAnswers: bool(poll.Multiple),
+		Anonymous:       bool(poll.Anonymous),
 	}
+	for _, answer := range poll.Answers {
+		res.Options = append(res.Options, answer.Text)
+	}
+	return res
+}
+
+// downloadVKFiles downloads the files concurrently and returns attachments in the same order.
+// Attachments of files which could not be downloaded are nil
+func (m *Messenger) downloadVKFiles(files []messenger.RemoteFile) []*msg.Attachment {
+	attachments, err := m.DownloadFiles(files)
+	if err != nil {
+		log.Printf("could not download vk attachments: %v", err)
+	}
+	return attachments
 }
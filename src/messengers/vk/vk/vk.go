@@ -8,6 +8,7 @@ import (
 	"github.com/SevereCloud/vksdk/v2/api"
 	"github.com/SevereCloud/vksdk/v2/events"
 	"github.com/SevereCloud/vksdk/v2/longpoll-bot"
+	"github.com/SevereCloud/vksdk/v2/object"
 	"log"
 )
 
@@ -15,6 +16,7 @@ type Messenger struct {
 	*messenger.BaseMessenger
 	vk       *api.VK
 	longPoll *longpoll.LongPoll
+	groupID  int
 }
 
 func NewMessenger(baseMessenger *messenger.BaseMessenger) (*Messenger, error) {
@@ -27,6 +29,7 @@ func NewMessenger(baseMessenger *messenger.BaseMessenger) (*Messenger, error) {
 	newMessenger := &Messenger{
 		BaseMessenger: baseMessenger,
 		vk:            vk,
+		groupID:       group[0].ID,
 	}
 	err = newMessenger.initLongPoll(group[0].ID)
 	if err != nil {
@@ -41,9 +44,25 @@ func (m *Messenger) initLongPoll(groupID int) error {
 		return err
 	}
 	m.longPoll = lp
+	lp.MessageEdit(func(ctx context.Context, obj events.MessageEditObject) {
+		message := object.MessagesMessage(obj)
+		if m.isOwnMessage(message) {
+			return
+		}
+		log.Printf("edited message: conversation message id: %d; chat id: %d",
+			message.ConversationMessageID, message.PeerID)
+		chat := msg.Chat{
+			Id:   int64(message.PeerID),
+			Type: "vk",
+			Name: "vk",
+		}
+		if err := m.processEditedMessage(message, &chat); err != nil {
+			log.Printf("error processing edited message: %v", err)
+		}
+	})
 	lp.MessageNew(func(ctx context.Context, obj events.MessageNewObject) {
 		m.logUpdate(&obj)
-		if obj.Message.Action.Type != "" {
+		if obj.Message.Action.Type != "" || m.isOwnMessage(obj.Message) {
 			return
 		}
 		chat := msg.Chat{
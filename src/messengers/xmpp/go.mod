@@ -0,0 +1,30 @@
+module github.com/Pelmenner/TransferBot/xmpp
+
+go 1.24.0
+
+replace github.com/Pelmenner/TransferBot/messenger v0.0.0 => ../messenger
+
+replace github.com/Pelmenner/TransferBot/proto v0.0.0 => ../../proto
+
+require (
+	github.com/Pelmenner/TransferBot/messenger v0.0.0
+	github.com/Pelmenner/TransferBot/proto v0.0.0
+	github.com/golang/protobuf v1.5.3
+	google.golang.org/grpc v1.56.3
+	mellium.im/sasl v0.3.2
+	mellium.im/xmlstream v0.15.4
+	mellium.im/xmpp v0.22.0
+)
+
+require (
+	golang.org/x/crypto v0.46.0 // indirect
+	golang.org/x/mod v0.30.0 // indirect
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sync v0.19.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+	golang.org/x/tools v0.39.0 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+	mellium.im/reader v0.1.0 // indirect
+)
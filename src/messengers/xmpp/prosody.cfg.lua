@@ -0,0 +1,35 @@
+-- Configuration of the local Prosody server run by docker-compose.prosody.yml, it is only meant for testing
+
+modules_enabled = {
+	"roster";
+	"saslauth";
+	"tls";
+	"disco";
+	"carbons";
+	"pep";
+	"ping";
+	"register";
+	"mam";
+}
+
+-- the bot and test users are registered with any client
+allow_registration = true
+c2s_require_encryption = true
+authentication = "internal_hashed"
+-- the self-signed certificate of localhost generated when Prosody is installed
+certificates = "certs"
+
+log = { info = "*console" }
+
+-- uploaded files are served to the bot by the name of the container,
+-- clients outside of Docker need this name to be resolved to 127.0.0.1
+http_ports = { 5280 }
+http_interfaces = { "*" }
+http_external_url = "http://prosody:5280/"
+
+VirtualHost "localhost"
+
+Component "conference.localhost" "muc"
+
+Component "upload.localhost" "http_file_share"
+	http_file_share_size_limit = 10 * 1024 * 1024
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/Pelmenner/TransferBot/xmpp/xmpp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"log"
+	"net"
+)
+
+func main() {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", xmpp.Config.Port))
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
+	xmppMessenger := createMessenger()
+	go xmppMessenger.Run(context.Background())
+	grpcServer := grpc.NewServer()
+	msg.RegisterChatServiceServer(grpcServer, xmppMessenger)
+
+	log.Printf("initializing gRPC server on port %d", xmpp.Config.Port)
+	if err = grpcServer.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
+
+func createMessenger() *xmpp.Messenger {
+	connection, err := grpc.Dial(xmpp.Config.ControllerHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("could not connect to controller on %s", xmpp.Config.ControllerHost)
+	}
+	xmppMessenger, err := xmpp.NewMessenger(messenger.NewBaseMessenger(connection))
+	if err != nil {
+		log.Fatalf("could not create messenger: %v", err)
+	}
+	log.Printf("connected to controller on %s", xmpp.Config.ControllerHost)
+	return xmppMessenger
+}
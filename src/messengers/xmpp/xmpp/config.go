@@ -0,0 +1,74 @@
+package xmpp
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"mellium.im/xmpp/jid"
+)
+
+var Config = struct {
+	// JID is the address of the account of the bot, e.g. transferbot@example.com
+	JID      jid.JID
+	Password string
+	// Server is the host and the port to connect to, e.g. prosody:5222.
+	// If it is empty, the server is looked up by the domain of the JID
+	Server string
+	// TLSSkipVerify turns off verification of certificates of the server, e.g. self-signed ones of a local server
+	TLSSkipVerify bool
+	// Rooms are addresses of MUC rooms joined after connecting, e.g. "chat@conference.example.com".
+	// Rooms the bot is invited to are joined as well
+	Rooms []jid.JID
+	// Nick is the name of the bot in rooms
+	Nick string
+	// UploadService is the address of the HTTP upload service, it is discovered on the server if it is empty
+	UploadService  string
+	Port           int
+	ControllerHost string
+}{
+	Password:       os.Getenv("XMPP_PASSWORD"),
+	Server:         os.Getenv("XMPP_SERVER"),
+	TLSSkipVerify:  os.Getenv("XMPP_TLS_SKIP_VERIFY") == "true",
+	Nick:           os.Getenv("XMPP_NICK"),
+	UploadService:  os.Getenv("XMPP_UPLOAD_SERVICE"),
+	ControllerHost: os.Getenv("CONTROLLER_HOST"),
+}
+
+func init() {
+	address := os.Getenv("XMPP_JID")
+	if len(address) == 0 {
+		log.Panic("XMPP JID not provided")
+	}
+	var err error
+	Config.JID, err = jid.Parse(address)
+	if err != nil {
+		log.Panicf("Invalid XMPP JID: %v", err)
+	}
+	if len(Config.Password) == 0 {
+		log.Panic("XMPP password not provided")
+	}
+	if len(Config.Nick) == 0 {
+		Config.Nick = "transferbot"
+	}
+	for _, room := range strings.Split(os.Getenv("XMPP_ROOMS"), ",") {
+		if room = strings.TrimSpace(room); room == "" {
+			continue
+		}
+		parsed, err := jid.Parse(room)
+		if err != nil {
+			log.Panicf("Invalid XMPP room %s: %v", room, err)
+		}
+		Config.Rooms = append(Config.Rooms, parsed.Bare())
+	}
+}
+
+func init() {
+	port := os.Getenv("PORT")
+	var err error
+	Config.Port, err = strconv.Atoi(port)
+	if err != nil {
+		log.Panic("Invalid XMPP service port")
+	}
+}
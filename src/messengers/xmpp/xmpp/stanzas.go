@@ -0,0 +1,122 @@
+package xmpp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// namespaces of extensions whose fallbacks are handled
+const (
+	// nsRetraction is the namespace of message retractions, XEP-0424
+	nsRetraction = "urn:xmpp:message-retract:1"
+	// nsReply is the namespace of message replies, XEP-0461
+	nsReply = "urn:xmpp:reply:0"
+)
+
+var (
+	bodyName    = xml.Name{Space: stanza.NSClient, Local: "body"}
+	retractName = xml.Name{Space: nsRetraction, Local: "retract"}
+)
+
+// reference is a payload referring to another message by its id
+type reference struct {
+	ID string `xml:"id,attr"`
+}
+
+// replyReference is a reply to a message sent by the user with the address
+type replyReference struct {
+	To jid.JID `xml:"to,attr,omitempty"`
+	ID string  `xml:"id,attr"`
+}
+
+// fallback marks the part of the body which is only shown by clients not supporting the extension
+type fallback struct {
+	For  string `xml:"for,attr"`
+	Body *struct {
+		Start *int `xml:"start,attr"`
+		End   *int `xml:"end,attr"`
+	} `xml:"body,omitempty"`
+}
+
+// oob is out of band data, XEP-0066, files uploaded with HTTP upload are shared with it
+type oob struct {
+	URL string `xml:"url"`
+}
+
+// incomingMessage is a message stanza with the payloads the bot handles
+type incomingMessage struct {
+	stanza.Message
+	Body      string          `xml:"body"`
+	Replace   *reference      `xml:"urn:xmpp:message-correct:0 replace"`
+	Retract   *reference      `xml:"urn:xmpp:message-retract:1 retract"`
+	Reply     *replyReference `xml:"urn:xmpp:reply:0 reply"`
+	Fallbacks []fallback      `xml:"urn:xmpp:fallback:0 fallback"`
+	OOB       []oob           `xml:"jabber:x:oob x"`
+	Unstyled  *struct{}       `xml:"urn:xmpp:styling:0 unstyled"`
+	Delay     *struct{}       `xml:"urn:xmpp:delay delay"`
+	// MUCUser is sent in mediated invitations, DirectInvite in direct ones, XEP-0249
+	MUCUser *struct {
+		Invite *struct{} `xml:"invite"`
+	} `xml:"http://jabber.org/protocol/muc#user x"`
+	DirectInvite *struct {
+		JID jid.JID `xml:"jid,attr"`
+	} `xml:"jabber:x:conference x"`
+}
+
+// isInvite checks if the message is an invitation to a room
+func (message *incomingMessage) isInvite() bool {
+	return (message.MUCUser != nil && message.MUCUser.Invite != nil) || message.DirectInvite != nil
+}
+
+// splitFallback returns the body without the fallback of the extension along with the fallback.
+// Positions of fallbacks are counted in characters
+func (message *incomingMessage) splitFallback(namespace string) (string, string) {
+	for _, fallback := range message.Fallbacks {
+		if fallback.For != namespace || fallback.Body == nil {
+			continue
+		}
+		runes := []rune(message.Body)
+		start, end := 0, len(runes)
+		if fallback.Body.Start != nil {
+			start = *fallback.Body.Start
+		}
+		if fallback.Body.End != nil {
+			end = *fallback.Body.End
+		}
+		if start < 0 || start > end || end > len(runes) {
+			return message.Body, ""
+		}
+		return string(runes[:start]) + string(runes[end:]), string(runes[start:end])
+	}
+	return message.Body, ""
+}
+
+// outgoingMessage is a message stanza sent by the bot. It does not embed stanza.Message,
+// which would add an empty from attribute
+type outgoingMessage struct {
+	XMLName  xml.Name           `xml:"message"`
+	ID       string             `xml:"id,attr"`
+	To       string             `xml:"to,attr"`
+	Type     stanza.MessageType `xml:"type,attr"`
+	Body     string             `xml:"body,omitempty"`
+	Replace  *reference         `xml:"urn:xmpp:message-correct:0 replace,omitempty"`
+	Retract  *reference         `xml:"urn:xmpp:message-retract:1 retract,omitempty"`
+	Reply    *reference         `xml:"urn:xmpp:reply:0 reply,omitempty"`
+	Fallback *fallback          `xml:"urn:xmpp:fallback:0 fallback,omitempty"`
+	OOB      *oob               `xml:"jabber:x:oob x,omitempty"`
+	Unstyled *struct{}          `xml:"urn:xmpp:styling:0 unstyled,omitempty"`
+	Store    *struct{}          `xml:"urn:xmpp:hints store,omitempty"`
+}
+
+// newID returns a random id of a stanza
+func newID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,60 @@
+package xmpp
+
+import (
+	"github.com/Pelmenner/TransferBot/messenger"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+)
+
+// stylingDelimiters are marks of message styling, XEP-0393. Code is parsed by messenger.ParseMarkup itself
+var stylingDelimiters = []messenger.Delimiter{
+	{Mark: "*", Type: "bold"},
+	{Mark: "_", Type: "italic"},
+	{Mark: "~", Type: "strikethrough"},
+}
+
+// parseStyling returns the text of a message without styling marks along with its formatting.
+// Styling has no escapes, so marks which are not closed are left in the text
+func parseStyling(text string) (string, []*msg.TextEntity) {
+	return messenger.ParseMarkup(text, messenger.MarkupSyntax{
+		Delimiters:    stylingDelimiters,
+		Quotes:        true,
+		CodeLanguages: true,
+	})
+}
+
+// messageText returns the text of the message with styling marks, it is already formatted by the controller
+func messageText(message *msg.Message) string {
+	return messenger.FormatText(message.Text, message.Entities, stylingMarkup, keepText)
+}
+
+// stylingMarkup marks formatted parts of texts with styling marks. Styling has no links, underline and spoilers,
+// links are shown with their targets and the rest is left as it is
+func stylingMarkup(entity *msg.TextEntity, content string) (string, string) {
+	switch entity.Type {
+	case "bold":
+		return "*", "*"
+	case "italic":
+		return "_", "_"
+	case "strikethrough":
+		return "~", "~"
+	case "code":
+		return "`", "`"
+	case "pre":
+		return "```" + entity.Language + "\n", "\n```"
+	case "link":
+		if content == entity.Url {
+			return "", ""
+		}
+		return "", " (" + entity.Url + ")"
+	case "blockquote":
+		return "> ", ""
+	}
+	return "", ""
+}
+
+// keepText is the escape function of styling, which can not escape its marks.
+// Messages without formatting are sent with a hint not to style them instead
+func keepText(text string, _ bool) string {
+	return text
+}
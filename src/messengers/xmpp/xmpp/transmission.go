@@ -0,0 +1,270 @@
+package xmpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	xmppapi "mellium.im/xmpp"
+	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/disco/items"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+	"mellium.im/xmpp/upload"
+)
+
+// messageMaxLength is the length of texts in bytes, longer messages are split.
+// Servers limit the size of stanzas, e.g. Prosody accepts stanzas up to 256 KiB by default
+const messageMaxLength = 32 * 1024
+
+// retractionFallback is the body of retractions shown by clients which do not support them
+const retractionFallback = "This person attempted to retract a previous message, but it's unsupported by your client."
+
+var errNotConnected = errors.New("not connected to the xmpp server")
+
+// SendMessage sends the text of the message and uploads its files with HTTP upload. Files are shared
+// in separate messages with their urls, clients show them in place of the urls
+func (m *Messenger) SendMessage(ctx context.Context, request *msg.SendMessageRequest) (*msg.SendMessageResponse, error) {
+	to, messageType, err := m.destination(ctx, request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &msg.SendMessageResponse{}, status.Error(codes.NotFound, "unknown chat")
+	}
+	if session, _ := m.getSession(); session == nil {
+		return &msg.SendMessageResponse{}, status.Error(codes.Unavailable, errNotConnected.Error())
+	}
+	cleanup, err := m.DownloadAttachments(ctx, request.Message.Attachments)
+	if err != nil {
+		log.Printf("could not download attachments: %v", err)
+		return &msg.SendMessageResponse{}, status.Error(codes.Unknown, "could not download attachments")
+	}
+	defer cleanup()
+
+	message := request.Message
+	var reply *reference
+	if message.ReplyTo != nil && message.ReplyTo.MessageId != "" {
+		reply = &reference{ID: message.ReplyTo.MessageId}
+	}
+	response := &msg.SendMessageResponse{}
+	for _, text := range messenger.SplitText(prepareText(message), messageMaxLength, utf8.RuneLen) {
+		id, err := m.send(ctx, outgoingMessage{
+			To:       to.String(),
+			Type:     messageType,
+			Body:     text,
+			Reply:    reply,
+			Unstyled: unstyled(message),
+		})
+		if err != nil {
+			log.Printf("could not send xmpp message: %v", err)
+			return messenger.SendFailed(response, "could not send the message")
+		}
+		// only the first part replies to the message
+		reply = nil
+		response.MessageIds = append(response.MessageIds, id)
+	}
+	for _, attachment := range message.Attachments {
+		id, err := m.sendFile(ctx, to, messageType, attachment)
+		if err != nil {
+			log.Printf("error sending file of type %s: %v", attachment.Type, err)
+			continue
+		}
+		response.MessageIds = append(response.MessageIds, id)
+	}
+	return response, nil
+}
+
+// EditMessage corrects the first message, the rest of the parts of a long message and files are left as they are
+func (m *Messenger) EditMessage(ctx context.Context, request *msg.EditMessageRequest) (*empty.Empty, error) {
+	to, messageType, err := m.destination(ctx, request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.NotFound, "unknown chat")
+	}
+	if len(request.MessageIds) == 0 {
+		return &empty.Empty{}, status.Error(codes.InvalidArgument, "no messages to edit")
+	}
+	text := ""
+	if parts := messenger.SplitText(prepareText(request.Message), messageMaxLength, utf8.RuneLen); len(parts) > 0 {
+		text = parts[0]
+	}
+	_, err = m.send(ctx, outgoingMessage{
+		To:       to.String(),
+		Type:     messageType,
+		Body:     text,
+		Replace:  &reference{ID: request.MessageIds[0]},
+		Unstyled: unstyled(request.Message),
+	})
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.Unknown, "could not edit the message")
+	}
+	return &empty.Empty{}, nil
+}
+
+// DeleteMessage retracts the messages, uploaded files stay on the server until it removes them
+func (m *Messenger) DeleteMessage(ctx context.Context, request *msg.DeleteMessageRequest) (*empty.Empty, error) {
+	to, messageType, err := m.destination(ctx, request.Chat)
+	if err != nil {
+		log.Print(err)
+		return &empty.Empty{}, status.Error(codes.NotFound, "unknown chat")
+	}
+	for _, id := range request.MessageIds {
+		_, err = m.send(ctx, outgoingMessage{
+			To:       to.String(),
+			Type:     messageType,
+			Body:     retractionFallback,
+			Retract:  &reference{ID: id},
+			Fallback: &fallback{For: nsRetraction},
+			Store:    &struct{}{},
+		})
+		if err != nil {
+			log.Print(err)
+			return &empty.Empty{}, status.Error(codes.Unknown, "could not delete the message")
+		}
+	}
+	return &empty.Empty{}, nil
+}
+
+// prepareText returns the message text with styling and the content XMPP can not show natively added to it
+func prepareText(message *msg.Message) string {
+	var text []string
+	for _, part := range []string{messageText(message), messenger.ContentText(message)} {
+		if strings.TrimSpace(part) != "" {
+			text = append(text, part)
+		}
+	}
+	return strings.Join(text, "\n\n")
+}
+
+// unstyled returns the hint not to style the text, if the message has no formatting.
+// Styling marks can not be escaped, so plain texts could be styled by clients otherwise
+func unstyled(message *msg.Message) *struct{} {
+	if len(message.Entities) > 0 {
+		return nil
+	}
+	return &struct{}{}
+}
+
+// send sends the message with a new id and returns the id
+func (m *Messenger) send(ctx context.Context, message outgoingMessage) (string, error) {
+	session, _ := m.getSession()
+	if session == nil {
+		return "", errNotConnected
+	}
+	message.ID = newID()
+	return message.ID, session.Encode(ctx, message)
+}
+
+// sendFile uploads the local file of the attachment and shares its url
+func (m *Messenger) sendFile(ctx context.Context, to jid.JID, messageType stanza.MessageType,
+	attachment *msg.Attachment) (string, error) {
+	link, err := m.upload(ctx, attachment)
+	if err != nil {
+		return "", err
+	}
+	return m.send(ctx, outgoingMessage{
+		To:   to.String(),
+		Type: messageType,
+		Body: link,
+		OOB:  &oob{URL: link},
+	})
+}
+
+// upload uploads the local file of the attachment to the HTTP upload service, XEP-0363, and returns its url
+func (m *Messenger) upload(ctx context.Context, attachment *msg.Attachment) (string, error) {
+	m.sessionMutex.RLock()
+	session, service := m.session, m.uploadService
+	m.sessionMutex.RUnlock()
+	if session == nil {
+		return "", errNotConnected
+	}
+	if service.Equal(jid.JID{}) {
+		return "", errors.New("the server has no http upload service")
+	}
+	file, err := os.Open(attachment.Url)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	name := attachment.Name
+	if name == "" {
+		name = filepath.Base(attachment.Url)
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	slot, err := upload.GetSlot(ctx, upload.File{Name: name, Size: int(info.Size()), Type: mimeType}, service, session)
+	if err != nil {
+		return "", fmt.Errorf("could not get upload slot: %w", err)
+	}
+	request, err := slot.Put(ctx, file)
+	if err != nil {
+		return "", err
+	}
+	if request.Header == nil {
+		request.Header = make(http.Header)
+	}
+	request.Header.Set("Content-Type", mimeType)
+	request.ContentLength = info.Size()
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("upload failed with status %s", response.Status)
+	}
+	return slot.GetURL.String(), nil
+}
+
+// findUploadService returns the configured HTTP upload service or looks for one among the services of the server
+func findUploadService(ctx context.Context, session *xmppapi.Session) (jid.JID, error) {
+	if Config.UploadService != "" {
+		return jid.Parse(Config.UploadService)
+	}
+	domain := session.LocalAddr().Domain()
+	candidates := []jid.JID{domain}
+	iter := disco.FetchItems(ctx, items.Item{JID: domain}, session)
+	for iter.Next() {
+		candidates = append(candidates, iter.Item().JID)
+	}
+	err := iter.Err()
+	// the iterator has to be closed before the session is used again
+	if closeErr := iter.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return jid.JID{}, err
+	}
+	for _, candidate := range candidates {
+		info, err := disco.GetInfo(ctx, "", candidate, session)
+		if err != nil {
+			log.Printf("could not get info of %s: %v", candidate, err)
+			continue
+		}
+		for _, feature := range info.Features {
+			if feature.Var == upload.NS {
+				log.Printf("found http upload service %s", candidate)
+				return candidate, nil
+			}
+		}
+	}
+	return jid.JID{}, errors.New("no service supports http upload")
+}
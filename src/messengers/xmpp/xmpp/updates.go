@@ -0,0 +1,324 @@
+package xmpp
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// onMessage takes a ticket for a message with a body and processes it concurrently, so that the session
+// is not blocked. Errors are logged instead of being returned, since they would end the session
+func (m *Messenger) onMessage(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+	message, ok := decodeMessage(r)
+	// retractions have fallback bodies, they are handled by onRetraction
+	if !ok || message.Retract != nil || message.isInvite() || m.skip(message) {
+		return nil
+	}
+	m.take(message)
+	return nil
+}
+
+func (m *Messenger) onRetraction(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+	message, ok := decodeMessage(r)
+	if !ok || m.skip(message) {
+		return nil
+	}
+	m.take(message)
+	return nil
+}
+
+// onInvite joins the room of a mediated or a direct invitation
+func (m *Messenger) onInvite(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+	message, ok := decodeMessage(r)
+	if !ok || !message.isInvite() {
+		return nil
+	}
+	room := message.From
+	if message.DirectInvite != nil {
+		room = message.DirectInvite.JID
+	}
+	log.Printf("invited to room %s", room.Bare())
+	go m.join(context.Background(), room)
+	return nil
+}
+
+func decodeMessage(r xml.TokenReader) (*incomingMessage, bool) {
+	var message incomingMessage
+	if err := xml.NewTokenDecoder(r).Decode(&message); err != nil {
+		log.Printf("could not decode xmpp message: %v", err)
+		return nil, false
+	}
+	return &message, true
+}
+
+// skip checks if the message should not be transferred: messages of the bot reflected by rooms,
+// messages sent before the bot joined and private messages of participants of rooms
+func (m *Messenger) skip(message *incomingMessage) bool {
+	if message.Delay != nil || message.From.Bare().Equal(Config.JID.Bare()) {
+		return true
+	}
+	if message.Type == stanza.GroupChatMessage {
+		return message.From.Resourcepart() == "" || message.From.Resourcepart() == Config.Nick
+	}
+	return m.isRoom(message.From)
+}
+
+func (m *Messenger) take(message *incomingMessage) {
+	chatID := m.chats.Add(message.From.Bare().String())
+	go m.processUpdate(message, m.sequencer.Ticket(chatID))
+}
+
+// processUpdate handles a message and marks its ticket as done once it is passed to the controller
+func (m *Messenger) processUpdate(message *incomingMessage, ticket *messenger.Ticket) {
+	defer ticket.Done()
+	chat := m.chat(message.From, message.Type == stanza.GroupChatMessage)
+	switch {
+	case message.Retract != nil:
+		ticket.Wait()
+		m.processDeletedMessage(message, chat)
+	case message.Replace != nil:
+		ticket.Wait()
+		m.processEditedMessage(message, chat)
+	default:
+		if strings.HasPrefix(message.Body, "!") {
+			ticket.Wait()
+			err := m.processCommand(message, chat)
+			if err != errCommandNotFound {
+				if err != nil {
+					log.Printf("error processing command: %v", err)
+				}
+				return
+			}
+		}
+		m.processMessage(message, chat, ticket)
+	}
+}
+
+// processMessage handles a new message. Files are downloaded concurrently with other updates,
+// but the message is passed to the controller only when its ticket is up.
+func (m *Messenger) processMessage(message *incomingMessage, chat *msg.Chat, ticket *messenger.Ticket) {
+	const template = "new message: from: %s; id: %s; type: %s; reply: %t"
+	log.Printf(template, message.From, message.ID, message.Type, message.Reply != nil)
+	body, quote := message.splitFallback(nsReply)
+	files := getFiles(message)
+	if len(files) > 0 {
+		// the body of a shared file is its url
+		body = ""
+	}
+	text, entities := parseBody(message, body)
+	standardMessage := msg.Message{
+		Id:       message.ID,
+		Text:     text,
+		Entities: entities,
+		Sender:   getSender(message.From, chat),
+		ReplyTo:  getReply(message, quote, chat),
+	}
+	attachments, err := m.DownloadFiles(files)
+	if err != nil {
+		log.Printf("could not download xmpp files: %v", err)
+	}
+	for _, attachment := range attachments {
+		if attachment != nil {
+			standardMessage.Attachments = append(standardMessage.Attachments, attachment)
+		}
+	}
+	ticket.Wait()
+	if err = m.MessageCallback(&standardMessage, chat); err != nil {
+		log.Printf("error processing message: %v", err)
+	}
+}
+
+// processEditedMessage handles a correction of a message, the corrected text replaces the text of the message
+func (m *Messenger) processEditedMessage(message *incomingMessage, chat *msg.Chat) {
+	log.Printf("edited message: from: %s; id: %s", message.From, message.Replace.ID)
+	body, _ := message.splitFallback(nsReply)
+	text, entities := parseBody(message, body)
+	standardMessage := msg.Message{
+		Id:       message.Replace.ID,
+		Text:     text,
+		Entities: entities,
+		Sender:   getSender(message.From, chat),
+	}
+	if err := m.EditedMessageCallback(&standardMessage, chat); err != nil {
+		log.Printf("error processing edited message: %v", err)
+	}
+}
+
+func (m *Messenger) processDeletedMessage(message *incomingMessage, chat *msg.Chat) {
+	log.Printf("deleted message: from: %s; id: %s", message.From, message.Retract.ID)
+	if err := m.DeletedMessageCallback(chat, message.Retract.ID); err != nil {
+		log.Printf("error processing deleted message: %v", err)
+	}
+}
+
+// parseBody returns the text of the body along with its formatting. Actions are shown after the name of the sender
+func parseBody(message *incomingMessage, body string) (string, []*msg.TextEntity) {
+	if action, isAction := strings.CutPrefix(body, "/me "); isAction {
+		body = "* " + action
+	}
+	if message.Unstyled != nil {
+		return body, nil
+	}
+	return parseStyling(body)
+}
+
+// getSender returns the sender of a message, senders in rooms are known by their nicks
+func getSender(from jid.JID, chat *msg.Chat) *msg.Sender {
+	name := from.Resourcepart()
+	if chat.Name == "xmpp" {
+		name = from.Localpart()
+	}
+	if name == "" {
+		name = from.Bare().String()
+	}
+	return &msg.Sender{
+		Name: name,
+		Chat: &msg.Chat{Name: chat.Name},
+	}
+}
+
+// getReply returns the message the message replies to. Its text is taken from the quote clients add
+// for the ones not supporting replies, if there is one
+func getReply(message *incomingMessage, quote string, chat *msg.Chat) *msg.Reply {
+	if message.Reply == nil {
+		return nil
+	}
+	reply := &msg.Reply{MessageId: message.Reply.ID}
+	if !message.Reply.To.Equal(jid.JID{}) {
+		reply.Sender = getSender(message.Reply.To, chat)
+	}
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(quote), "\n") {
+		if line, quoted := strings.CutPrefix(line, ">"); quoted {
+			lines = append(lines, strings.TrimPrefix(line, " "))
+		}
+	}
+	reply.Text = strings.Join(lines, "\n")
+	return reply
+}
+
+// getFiles returns files shared with out of band data. Files are only taken from messages whose body
+// is the url of the file, otherwise the url is a part of the text
+func getFiles(message *incomingMessage) []messenger.RemoteFile {
+	var files []messenger.RemoteFile
+	for _, oob := range message.OOB {
+		if oob.URL == "" || strings.TrimSpace(message.Body) != oob.URL {
+			continue
+		}
+		name := "file"
+		if parsed, err := url.Parse(oob.URL); err == nil && path.Base(parsed.Path) != "/" {
+			name = path.Base(parsed.Path)
+		}
+		files = append(files, messenger.RemoteFile{
+			URL:  oob.URL,
+			Name: name,
+			Type: getAttachmentType(name),
+		})
+	}
+	return files
+}
+
+func getAttachmentType(name string) string {
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	switch {
+	case mimeType == "image/gif":
+		return "animation"
+	case strings.HasPrefix(mimeType, "image/"):
+		return "photo"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	}
+	return "doc"
+}
+
+var errCommandNotFound = fmt.Errorf("command not found")
+
+// processCommand handles a command. Clients handle some messages starting with "/" themselves,
+// so commands start with "!", e.g. !subscribe <token>
+func (m *Messenger) processCommand(message *incomingMessage, chat *msg.Chat) error {
+	command, args := splitCommand(message.Body)
+	var reply string
+	var err error
+	switch command {
+	case "get_token":
+		reply, err = m.GetChatToken(chat.Id, chat.Type)
+	case "subscribe":
+		reply, err = m.SubscribeCallback(chat, args)
+	case "unsubscribe":
+		err = m.UnsubscribeCallback(chat, args)
+	case "bridge":
+		err = m.BridgeCallback(chat, args)
+	case "filter":
+		reply, err = m.ProcessFilterCommand(chat, args)
+	case "template":
+		reply, err = m.ProcessTemplateCommand(chat, args)
+	case "photos":
+		reply, err = m.ProcessPhotosCommand(chat, args)
+	case "failed":
+		reply, err = m.ProcessFailedCommand(chat)
+	default:
+		return errCommandNotFound
+	}
+	if err == nil && reply != "" {
+		m.sendReply(chat, reply)
+	}
+	return m.processCommandResult(err, chat)
+}
+
+// splitCommand splits the text of a command into the name of the command without its prefix and its arguments
+func splitCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)[1:]
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return text, ""
+	}
+	return text[:end], strings.TrimSpace(text[end:])
+}
+
+// processCommandResult checks if there is an error that needs to be sent to the user and sends it.
+// If the error was internal, it is returned
+//
+// It might have some messenger-specific logic in the future, so it should not be moved to baseMessenger.
+func (m *Messenger) processCommandResult(err error, chat *msg.Chat) error {
+	// If err is nil or not a grpc error, it should be returned immediately
+	if status.Code(err) == codes.OK {
+		return err
+	}
+	m.sendReply(chat, status.Convert(err).Message())
+	if !messenger.IsUserInputError(err) {
+		return err
+	}
+	return nil
+}
+
+// sendReply sends a reply to a command without styling, so that tokens and templates are shown as they are
+func (m *Messenger) sendReply(chat *msg.Chat, text string) {
+	to, messageType, err := m.destination(context.Background(), chat)
+	if err == nil {
+		_, err = m.send(context.Background(), outgoingMessage{
+			To:       to.String(),
+			Type:     messageType,
+			Body:     text,
+			Unstyled: &struct{}{},
+		})
+	}
+	if err != nil {
+		log.Printf("could not send reply: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package xmpp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"github.com/Pelmenner/TransferBot/messenger"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	msg "github.com/Pelmenner/TransferBot/proto/messenger"
+	"mellium.im/sasl"
+	xmppapi "mellium.im/xmpp"
+	"mellium.im/xmpp/dial"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/mux"
+	"mellium.im/xmpp/stanza"
+)
+
+// reconnectDelay is the delay before connecting again after the connection was lost
+const reconnectDelay = 30 * time.Second
+
+// joinTimeout limits the time of waiting for a room to be joined
+const joinTimeout = time.Minute
+
+// roomScheme and roomQuery make an XMPP URI joining the room (XEP-0147) of its address. Addresses of rooms kept by
+// the controller are such URIs, so that rooms joined on invite are told from users and joined again after a restart
+const (
+	roomScheme = "xmpp:"
+	roomQuery  = "?join"
+)
+
+type Messenger struct {
+	*messenger.BaseMessenger
+	// session is the current connection to the server, it is nil while the bot is not connected
+	session      *xmppapi.Session
+	mucClient    *muc.Client
+	sessionMutex sync.RWMutex
+	// uploadService is the address of the HTTP upload service, it is empty if the server has none
+	uploadService jid.JID
+	// rooms are bare addresses of MUC rooms which are joined again after reconnecting
+	rooms     map[string]bool
+	roomMutex sync.Mutex
+	// chats are bare addresses of rooms and users by ids of their chats
+	chats *messenger.ChatAddresses
+	// updates are processed concurrently, the sequencer keeps them in order when they are passed to the controller
+	sequencer *messenger.Sequencer
+}
+
+func NewMessenger(baseMessenger *messenger.BaseMessenger) (*Messenger, error) {
+	newMessenger := &Messenger{
+		BaseMessenger: baseMessenger,
+		rooms:         make(map[string]bool),
+		chats:         messenger.NewChatAddresses(),
+		sequencer:     messenger.NewSequencer(),
+	}
+	for _, room := range Config.Rooms {
+		newMessenger.rooms[room.String()] = true
+		newMessenger.chats.Add(room.String())
+	}
+	return newMessenger, nil
+}
+
+// Run keeps the connection to the server until the context is done
+func (m *Messenger) Run(ctx context.Context) {
+	for {
+		err := m.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("connection to xmpp server stopped: %v", err)
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// serve connects to the server and handles incoming stanzas until the connection is closed
+func (m *Messenger) serve(ctx context.Context) error {
+	session, err := connect(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		if err := session.Close(); err != nil {
+			log.Printf("could not close xmpp session: %v", err)
+		}
+	})
+	defer stop()
+	log.Printf("connected to xmpp server as %s", session.LocalAddr())
+
+	mucClient := &muc.Client{}
+	mucUser := xml.Name{Space: muc.NSUser, Local: "x"}
+	handler := mux.New(stanza.NSClient,
+		// only presences are handled by the muc client, it loses addresses of rooms of mediated invites
+		mux.Presence(stanza.AvailablePresence, mucUser, mucClient),
+		mux.Presence(stanza.UnavailablePresence, mucUser, mucClient),
+		mux.MessageFunc(stanza.NormalMessage, mucUser, m.onInvite),
+		mux.MessageFunc(stanza.NormalMessage, xml.Name{Space: muc.NSConf, Local: "x"}, m.onInvite),
+		mux.MessageFunc(stanza.GroupChatMessage, bodyName, m.onMessage),
+		mux.MessageFunc(stanza.ChatMessage, bodyName, m.onMessage),
+		mux.MessageFunc(stanza.NormalMessage, bodyName, m.onMessage),
+		mux.MessageFunc(stanza.GroupChatMessage, retractName, m.onRetraction),
+		mux.MessageFunc(stanza.ChatMessage, retractName, m.onRetraction),
+	)
+	m.setSession(session, mucClient)
+	defer m.setSession(nil, nil)
+	go m.start(ctx, session)
+	return session.Serve(handler)
+}
+
+// connect opens a session with the server, the server is looked up by the domain of the JID if it is not configured
+func connect(ctx context.Context) (*xmppapi.Session, error) {
+	var conn net.Conn
+	var err error
+	if Config.Server != "" {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", Config.Server)
+	} else {
+		dialer := dial.Dialer{TLSConfig: tlsConfig()}
+		conn, err = dialer.Dial(ctx, "tcp", Config.JID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	session, err := xmppapi.NewClientSession(ctx, Config.JID, conn,
+		xmppapi.StartTLS(tlsConfig()),
+		xmppapi.SASL("", Config.Password, sasl.ScramSha256Plus, sasl.ScramSha256, sasl.ScramSha1Plus,
+			sasl.ScramSha1, sasl.Plain),
+		xmppapi.BindResource(),
+	)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         Config.JID.Domain().String(),
+		InsecureSkipVerify: Config.TLSSkipVerify,
+	}
+}
+
+// start announces the bot as available, finds the upload service and joins the rooms.
+// It is called when the session is already served, because it waits for responses of the server
+func (m *Messenger) start(ctx context.Context, session *xmppapi.Session) {
+	if err := session.Send(ctx, stanza.Presence{}.Wrap(nil)); err != nil {
+		log.Printf("could not send presence: %v", err)
+	}
+	uploadService, err := findUploadService(ctx, session)
+	if err != nil {
+		log.Printf("could not find http upload service, attachments will not be sent: %v", err)
+	}
+	m.sessionMutex.Lock()
+	m.uploadService = uploadService
+	m.sessionMutex.Unlock()
+
+	m.roomMutex.Lock()
+	rooms := make([]string, 0, len(m.rooms))
+	for room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	m.roomMutex.Unlock()
+	for _, room := range rooms {
+		m.join(ctx, jid.MustParse(room))
+	}
+}
+
+// join joins the room with the nick of the bot and remembers it to join it again after reconnecting
+func (m *Messenger) join(ctx context.Context, room jid.JID) {
+	session, mucClient := m.getSession()
+	if session == nil {
+		return
+	}
+	room = room.Bare()
+	address, err := room.WithResource(Config.Nick)
+	if err != nil {
+		log.Printf("invalid address of room %s: %v", room, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, joinTimeout)
+	defer cancel()
+	// history is not requested, old messages should not be transferred again
+	if _, err = mucClient.Join(ctx, address, session, muc.MaxHistory(0)); err != nil {
+		log.Printf("could not join room %s: %v", room, err)
+		return
+	}
+	log.Printf("joined room %s", room)
+	m.roomMutex.Lock()
+	m.rooms[room.String()] = true
+	m.roomMutex.Unlock()
+	m.chats.Add(room.String())
+}
+
+func (m *Messenger) isRoom(address jid.JID) bool {
+	m.roomMutex.Lock()
+	defer m.roomMutex.Unlock()
+	return m.rooms[address.Bare().String()]
+}
+
+func (m *Messenger) setSession(session *xmppapi.Session, mucClient *muc.Client) {
+	m.sessionMutex.Lock()
+	defer m.sessionMutex.Unlock()
+	m.session, m.mucClient = session, mucClient
+	if session == nil {
+		m.uploadService = jid.JID{}
+	}
+}
+
+func (m *Messenger) getSession() (*xmppapi.Session, *muc.Client) {
+	m.sessionMutex.RLock()
+	defer m.sessionMutex.RUnlock()
+	return m.session, m.mucClient
+}
+
+// chat returns the chat of a room or a direct chat with a user, both are identified by bare addresses
+func (m *Messenger) chat(address jid.JID, isRoom bool) *msg.Chat {
+	name, chatAddress := "xmpp", address.Bare().String()
+	if isRoom {
+		name, chatAddress = address.Bare().String(), roomScheme+address.Bare().String()+roomQuery
+	}
+	return &msg.Chat{
+		Id:      m.chats.Add(address.Bare().String()),
+		Type:    "xmpp",
+		Name:    name,
+		Address: chatAddress,
+	}
+}
+
+// destination returns the address messages of the chat are sent to and the type of these messages.
+// Rooms which are not joined yet, e.g. the ones joined on invite before a restart, are joined first
+func (m *Messenger) destination(ctx context.Context, chat *msg.Chat) (jid.JID, stanza.MessageType, error) {
+	address := chat.GetAddress()
+	if address == "" {
+		// the controller has not saved the address of the chat yet
+		address = m.chats.Get(chat.Id)
+	}
+	if address == "" {
+		return jid.JID{}, "", fmt.Errorf("unknown xmpp chat %d", chat.Id)
+	}
+	room, isRoom := strings.CutPrefix(address, roomScheme)
+	if isRoom {
+		address = strings.TrimSuffix(room, roomQuery)
+	}
+	parsed, err := jid.Parse(address)
+	if err != nil {
+		return jid.JID{}, "", err
+	}
+	if isRoom && !m.isRoom(parsed) {
+		m.join(ctx, parsed)
+		if !m.isRoom(parsed) {
+			return jid.JID{}, "", fmt.Errorf("could not join xmpp room %s", parsed)
+		}
+	}
+	if m.isRoom(parsed) {
+		return parsed, stanza.GroupChatMessage, nil
+	}
+	return parsed, stanza.ChatMessage, nil
+}
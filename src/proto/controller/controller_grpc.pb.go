@@ -20,11 +20,27 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	Controller_HandleNewMessage_FullMethodName = "/controller.Controller/HandleNewMessage"
-	Controller_Subscribe_FullMethodName        = "/controller.Controller/Subscribe"
-	Controller_Unsubscribe_FullMethodName      = "/controller.Controller/Unsubscribe"
-	Controller_GetChatToken_FullMethodName     = "/controller.Controller/GetChatToken"
-	Controller_CreateChat_FullMethodName       = "/controller.Controller/CreateChat"
+	Controller_HandleNewMessage_FullMethodName     = "/controller.Controller/HandleNewMessage"
+	Controller_HandleEditedMessage_FullMethodName  = "/controller.Controller/HandleEditedMessage"
+	Controller_HandleDeletedMessage_FullMethodName = "/controller.Controller/HandleDeletedMessage"
+	Controller_Subscribe_FullMethodName            = "/controller.Controller/Subscribe"
+	Controller_Unsubscribe_FullMethodName          = "/controller.Controller/Unsubscribe"
+	Controller_Bridge_FullMethodName               = "/controller.Controller/Bridge"
+	Controller_AddFilter_FullMethodName            = "/controller.Controller/AddFilter"
+	Controller_RemoveFilter_FullMethodName         = "/controller.Controller/RemoveFilter"
+	Controller_ListFilters_FullMethodName          = "/controller.Controller/ListFilters"
+	Controller_SetTemplate_FullMethodName          = "/controller.Controller/SetTemplate"
+	Controller_GetTemplate_FullMethodName          = "/controller.Controller/GetTemplate"
+	Controller_SetPhotoOptions_FullMethodName      = "/controller.Controller/SetPhotoOptions"
+	Controller_GetPhotoOptions_FullMethodName      = "/controller.Controller/GetPhotoOptions"
+	Controller_ListDeadLetters_FullMethodName      = "/controller.Controller/ListDeadLetters"
+	Controller_GetDeadLetter_FullMethodName        = "/controller.Controller/GetDeadLetter"
+	Controller_ReplayDeadLetters_FullMethodName    = "/controller.Controller/ReplayDeadLetters"
+	Controller_PurgeDeadLetters_FullMethodName     = "/controller.Controller/PurgeDeadLetters"
+	Controller_UploadAttachment_FullMethodName     = "/controller.Controller/UploadAttachment"
+	Controller_DownloadAttachment_FullMethodName   = "/controller.Controller/DownloadAttachment"
+	Controller_GetChatToken_FullMethodName         = "/controller.Controller/GetChatToken"
+	Controller_CreateChat_FullMethodName           = "/controller.Controller/CreateChat"
 )
 
 // ControllerClient is the client API for Controller service.
@@ -32,8 +48,26 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type ControllerClient interface {
 	HandleNewMessage(ctx context.Context, in *HandleMessageRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	HandleEditedMessage(ctx context.Context, in *HandleMessageRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	HandleDeletedMessage(ctx context.Context, in *HandleDeletedMessageRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error)
 	Unsubscribe(ctx context.Context, in *UnsubscribeRequest, opts ...grpc.CallOption) (*UnsubscribeResponse, error)
+	Bridge(ctx context.Context, in *BridgeRequest, opts ...grpc.CallOption) (*BridgeResponse, error)
+	AddFilter(ctx context.Context, in *FilterRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	RemoveFilter(ctx context.Context, in *FilterRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	ListFilters(ctx context.Context, in *ListFiltersRequest, opts ...grpc.CallOption) (*ListFiltersResponse, error)
+	SetTemplate(ctx context.Context, in *SetTemplateRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	GetTemplate(ctx context.Context, in *GetTemplateRequest, opts ...grpc.CallOption) (*GetTemplateResponse, error)
+	SetPhotoOptions(ctx context.Context, in *SetPhotoOptionsRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	GetPhotoOptions(ctx context.Context, in *GetPhotoOptionsRequest, opts ...grpc.CallOption) (*GetPhotoOptionsResponse, error)
+	ListDeadLetters(ctx context.Context, in *DeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error)
+	GetDeadLetter(ctx context.Context, in *GetDeadLetterRequest, opts ...grpc.CallOption) (*DeadLetter, error)
+	ReplayDeadLetters(ctx context.Context, in *DeadLettersRequest, opts ...grpc.CallOption) (*DeadLettersResponse, error)
+	PurgeDeadLetters(ctx context.Context, in *DeadLettersRequest, opts ...grpc.CallOption) (*DeadLettersResponse, error)
+	// UploadAttachment stores a file sent in chunks and returns its url, the name is sent in the first chunk
+	UploadAttachment(ctx context.Context, opts ...grpc.CallOption) (Controller_UploadAttachmentClient, error)
+	// DownloadAttachment sends contents of a stored file in chunks
+	DownloadAttachment(ctx context.Context, in *DownloadAttachmentRequest, opts ...grpc.CallOption) (Controller_DownloadAttachmentClient, error)
 	GetChatToken(ctx context.Context, in *GetChatTokenRequest, opts ...grpc.CallOption) (*GetChatTokenResponse, error)
 	CreateChat(ctx context.Context, in *CreateChatRequest, opts ...grpc.CallOption) (*CreateChatResponse, error)
 }
@@ -55,6 +89,24 @@ func (c *controllerClient) HandleNewMessage(ctx context.Context, in *HandleMessa
 	return out, nil
 }
 
+func (c *controllerClient) HandleEditedMessage(ctx context.Context, in *HandleMessageRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, Controller_HandleEditedMessage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) HandleDeletedMessage(ctx context.Context, in *HandleDeletedMessageRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, Controller_HandleDeletedMessage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *controllerClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (*SubscribeResponse, error) {
 	out := new(SubscribeResponse)
 	err := c.cc.Invoke(ctx, Controller_Subscribe_FullMethodName, in, out, opts...)
@@ -73,6 +125,180 @@ func (c *controllerClient) Unsubscribe(ctx context.Context, in *UnsubscribeReque
 	return out, nil
 }
 
+func (c *controllerClient) Bridge(ctx context.Context, in *BridgeRequest, opts ...grpc.CallOption) (*BridgeResponse, error) {
+	out := new(BridgeResponse)
+	err := c.cc.Invoke(ctx, Controller_Bridge_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) AddFilter(ctx context.Context, in *FilterRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, Controller_AddFilter_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) RemoveFilter(ctx context.Context, in *FilterRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, Controller_RemoveFilter_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) ListFilters(ctx context.Context, in *ListFiltersRequest, opts ...grpc.CallOption) (*ListFiltersResponse, error) {
+	out := new(ListFiltersResponse)
+	err := c.cc.Invoke(ctx, Controller_ListFilters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) SetTemplate(ctx context.Context, in *SetTemplateRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, Controller_SetTemplate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) GetTemplate(ctx context.Context, in *GetTemplateRequest, opts ...grpc.CallOption) (*GetTemplateResponse, error) {
+	out := new(GetTemplateResponse)
+	err := c.cc.Invoke(ctx, Controller_GetTemplate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) SetPhotoOptions(ctx context.Context, in *SetPhotoOptionsRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, Controller_SetPhotoOptions_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) GetPhotoOptions(ctx context.Context, in *GetPhotoOptionsRequest, opts ...grpc.CallOption) (*GetPhotoOptionsResponse, error) {
+	out := new(GetPhotoOptionsResponse)
+	err := c.cc.Invoke(ctx, Controller_GetPhotoOptions_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) ListDeadLetters(ctx context.Context, in *DeadLettersRequest, opts ...grpc.CallOption) (*ListDeadLettersResponse, error) {
+	out := new(ListDeadLettersResponse)
+	err := c.cc.Invoke(ctx, Controller_ListDeadLetters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) GetDeadLetter(ctx context.Context, in *GetDeadLetterRequest, opts ...grpc.CallOption) (*DeadLetter, error) {
+	out := new(DeadLetter)
+	err := c.cc.Invoke(ctx, Controller_GetDeadLetter_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) ReplayDeadLetters(ctx context.Context, in *DeadLettersRequest, opts ...grpc.CallOption) (*DeadLettersResponse, error) {
+	out := new(DeadLettersResponse)
+	err := c.cc.Invoke(ctx, Controller_ReplayDeadLetters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) PurgeDeadLetters(ctx context.Context, in *DeadLettersRequest, opts ...grpc.CallOption) (*DeadLettersResponse, error) {
+	out := new(DeadLettersResponse)
+	err := c.cc.Invoke(ctx, Controller_PurgeDeadLetters_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controllerClient) UploadAttachment(ctx context.Context, opts ...grpc.CallOption) (Controller_UploadAttachmentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Controller_ServiceDesc.Streams[0], Controller_UploadAttachment_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &controllerUploadAttachmentClient{stream}
+	return x, nil
+}
+
+type Controller_UploadAttachmentClient interface {
+	Send(*UploadAttachmentRequest) error
+	CloseAndRecv() (*UploadAttachmentResponse, error)
+	grpc.ClientStream
+}
+
+type controllerUploadAttachmentClient struct {
+	grpc.ClientStream
+}
+
+func (x *controllerUploadAttachmentClient) Send(m *UploadAttachmentRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *controllerUploadAttachmentClient) CloseAndRecv() (*UploadAttachmentResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadAttachmentResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *controllerClient) DownloadAttachment(ctx context.Context, in *DownloadAttachmentRequest, opts ...grpc.CallOption) (Controller_DownloadAttachmentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Controller_ServiceDesc.Streams[1], Controller_DownloadAttachment_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &controllerDownloadAttachmentClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Controller_DownloadAttachmentClient interface {
+	Recv() (*DownloadAttachmentResponse, error)
+	grpc.ClientStream
+}
+
+type controllerDownloadAttachmentClient struct {
+	grpc.ClientStream
+}
+
+func (x *controllerDownloadAttachmentClient) Recv() (*DownloadAttachmentResponse, error) {
+	m := new(DownloadAttachmentResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *controllerClient) GetChatToken(ctx context.Context, in *GetChatTokenRequest, opts ...grpc.CallOption) (*GetChatTokenResponse, error) {
 	out := new(GetChatTokenResponse)
 	err := c.cc.Invoke(ctx, Controller_GetChatToken_FullMethodName, in, out, opts...)
@@ -96,8 +322,26 @@ func (c *controllerClient) CreateChat(ctx context.Context, in *CreateChatRequest
 // for forward compatibility
 type ControllerServer interface {
 	HandleNewMessage(context.Context, *HandleMessageRequest) (*empty.Empty, error)
+	HandleEditedMessage(context.Context, *HandleMessageRequest) (*empty.Empty, error)
+	HandleDeletedMessage(context.Context, *HandleDeletedMessageRequest) (*empty.Empty, error)
 	Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error)
 	Unsubscribe(context.Context, *UnsubscribeRequest) (*UnsubscribeResponse, error)
+	Bridge(context.Context, *BridgeRequest) (*BridgeResponse, error)
+	AddFilter(context.Context, *FilterRequest) (*empty.Empty, error)
+	RemoveFilter(context.Context, *FilterRequest) (*empty.Empty, error)
+	ListFilters(context.Context, *ListFiltersRequest) (*ListFiltersResponse, error)
+	SetTemplate(context.Context, *SetTemplateRequest) (*empty.Empty, error)
+	GetTemplate(context.Context, *GetTemplateRequest) (*GetTemplateResponse, error)
+	SetPhotoOptions(context.Context, *SetPhotoOptionsRequest) (*empty.Empty, error)
+	GetPhotoOptions(context.Context, *GetPhotoOptionsRequest) (*GetPhotoOptionsResponse, error)
+	ListDeadLetters(context.Context, *DeadLettersRequest) (*ListDeadLettersResponse, error)
+	GetDeadLetter(context.Context, *GetDeadLetterRequest) (*DeadLetter, error)
+	ReplayDeadLetters(context.Context, *DeadLettersRequest) (*DeadLettersResponse, error)
+	PurgeDeadLetters(context.Context, *DeadLettersRequest) (*DeadLettersResponse, error)
+	// UploadAttachment stores a file sent in chunks and returns its url, the name is sent in the first chunk
+	UploadAttachment(Controller_UploadAttachmentServer) error
+	// DownloadAttachment sends contents of a stored file in chunks
+	DownloadAttachment(*DownloadAttachmentRequest, Controller_DownloadAttachmentServer) error
 	GetChatToken(context.Context, *GetChatTokenRequest) (*GetChatTokenResponse, error)
 	CreateChat(context.Context, *CreateChatRequest) (*CreateChatResponse, error)
 	mustEmbedUnimplementedControllerServer()
@@ -110,12 +354,60 @@ type UnimplementedControllerServer struct {
 func (UnimplementedControllerServer) HandleNewMessage(context.Context, *HandleMessageRequest) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method HandleNewMessage not implemented")
 }
+func (UnimplementedControllerServer) HandleEditedMessage(context.Context, *HandleMessageRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleEditedMessage not implemented")
+}
+func (UnimplementedControllerServer) HandleDeletedMessage(context.Context, *HandleDeletedMessageRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleDeletedMessage not implemented")
+}
 func (UnimplementedControllerServer) Subscribe(context.Context, *SubscribeRequest) (*SubscribeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
 }
 func (UnimplementedControllerServer) Unsubscribe(context.Context, *UnsubscribeRequest) (*UnsubscribeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Unsubscribe not implemented")
 }
+func (UnimplementedControllerServer) Bridge(context.Context, *BridgeRequest) (*BridgeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Bridge not implemented")
+}
+func (UnimplementedControllerServer) AddFilter(context.Context, *FilterRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddFilter not implemented")
+}
+func (UnimplementedControllerServer) RemoveFilter(context.Context, *FilterRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveFilter not implemented")
+}
+func (UnimplementedControllerServer) ListFilters(context.Context, *ListFiltersRequest) (*ListFiltersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFilters not implemented")
+}
+func (UnimplementedControllerServer) SetTemplate(context.Context, *SetTemplateRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetTemplate not implemented")
+}
+func (UnimplementedControllerServer) GetTemplate(context.Context, *GetTemplateRequest) (*GetTemplateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTemplate not implemented")
+}
+func (UnimplementedControllerServer) SetPhotoOptions(context.Context, *SetPhotoOptionsRequest) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPhotoOptions not implemented")
+}
+func (UnimplementedControllerServer) GetPhotoOptions(context.Context, *GetPhotoOptionsRequest) (*GetPhotoOptionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPhotoOptions not implemented")
+}
+func (UnimplementedControllerServer) ListDeadLetters(context.Context, *DeadLettersRequest) (*ListDeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDeadLetters not implemented")
+}
+func (UnimplementedControllerServer) GetDeadLetter(context.Context, *GetDeadLetterRequest) (*DeadLetter, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeadLetter not implemented")
+}
+func (UnimplementedControllerServer) ReplayDeadLetters(context.Context, *DeadLettersRequest) (*DeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplayDeadLetters not implemented")
+}
+func (UnimplementedControllerServer) PurgeDeadLetters(context.Context, *DeadLettersRequest) (*DeadLettersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeDeadLetters not implemented")
+}
+func (UnimplementedControllerServer) UploadAttachment(Controller_UploadAttachmentServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadAttachment not implemented")
+}
+func (UnimplementedControllerServer) DownloadAttachment(*DownloadAttachmentRequest, Controller_DownloadAttachmentServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadAttachment not implemented")
+}
 func (UnimplementedControllerServer) GetChatToken(context.Context, *GetChatTokenRequest) (*GetChatTokenResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetChatToken not implemented")
 }
@@ -153,6 +445,42 @@ func _Controller_HandleNewMessage_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Controller_HandleEditedMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HandleMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).HandleEditedMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_HandleEditedMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).HandleEditedMessage(ctx, req.(*HandleMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_HandleDeletedMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HandleDeletedMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).HandleDeletedMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_HandleDeletedMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).HandleDeletedMessage(ctx, req.(*HandleDeletedMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Controller_Subscribe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(SubscribeRequest)
 	if err := dec(in); err != nil {
@@ -189,6 +517,269 @@ func _Controller_Unsubscribe_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Controller_Bridge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BridgeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).Bridge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_Bridge_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).Bridge(ctx, req.(*BridgeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_AddFilter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FilterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).AddFilter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_AddFilter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).AddFilter(ctx, req.(*FilterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_RemoveFilter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FilterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).RemoveFilter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_RemoveFilter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).RemoveFilter(ctx, req.(*FilterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_ListFilters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFiltersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).ListFilters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_ListFilters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).ListFilters(ctx, req.(*ListFiltersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_SetTemplate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTemplateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).SetTemplate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_SetTemplate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).SetTemplate(ctx, req.(*SetTemplateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_GetTemplate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTemplateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).GetTemplate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_GetTemplate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).GetTemplate(ctx, req.(*GetTemplateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_SetPhotoOptions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPhotoOptionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).SetPhotoOptions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_SetPhotoOptions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).SetPhotoOptions(ctx, req.(*SetPhotoOptionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_GetPhotoOptions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPhotoOptionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).GetPhotoOptions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_GetPhotoOptions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).GetPhotoOptions(ctx, req.(*GetPhotoOptionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_ListDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).ListDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_ListDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).ListDeadLetters(ctx, req.(*DeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_GetDeadLetter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDeadLetterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).GetDeadLetter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_GetDeadLetter_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).GetDeadLetter(ctx, req.(*GetDeadLetterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_ReplayDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).ReplayDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_ReplayDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).ReplayDeadLetters(ctx, req.(*DeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_PurgeDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeadLettersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControllerServer).PurgeDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Controller_PurgeDeadLetters_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControllerServer).PurgeDeadLetters(ctx, req.(*DeadLettersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Controller_UploadAttachment_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ControllerServer).UploadAttachment(&controllerUploadAttachmentServer{stream})
+}
+
+type Controller_UploadAttachmentServer interface {
+	SendAndClose(*UploadAttachmentResponse) error
+	Recv() (*UploadAttachmentRequest, error)
+	grpc.ServerStream
+}
+
+type controllerUploadAttachmentServer struct {
+	grpc.ServerStream
+}
+
+func (x *controllerUploadAttachmentServer) SendAndClose(m *UploadAttachmentResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *controllerUploadAttachmentServer) Recv() (*UploadAttachmentRequest, error) {
+	m := new(UploadAttachmentRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Controller_DownloadAttachment_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadAttachmentRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControllerServer).DownloadAttachment(m, &controllerDownloadAttachmentServer{stream})
+}
+
+type Controller_DownloadAttachmentServer interface {
+	Send(*DownloadAttachmentResponse) error
+	grpc.ServerStream
+}
+
+type controllerDownloadAttachmentServer struct {
+	grpc.ServerStream
+}
+
+func (x *controllerDownloadAttachmentServer) Send(m *DownloadAttachmentResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Controller_GetChatToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetChatTokenRequest)
 	if err := dec(in); err != nil {
@@ -236,6 +827,14 @@ var Controller_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "HandleNewMessage",
 			Handler:    _Controller_HandleNewMessage_Handler,
 		},
+		{
+			MethodName: "HandleEditedMessage",
+			Handler:    _Controller_HandleEditedMessage_Handler,
+		},
+		{
+			MethodName: "HandleDeletedMessage",
+			Handler:    _Controller_HandleDeletedMessage_Handler,
+		},
 		{
 			MethodName: "Subscribe",
 			Handler:    _Controller_Subscribe_Handler,
@@ -244,6 +843,54 @@ var Controller_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Unsubscribe",
 			Handler:    _Controller_Unsubscribe_Handler,
 		},
+		{
+			MethodName: "Bridge",
+			Handler:    _Controller_Bridge_Handler,
+		},
+		{
+			MethodName: "AddFilter",
+			Handler:    _Controller_AddFilter_Handler,
+		},
+		{
+			MethodName: "RemoveFilter",
+			Handler:    _Controller_RemoveFilter_Handler,
+		},
+		{
+			MethodName: "ListFilters",
+			Handler:    _Controller_ListFilters_Handler,
+		},
+		{
+			MethodName: "SetTemplate",
+			Handler:    _Controller_SetTemplate_Handler,
+		},
+		{
+			MethodName: "GetTemplate",
+			Handler:    _Controller_GetTemplate_Handler,
+		},
+		{
+			MethodName: "SetPhotoOptions",
+			Handler:    _Controller_SetPhotoOptions_Handler,
+		},
+		{
+			MethodName: "GetPhotoOptions",
+			Handler:    _Controller_GetPhotoOptions_Handler,
+		},
+		{
+			MethodName: "ListDeadLetters",
+			Handler:    _Controller_ListDeadLetters_Handler,
+		},
+		{
+			MethodName: "GetDeadLetter",
+			Handler:    _Controller_GetDeadLetter_Handler,
+		},
+		{
+			MethodName: "ReplayDeadLetters",
+			Handler:    _Controller_ReplayDeadLetters_Handler,
+		},
+		{
+			MethodName: "PurgeDeadLetters",
+			Handler:    _Controller_PurgeDeadLetters_Handler,
+		},
 		{
 			MethodName: "GetChatToken",
 			Handler:    _Controller_GetChatToken_Handler,
@@ -253,6 +900,17 @@ var Controller_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _Controller_CreateChat_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadAttachment",
+			Handler:       _Controller_UploadAttachment_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DownloadAttachment",
+			Handler:       _Controller_DownloadAttachment_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "controller.proto",
 }